@@ -3,27 +3,58 @@ package batch
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 )
 
 type Codec interface {
-	Pack(batch any) (PackedBatch, error)
-	Unpack(p PackedBatch) (any, error)
+	Pack(batch *Batch) (PackedBatch, error)
+	Unpack(p PackedBatch) (*Batch, error)
 }
 
+// packedMagic starts every packed batch, followed by a version byte. No
+// gob stream starts with a zero byte, so batches packed before the header
+// was added, plain gob streams, are read as version 0.
+var packedMagic = []byte{0, 'P', 'X'}
+
+// packedVersion is the version Pack writes. Version 1 is the header
+// followed by the gob encoding of the batch, as in version 0.
+const packedVersion = 1
+
+var errTruncatedHeader = errors.New("batch: truncated header")
+
 type gobCodec struct{}
 
 func NewCodec() Codec {
 	return &gobCodec{}
 }
 
-func (c *gobCodec) Pack(b any) (PackedBatch, error) {
+func (c *gobCodec) Pack(b *Batch) (PackedBatch, error) {
+	if b == nil {
+		b = &Batch{}
+	}
 	var buf bytes.Buffer
+	buf.Write(packedMagic)
+	buf.WriteByte(packedVersion)
 	err := gob.NewEncoder(&buf).Encode(b)
 	return buf.Bytes(), err
 }
 
-func (c *gobCodec) Unpack(p PackedBatch) (any, error) {
-	var b any
-	err := gob.NewDecoder(bytes.NewReader(p)).Decode(&b)
-	return b, err
+// Unpack decodes p into the storage of a released batch, if there is one.
+// It reads every version Pack has written.
+func (c *gobCodec) Unpack(p PackedBatch) (*Batch, error) {
+	if body, ok := bytes.CutPrefix(p, packedMagic); ok {
+		if len(body) == 0 {
+			return nil, errTruncatedHeader
+		}
+		if body[0] != packedVersion {
+			return nil, fmt.Errorf("batch: unknown packed version %d", body[0])
+		}
+		p = body[1:]
+	}
+	b := get()
+	if err := gob.NewDecoder(bytes.NewReader(p)).Decode(b); err != nil {
+		return nil, err
+	}
+	return b, nil
 }
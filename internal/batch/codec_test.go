@@ -0,0 +1,90 @@
+package batch
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+)
+
+// equalBatches reports whether a and b hold the same records and
+// metadata, nil and empty alike.
+func equalBatches(a, b *Batch) bool {
+	if a.Len() != b.Len() || !maps.Equal(a.Metadata, b.Metadata) {
+		return false
+	}
+	for i := range a.Records {
+		ra, rb := a.Records[i], b.Records[i]
+		if !bytes.Equal(ra.Payload, rb.Payload) || !maps.Equal(ra.Metadata, rb.Metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// FuzzUnpackBatch feeds Unpack arbitrary wire data, as an engine could
+// send: it must fail or return a batch that packs back to itself.
+func FuzzUnpackBatch(f *testing.F) {
+	c := NewCodec()
+	for _, b := range []*Batch{
+		nil,
+		{Metadata: map[string]string{"x-planx-barrier": "cp-1"}},
+		{Records: []Record{{Payload: []byte("a")}, {Payload: []byte("bc"), Metadata: map[string]string{"k": "v"}}}},
+	} {
+		p, err := c.Pack(b)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(p)
+		f.Add(p[:len(p)/2])
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		b, err := c.Unpack(data)
+		if err != nil {
+			return
+		}
+		p, err := c.Pack(b)
+		if err != nil {
+			t.Fatalf("Pack of an unpacked batch: %v", err)
+		}
+		again, err := c.Unpack(p)
+		if err != nil {
+			t.Fatalf("Unpack of a repacked batch: %v", err)
+		}
+		if !equalBatches(b, again) {
+			t.Fatalf("repacked batch differs: %+v, then %+v", b, again)
+		}
+	})
+}
+
+// FuzzPackUnpackRoundTrip checks that every batch Unpack reads back the
+// way Pack wrote it.
+func FuzzPackUnpackRoundTrip(f *testing.F) {
+	f.Add([]byte("payload"), "k", "v", uint8(1))
+	f.Add([]byte{}, "", "", uint8(0))
+	f.Add([]byte{0, 0xff, '\n'}, "x-planx-checkpoint-id", "cp-42", uint8(3))
+
+	c := NewCodec()
+	f.Fuzz(func(t *testing.T, payload []byte, key, value string, records uint8) {
+		b := &Batch{Metadata: map[string]string{key: value}}
+		for i := range int(records % 16) {
+			r := Record{Payload: payload[:len(payload)*i/16]}
+			if i%2 == 1 {
+				r.Metadata = map[string]string{key: value}
+			}
+			b.Records = append(b.Records, r)
+		}
+		p, err := c.Pack(b)
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		got, err := c.Unpack(p)
+		if err != nil {
+			t.Fatalf("Unpack: %v", err)
+		}
+		if !equalBatches(b, got) {
+			t.Fatalf("round trip of %+v gave %+v", b, got)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package batch
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the current packed version")
+
+// goldenBatches are packed under testdata/golden/v<version>/<name>.bin.
+// Gob writes map entries in iteration order, so no map holds more than
+// one key and the encodings stay byte-stable.
+var goldenBatches = []struct {
+	name  string
+	batch *Batch
+}{
+	{"empty", &Batch{}},
+	{"barrier", &Batch{Metadata: map[string]string{"x-planx-barrier": "cp-1"}}},
+	{"records", &Batch{
+		Metadata: map[string]string{"x-planx-checkpoint-id": "cp-42"},
+		Records: []Record{
+			{Payload: []byte("a")},
+			{Payload: []byte{0, 0xff, '\n'}, Metadata: map[string]string{"k": "v"}},
+			{},
+		},
+	}},
+}
+
+func goldenPath(version int, name string) string {
+	return filepath.Join("testdata", "golden", fmt.Sprintf("v%d", version), name+".bin")
+}
+
+// TestPackGolden checks that Pack writes the current version byte for
+// byte, so engines reading it keep doing so.
+func TestPackGolden(t *testing.T) {
+	c := NewCodec()
+	for _, g := range goldenBatches {
+		p, err := c.Pack(g.batch)
+		if err != nil {
+			t.Fatalf("%s: Pack: %v", g.name, err)
+		}
+		path := goldenPath(packedVersion, g.name)
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, p, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, want) {
+			t.Errorf("%s: Pack wrote %x, golden %s is %x", g.name, p, path, want)
+		}
+	}
+}
+
+// TestUnpackGolden checks that Unpack reads every version ever written.
+func TestUnpackGolden(t *testing.T) {
+	c := NewCodec()
+	for v := 0; v <= packedVersion; v++ {
+		for _, g := range goldenBatches {
+			path := goldenPath(v, g.name)
+			p, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.Unpack(p)
+			if err != nil {
+				t.Errorf("%s: Unpack: %v", path, err)
+				continue
+			}
+			if !equalBatches(b, g.batch) {
+				t.Errorf("%s: unpacked %+v, want %+v", path, b, g.batch)
+			}
+		}
+	}
+}
+
+func TestUnpackUnknownVersion(t *testing.T) {
+	c := NewCodec()
+	p, err := c.Pack(&Batch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p[len(packedMagic)] = packedVersion + 1
+	if _, err := c.Unpack(p); err == nil {
+		t.Fatal("Unpack accepted an unknown version")
+	}
+	if _, err := c.Unpack(packedMagic); err == nil {
+		t.Fatal("Unpack accepted a header without a version")
+	}
+}
@@ -0,0 +1,97 @@
+package batch
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledRecords bounds the records of a batch kept for reuse, so one
+// huge batch does not pin its storage for good.
+const maxPooledRecords = 1 << 16
+
+// The states of a batch, as bits of Batch.state.
+const (
+	// held marks a batch the SDK is still using: a release waits for it.
+	held int32 = 1 << iota
+	// owned marks a batch from Get, which the SDK releases once done.
+	owned
+	released
+)
+
+var pool = sync.Pool{New: func() any { return new(Batch) }}
+
+// Get returns an empty batch, reusing the records, payloads and metadata
+// maps of a released one. Its metadata may be empty rather than nil.
+// The SDK releases it once done with it; see Done.
+func Get() *Batch {
+	b := pool.Get().(*Batch)
+	atomic.StoreInt32(&b.state, owned)
+	return b
+}
+
+// get returns an empty batch to decode into.
+func get() *Batch {
+	b := pool.Get().(*Batch)
+	atomic.StoreInt32(&b.state, 0)
+	return b
+}
+
+// Release hands b back for its storage to be reused. Neither b nor its
+// records, their payloads or metadata may be used afterwards. A batch
+// the SDK still holds goes back once the SDK is done with it; releasing
+// a batch twice does nothing.
+func Release(b *Batch) {
+	transition(b, func(s int32) int32 { return s | released })
+}
+
+// Hold marks b as in use by the SDK until Done.
+func Hold(b *Batch) {
+	transition(b, func(s int32) int32 { return s | held })
+}
+
+// Done tells that the SDK is done with b, which goes back to the pool if
+// it was released meanwhile or came from Get.
+func Done(b *Batch) {
+	transition(b, func(s int32) int32 {
+		s &^= held
+		if s&owned != 0 {
+			s = s&^owned | released
+		}
+		return s
+	})
+}
+
+func transition(b *Batch, next func(int32) int32) {
+	if b == nil {
+		return
+	}
+	for {
+		s := atomic.LoadInt32(&b.state)
+		n := next(s)
+		if !atomic.CompareAndSwapInt32(&b.state, s, n) {
+			continue
+		}
+		if free(n) && !free(s) {
+			put(b)
+		}
+		return
+	}
+}
+
+func free(s int32) bool {
+	return s&released != 0 && s&held == 0
+}
+
+func put(b *Batch) {
+	if cap(b.Records) > maxPooledRecords {
+		return
+	}
+	clear(b.Metadata)
+	recs := b.Records[:cap(b.Records)]
+	for i := range recs {
+		recs[i].Payload = recs[i].Payload[:0]
+		clear(recs[i].Metadata)
+	}
+	b.Records = recs[:0]
+	pool.Put(b)
+}
@@ -1,3 +1,26 @@
 package batch
 
 type PackedBatch = []byte
+
+// Record is a single opaque payload plus string metadata. The SDK never
+// interprets Payload.
+type Record struct {
+	Payload  []byte
+	Metadata map[string]string
+}
+
+// Batch is the unit of data exchanged between SPIs and the engine.
+type Batch struct {
+	Records  []Record
+	Metadata map[string]string
+
+	// state tracks the batch through the pool; see Release.
+	state int32
+}
+
+func (b *Batch) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.Records)
+}
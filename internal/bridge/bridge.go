@@ -0,0 +1,40 @@
+// Package bridge gives in-module packages such as sdk/plugintest the
+// runtime form of a *sdk.Plugin without widening the public sdk API.
+package bridge
+
+import (
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"google.golang.org/grpc"
+)
+
+// Connectors are the SPI factories of a plugin, by connector name.
+type Connectors struct {
+	Sources    map[string]func() runtime.SourceSPI
+	Sinks      map[string]func() runtime.SinkSPI
+	Processors map[string]func() runtime.ProcessorSPI
+}
+
+// Servers returns a server for every role that has connectors.
+func (c Connectors) Servers(proc *runtime.Process) runtime.Servers {
+	var s runtime.Servers
+	if len(c.Sources) > 0 {
+		s.Source = runtime.NewSourceServer(proc, c.Sources)
+	}
+	if len(c.Sinks) > 0 {
+		s.Sink = runtime.NewSinkServer(proc, c.Sinks)
+	}
+	if len(c.Processors) > 0 {
+		s.Processor = runtime.NewProcessorServer(proc, c.Processors)
+	}
+	return s
+}
+
+// Register returns a func adding a server for every role that has
+// connectors to a gRPC server.
+func (c Connectors) Register(proc *runtime.Process) func(*grpc.Server) {
+	return c.Servers(proc).Register
+}
+
+// Plugin is set by package sdk. It returns the connectors of a
+// *sdk.Plugin and the process options Run would use.
+var Plugin func(p any) (Connectors, runtime.Options)
@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"context"
+	"io"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SourceStream is an in-memory server side of the source OpenStream
+// call. Sent batches are delivered to out until ctx is done.
+type SourceStream struct {
+	ctx context.Context
+	out chan<- *pb.Batch
+}
+
+var _ grpc.ServerStreamingServer[pb.Batch] = (*SourceStream)(nil)
+
+func NewSourceStream(ctx context.Context, out chan<- *pb.Batch) *SourceStream {
+	return &SourceStream{ctx: ctx, out: out}
+}
+
+func (s *SourceStream) Send(b *pb.Batch) error {
+	select {
+	case s.out <- b:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *SourceStream) Context() context.Context     { return s.ctx }
+func (s *SourceStream) SetHeader(metadata.MD) error  { return nil }
+func (s *SourceStream) SendHeader(metadata.MD) error { return nil }
+func (s *SourceStream) SetTrailer(metadata.MD)       {}
+func (s *SourceStream) SendMsg(m any) error          { return s.Send(m.(*pb.Batch)) }
+func (s *SourceStream) RecvMsg(any) error            { return io.EOF }
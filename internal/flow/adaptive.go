@@ -0,0 +1,136 @@
+package flow
+
+import (
+	"sync"
+	"time"
+)
+
+// Adaptive caps the number of unacknowledged batches below the engine
+// window using AIMD: the limit grows by about one batch per round trip
+// while acks arrive promptly, and halves when ack latency spikes above
+// SpikeFactor times its moving average or when an error is reported.
+type Adaptive struct {
+	mu      sync.Mutex
+	changed signal
+
+	min, max    float64
+	limit       float64
+	spikeFactor float64
+
+	inflight []time.Time
+	avg      time.Duration
+}
+
+const (
+	defaultSpikeFactor = 2.0
+	avgWeight          = 0.125
+)
+
+func NewAdaptive(min, max int) *Adaptive {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &Adaptive{
+		min:         float64(min),
+		max:         float64(max),
+		limit:       float64(min),
+		spikeFactor: defaultSpikeFactor,
+	}
+}
+
+// Acquire blocks until another batch may be sent and records its send
+// time.
+func (a *Adaptive) Acquire() {
+	a.AcquireTimeout(-1)
+}
+
+// AcquireTimeout is Acquire bounded by d; a negative d waits forever.
+func (a *Adaptive) AcquireTimeout(d time.Duration) bool {
+	var timeout <-chan time.Time
+	if d >= 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for float64(len(a.inflight)) >= a.limit {
+		changed := a.changed.wait()
+		a.mu.Unlock()
+		select {
+		case <-changed:
+			a.mu.Lock()
+		case <-timeout:
+			a.mu.Lock()
+			return false
+		}
+	}
+	a.inflight = append(a.inflight, time.Now())
+	return true
+}
+
+// Ack marks the n oldest in-flight batches as acknowledged and adjusts
+// the limit from the observed latency.
+func (a *Adaptive) Ack(n int) {
+	a.mu.Lock()
+	if n > len(a.inflight) {
+		n = len(a.inflight)
+	}
+	if n > 0 {
+		latency := time.Since(a.inflight[n-1])
+		a.inflight = a.inflight[n:]
+
+		if a.avg > 0 && float64(latency) > a.spikeFactor*float64(a.avg) {
+			a.decrease()
+		} else {
+			a.limit += float64(n) / a.limit
+			if a.limit > a.max {
+				a.limit = a.max
+			}
+		}
+
+		if a.avg == 0 {
+			a.avg = latency
+		} else {
+			a.avg += time.Duration(avgWeight * float64(latency-a.avg))
+		}
+	}
+	a.changed.broadcast()
+	a.mu.Unlock()
+}
+
+// Release gives back the room of the batch acquired last, which was not
+// sent, without adjusting the limit.
+func (a *Adaptive) Release() {
+	a.mu.Lock()
+	if n := len(a.inflight); n > 0 {
+		a.inflight = a.inflight[:n-1]
+	}
+	a.changed.broadcast()
+	a.mu.Unlock()
+}
+
+// Fail reports a send or processing error and shrinks the limit.
+func (a *Adaptive) Fail() {
+	a.mu.Lock()
+	a.decrease()
+	a.mu.Unlock()
+}
+
+// Limit returns the current effective window.
+func (a *Adaptive) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+func (a *Adaptive) decrease() {
+	a.limit /= 2
+	if a.limit < a.min {
+		a.limit = a.min
+	}
+}
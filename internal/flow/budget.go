@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"context"
+	"sync"
+)
+
+// Limit is a weighted semaphore. A nil *Limit is unlimited.
+type Limit struct {
+	mu      sync.Mutex
+	changed signal
+	max     int64
+	used    int64
+}
+
+func NewLimit(max int64) *Limit {
+	if max <= 0 {
+		return nil
+	}
+	return &Limit{max: max}
+}
+
+// Acquire reserves n units, waiting until ctx is done. Requests larger
+// than the limit are clamped to it so they can still proceed alone.
+// It returns the amount actually reserved, to be passed to Release.
+func (l *Limit) Acquire(ctx context.Context, n int64) (int64, error) {
+	if l == nil {
+		return 0, nil
+	}
+	if n > l.max {
+		n = l.max
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.used+n > l.max {
+		changed := l.changed.wait()
+		l.mu.Unlock()
+		select {
+		case <-changed:
+			l.mu.Lock()
+		case <-ctx.Done():
+			l.mu.Lock()
+			return 0, ctx.Err()
+		}
+	}
+	l.used += n
+	return n, nil
+}
+
+func (l *Limit) Release(n int64) {
+	if l == nil || n == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.used -= n
+	l.changed.broadcast()
+	l.mu.Unlock()
+}
+
+// Used reports reserved and maximum units.
+func (l *Limit) Used() (used, max int64) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.used, l.max
+}
+
+// Budget bounds resources shared by all sessions of a plugin process.
+type Budget struct {
+	Batches *Limit
+	Bytes   *Limit
+	Calls   *Limit
+}
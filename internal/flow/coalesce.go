@@ -0,0 +1,62 @@
+package flow
+
+import (
+	"sync"
+	"time"
+)
+
+// Coalescer merges credit updates that arrive within interval into a
+// single call to apply, so a burst of small acks wakes the sender once.
+// A zero interval applies every update immediately.
+type Coalescer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	apply    func(n int)
+	pending  int
+	timer    *time.Timer
+}
+
+func NewCoalescer(interval time.Duration, apply func(n int)) *Coalescer {
+	return &Coalescer{interval: interval, apply: apply}
+}
+
+func (c *Coalescer) Add(n int) {
+	if c.interval <= 0 {
+		c.apply(n)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending += n
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.interval, c.Flush)
+	}
+}
+
+// Flush applies pending credits now.
+func (c *Coalescer) Flush() {
+	c.mu.Lock()
+	n := c.pending
+	c.pending = 0
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+
+	if n != 0 {
+		c.apply(n)
+	}
+}
+
+// Stop discards pending credits and cancels the timer.
+func (c *Coalescer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = 0
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
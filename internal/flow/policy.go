@@ -0,0 +1,206 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy decides when a session may move the next batch. Release is
+// called with the credits granted by engine acks and Reset with the
+// credits available after a stream is (re)opened; policies that are not
+// credit based ignore both.
+type Policy interface {
+	TryAcquire() bool
+	AcquireTimeout(d time.Duration) bool
+	AcquireContext(ctx context.Context) error
+	Release(n int)
+	Reset(credits int)
+	Stats() Stats
+}
+
+const (
+	PolicyCredit      = "credit"
+	PolicyTokenBucket = "token_bucket"
+	PolicyLeakyBucket = "leaky_bucket"
+	PolicyUnlimited   = "unlimited"
+)
+
+// ParsePolicy builds a Policy from a spec of the form name?key=value&...:
+//
+//	credit                          engine credit window (initial credits)
+//	token_bucket?rate=100&burst=20  rate batches/s with bursts up to burst
+//	leaky_bucket?rate=100           a steady rate batches/s, no bursts
+//	unlimited                       no flow control
+func ParsePolicy(spec string, initial int) (Policy, error) {
+	name, query, _ := strings.Cut(strings.TrimSpace(spec), "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("flow policy %q: %w", spec, err)
+	}
+
+	num := func(key string, def float64) (float64, error) {
+		v := params.Get(key)
+		if v == "" {
+			return def, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return 0, fmt.Errorf("flow policy %q: invalid %s", spec, key)
+		}
+		return f, nil
+	}
+
+	switch name {
+	case "", PolicyCredit:
+		return NewWindow(initial), nil
+	case PolicyUnlimited:
+		return Unlimited{}, nil
+	case PolicyTokenBucket, PolicyLeakyBucket:
+		rate, err := num("rate", 0)
+		if err != nil {
+			return nil, err
+		}
+		if rate == 0 {
+			return nil, fmt.Errorf("flow policy %q: rate is required", spec)
+		}
+		if name == PolicyLeakyBucket {
+			return NewTokenBucket(rate, 1), nil
+		}
+		burst, err := num("burst", math.Max(1, rate))
+		if err != nil {
+			return nil, err
+		}
+		return NewTokenBucket(rate, int(burst)), nil
+	default:
+		return nil, fmt.Errorf("unknown flow policy %q", name)
+	}
+}
+
+// Unlimited is a Policy that never blocks.
+type Unlimited struct{}
+
+func (Unlimited) TryAcquire() bool                     { return true }
+func (Unlimited) AcquireTimeout(time.Duration) bool    { return true }
+func (Unlimited) AcquireContext(context.Context) error { return nil }
+func (Unlimited) Release(int)                          {}
+func (Unlimited) Reset(int)                            {}
+func (Unlimited) Stats() Stats                         { return Stats{Credits: math.MaxInt} }
+
+// TokenBucket admits rate batches per second with bursts up to burst.
+// With a burst of 1 it behaves as a leaky bucket.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	stats  Stats
+	// stalled is as in Window.
+	stalled bool
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *TokenBucket) TryAcquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reserve(time.Now()) > 0 {
+		return false
+	}
+	b.take()
+	return true
+}
+
+func (b *TokenBucket) AcquireTimeout(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	return b.acquire(t.C, nil)
+}
+
+func (b *TokenBucket) AcquireContext(ctx context.Context) error {
+	if !b.acquire(nil, ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (b *TokenBucket) acquire(timeout <-chan time.Time, done <-chan struct{}) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := time.Now()
+	wait := b.reserve(start)
+	if wait > 0 {
+		b.stalled = true
+		defer func() { b.stats.WaitTime += time.Since(start) }()
+	}
+	for wait > 0 {
+		b.mu.Unlock()
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+			b.mu.Lock()
+		case <-timeout:
+			t.Stop()
+			b.mu.Lock()
+			return false
+		case <-done:
+			t.Stop()
+			b.mu.Lock()
+			b.stalled = false
+			return false
+		}
+		wait = b.reserve(time.Now())
+	}
+
+	b.take()
+	return true
+}
+
+func (b *TokenBucket) take() {
+	b.tokens--
+	b.stats.Acquires++
+	if b.stalled {
+		b.stats.Stalls++
+		b.stalled = false
+	}
+}
+
+// reserve refills the bucket and returns how long until a token is
+// available.
+func (b *TokenBucket) reserve(now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) Release(int) {}
+func (b *TokenBucket) Reset(int)   {}
+
+func (b *TokenBucket) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserve(time.Now())
+	s := b.stats
+	s.Credits = int(b.tokens)
+	return s
+}
@@ -0,0 +1,22 @@
+package flow
+
+// signal is a broadcast notification that, unlike sync.Cond, can be
+// waited on in a select. Callers hold their own mutex around wait and
+// broadcast.
+type signal struct {
+	ch chan struct{}
+}
+
+func (s *signal) wait() <-chan struct{} {
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *signal) broadcast() {
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
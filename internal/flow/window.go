@@ -1,31 +1,147 @@
 package flow
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 type Window struct {
-	mu    sync.Mutex
-	cond  *sync.Cond
-	value int
+	mu      sync.Mutex
+	changed signal
+	value   int
+	stats   Stats
+	// stalled is set while an acquire waits, and after one timed out,
+	// until a credit is taken.
+	stalled bool
+}
+
+// Stats describes the flow-control history of a Window.
+type Stats struct {
+	// Credits is the number of batches that may be sent right now.
+	Credits int
+	// Acquires counts granted Acquire calls; Stalls counts those that
+	// had to wait for credit, once however many timed-out waits came
+	// before, and WaitTime is the total time waited.
+	Acquires int64
+	Stalls   int64
+	WaitTime time.Duration
+	// Releases counts credit grants. ReleaseInterval is the moving
+	// average time between them.
+	Releases        int64
+	ReleaseInterval time.Duration
+	LastRelease     time.Time
 }
 
 func NewWindow(init int) *Window {
-	w := &Window{value: init}
-	w.cond = sync.NewCond(&w.mu)
-	return w
+	return &Window{value: init}
 }
 
+// Acquire blocks until a credit is available and takes it.
 func (w *Window) Acquire() {
+	w.acquire(nil, nil)
+}
+
+// TryAcquire takes a credit if one is available without blocking.
+func (w *Window) TryAcquire() bool {
 	w.mu.Lock()
-	for w.value <= 0 {
-		w.cond.Wait()
+	defer w.mu.Unlock()
+	if w.value <= 0 {
+		return false
+	}
+	w.take()
+	return true
+}
+
+// AcquireTimeout waits at most d for a credit and reports whether one
+// was taken.
+func (w *Window) AcquireTimeout(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	return w.acquire(t.C, nil)
+}
+
+// AcquireContext waits for a credit until ctx is done.
+func (w *Window) AcquireContext(ctx context.Context) error {
+	if !w.acquire(nil, ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (w *Window) acquire(timeout <-chan time.Time, done <-chan struct{}) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.value <= 0 {
+		start := time.Now()
+		w.stalled = true
+		defer func() { w.stats.WaitTime += time.Since(start) }()
+
+		for w.value <= 0 {
+			changed := w.changed.wait()
+			w.mu.Unlock()
+			select {
+			case <-changed:
+				w.mu.Lock()
+			case <-timeout:
+				w.mu.Lock()
+				return false
+			case <-done:
+				w.mu.Lock()
+				w.stalled = false
+				return false
+			}
+		}
 	}
+
+	w.take()
+	return true
+}
+
+// take takes a credit, counting a stall if the caller waited for it.
+func (w *Window) take() {
 	w.value--
-	w.mu.Unlock()
+	w.stats.Acquires++
+	if w.stalled {
+		w.stats.Stalls++
+		w.stalled = false
+	}
 }
 
 func (w *Window) Release(n int) {
 	w.mu.Lock()
 	w.value += n
+	w.recordRelease(time.Now())
+	w.changed.broadcast()
 	w.mu.Unlock()
-	w.cond.Broadcast()
+}
+
+// Reset sets the available credits, discarding the previous balance.
+func (w *Window) Reset(credits int) {
+	w.mu.Lock()
+	w.value = credits
+	w.changed.broadcast()
+	w.mu.Unlock()
+}
+
+func (w *Window) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.stats
+	s.Credits = w.value
+	return s
+}
+
+func (w *Window) recordRelease(now time.Time) {
+	if !w.stats.LastRelease.IsZero() {
+		d := now.Sub(w.stats.LastRelease)
+		if w.stats.ReleaseInterval == 0 {
+			w.stats.ReleaseInterval = d
+		} else {
+			w.stats.ReleaseInterval += time.Duration(avgWeight * float64(d-w.stats.ReleaseInterval))
+		}
+	}
+	w.stats.LastRelease = now
+	w.stats.Releases++
 }
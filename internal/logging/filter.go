@@ -0,0 +1,241 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+)
+
+// Redacted replaces the value of redacted keys.
+const Redacted = "[REDACTED]"
+
+// Options configures a Filter.
+type Options struct {
+	// Level, when set, drops messages below it. It can only make a
+	// logger quieter than its own configuration.
+	Level *slog.LevelVar
+
+	// SampleFirst messages with the same level and text are logged per
+	// SampleInterval, then only every SampleThereafter-th one. Zero
+	// disables sampling. Errors are never sampled.
+	SampleFirst      int
+	SampleThereafter int
+	SampleInterval   time.Duration
+
+	// RedactKeys are matched case-insensitively against log keys and the
+	// keys of map values, e.g. record metadata.
+	RedactKeys []string
+	// Redact, when set, rewrites every logged value, including map
+	// entries, after RedactKeys is applied.
+	Redact func(key string, value any) any
+	// Secrets, when set, are scrubbed from messages and string and
+	// error values.
+	Secrets *Secrets
+}
+
+// Filter samples and redacts the log output of one session. A nil
+// Filter only strips payloads, see Redact.
+type Filter struct {
+	level      *slog.LevelVar
+	first      int
+	thereafter int
+	interval   time.Duration
+	redact     map[string]bool
+	fn         func(key string, value any) any
+	secrets    *Secrets
+
+	mu      sync.Mutex
+	resetAt time.Time
+	counts  map[sampleKey]int
+}
+
+type sampleKey struct {
+	level slog.Level
+	msg   string
+}
+
+// NewFilter returns nil when opts configures nothing.
+func NewFilter(opts Options) *Filter {
+	f := &Filter{
+		level:      opts.Level,
+		first:      opts.SampleFirst,
+		thereafter: opts.SampleThereafter,
+		interval:   opts.SampleInterval,
+		fn:         opts.Redact,
+		secrets:    opts.Secrets,
+		counts:     make(map[sampleKey]int),
+	}
+	for _, k := range opts.RedactKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			if f.redact == nil {
+				f.redact = make(map[string]bool)
+			}
+			f.redact[strings.ToLower(k)] = true
+		}
+	}
+	if f.level == nil && f.first <= 0 && f.redact == nil && f.fn == nil && f.secrets == nil {
+		return nil
+	}
+	return f
+}
+
+// Allow reports whether a message passes the level and sampling.
+func (f *Filter) Allow(level slog.Level, msg string) bool {
+	if f == nil {
+		return true
+	}
+	if f.level != nil && level < f.level.Level() {
+		return false
+	}
+	if f.first <= 0 || level >= slog.LevelError {
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now := time.Now(); now.After(f.resetAt) {
+		clear(f.counts)
+		f.resetAt = now.Add(f.interval)
+	}
+	k := sampleKey{level, msg}
+	f.counts[k]++
+	n := f.counts[k]
+	if n <= f.first {
+		return true
+	}
+	return f.thereafter > 0 && (n-f.first)%f.thereafter == 0
+}
+
+// Scrub replaces the filter's secrets in s.
+func (f *Filter) Scrub(s string) string {
+	if f == nil {
+		return s
+	}
+	return f.secrets.Scrub(s)
+}
+
+// Redact returns kv with the values of redacted keys replaced and raw
+// byte payloads reduced to their size, so payloads never reach a log
+// line. kv is not modified. A nil Filter still strips payloads.
+func (f *Filter) Redact(kv []any) []any {
+	if len(kv) == 0 {
+		return kv
+	}
+
+	out := make([]any, len(kv))
+	copy(out, kv)
+	for i := 0; i < len(out); i++ {
+		switch v := out[i].(type) {
+		case slog.Attr:
+			out[i] = f.attr(v)
+		case string:
+			if i+1 < len(out) {
+				i++
+				out[i] = f.value(v, out[i])
+			}
+		default:
+			out[i] = f.nested(v)
+		}
+	}
+	return out
+}
+
+// RedactMap returns a copy of m with redacted keys replaced, or m itself
+// when nothing is redacted.
+func (f *Filter) RedactMap(m map[string]string) map[string]string {
+	if f == nil || (f.redact == nil && f.fn == nil) || m == nil {
+		return m
+	}
+	return f.nested(m).(map[string]string)
+}
+
+func (f *Filter) attr(a slog.Attr) slog.Attr {
+	if f.redacts(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		attrs := make([]any, len(group))
+		for i, g := range group {
+			attrs[i] = f.attr(g)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		return slog.Any(a.Key, f.value(a.Key, a.Value.Any()))
+	case slog.KindString:
+		return slog.String(a.Key, f.Scrub(a.Value.String()))
+	}
+	return a
+}
+
+func (f *Filter) redacts(key string) bool {
+	return f != nil && f.redact[strings.ToLower(key)]
+}
+
+func (f *Filter) value(key string, v any) any {
+	if f.redacts(key) {
+		return Redacted
+	}
+	if f != nil && f.fn != nil {
+		v = f.fn(key, v)
+	}
+	return f.scrub(f.nested(v))
+}
+
+// scrub removes secrets from string and error values.
+func (f *Filter) scrub(v any) any {
+	if f == nil || f.secrets == nil {
+		return v
+	}
+	switch x := v.(type) {
+	case string:
+		return f.secrets.Scrub(x)
+	case error:
+		if s := f.secrets.Scrub(x.Error()); s != x.Error() {
+			return s
+		}
+	}
+	return v
+}
+
+// nested strips payload bytes and redacts inside the map types metadata
+// is usually logged as.
+func (f *Filter) nested(v any) any {
+	switch m := v.(type) {
+	case []byte:
+		return fmt.Sprintf("[%d bytes]", len(m))
+	case json.RawMessage:
+		return fmt.Sprintf("[%d bytes]", len(m))
+	case batch.Record:
+		return fmt.Sprintf("[record: %d bytes]", len(m.Payload))
+	case *batch.Batch:
+		return fmt.Sprintf("[batch: %d records]", m.Len())
+	case batch.Batch:
+		return fmt.Sprintf("[batch: %d records]", m.Len())
+	case map[string]string:
+		out := make(map[string]string, len(m))
+		for k, s := range m {
+			if x, ok := f.value(k, s).(string); ok {
+				s = x
+			} else {
+				s = fmt.Sprint(x)
+			}
+			out[k] = s
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(m))
+		for k, x := range m {
+			out[k] = f.value(k, x)
+		}
+		return out
+	}
+	return v
+}
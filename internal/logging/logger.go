@@ -0,0 +1,37 @@
+package logging
+
+import "log/slog"
+
+// Logger is the logging interface shared by the runtime and the SDK.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+}
+
+// Wrap returns a logger that adds fields to every message and applies f.
+func Wrap(l Logger, f *Filter, fields ...any) Logger {
+	return &filtered{l: l, f: f, fields: f.Redact(fields)}
+}
+
+type filtered struct {
+	l      Logger
+	f      *Filter
+	fields []any
+}
+
+func (l *filtered) Debug(msg string, kv ...any) { l.log(slog.LevelDebug, l.l.Debug, msg, kv) }
+func (l *filtered) Info(msg string, kv ...any)  { l.log(slog.LevelInfo, l.l.Info, msg, kv) }
+func (l *filtered) Warn(msg string, kv ...any)  { l.log(slog.LevelWarn, l.l.Warn, msg, kv) }
+func (l *filtered) Error(msg string, kv ...any) { l.log(slog.LevelError, l.l.Error, msg, kv) }
+
+func (l *filtered) log(level slog.Level, emit func(string, ...any), msg string, kv []any) {
+	if !l.f.Allow(level, msg) {
+		return
+	}
+	all := make([]any, 0, len(l.fields)+len(kv))
+	all = append(all, l.fields...)
+	all = append(all, l.f.Redact(kv)...)
+	emit(l.f.Scrub(msg), all...)
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Scrubbed replaces secret values found in log lines and errors.
+const Scrubbed = "[SCRUBBED]"
+
+// minSecretLen is the shortest value Secrets tracks; shorter ones would
+// scrub ordinary words and numbers.
+const minSecretLen = 4
+
+// Secrets is a set of values, such as passwords resolved from a
+// connector config, to be scrubbed wherever they appear in text. The
+// zero value is empty and ready to use; a nil Secrets scrubs nothing.
+type Secrets struct {
+	mu       sync.RWMutex
+	values   []string
+	replacer *strings.Replacer
+}
+
+// Add tracks values. Values shorter than four bytes are ignored.
+func (s *Secrets) Add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range values {
+		if len(v) >= minSecretLen && !slices.Contains(s.values, v) {
+			s.values = append(s.values, v)
+		}
+	}
+	// Longer values go first so a secret containing another is
+	// scrubbed whole.
+	slices.SortFunc(s.values, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	pairs := make([]string, 0, 2*len(s.values))
+	for _, v := range s.values {
+		pairs = append(pairs, v, Scrubbed)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+}
+
+// Scrub replaces every tracked value in str.
+func (s *Secrets) Scrub(str string) string {
+	if s == nil {
+		return str
+	}
+	s.mu.RLock()
+	r := s.replacer
+	s.mu.RUnlock()
+	if r == nil {
+		return str
+	}
+	return r.Replace(str)
+}
@@ -0,0 +1,271 @@
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// AdminServiceName is the gRPC service registered when admin_service is
+// enabled. Requests and responses are google.protobuf.Struct messages so
+// operators can call it with generic tools such as grpcurl:
+//
+//	ListSessions      {}                      -> {"sessions": [...]}
+//	GetSessionStats   {"session_id": "..."}   -> session with flow and traffic stats
+//	Snapshot          {}                      -> process snapshot, see ProcessSnapshot
+//	GetTenantUsage    {"tenant_id": "..."}    -> {"tenants": [...]}, all tenants when omitted
+//	ForceCloseSession {"session_id": "..."}   -> {}
+//	SetLogLevel       {"level": "debug"}      -> {"previous_level": "INFO"}
+//	Savepoint         {"savepoint_id": "...", "session_ids": [...]}, both optional
+//	                                          -> {"savepoint_id": "...", "sessions": [...], "savepoint": "..."}
+//	ImportSavepoint   {"savepoint": "..."}    -> {"savepoint_id": "...", "sessions": [...]}
+//	TapBatches        {}                      -> stream of mirrored batches (tap_target=admin)
+//
+// A savepoint is the base64 of a JSON Savepoint. Each of its sessions
+// lists the state namespace to recreate it on.
+const AdminServiceName = "planx.plugin.admin.v1.PluginAdmin"
+
+type adminServer struct {
+	proc *Process
+}
+
+type adminMethod func(*adminServer, context.Context, *structpb.Struct) (map[string]any, error)
+
+// RegisterAdminServer registers the admin service for proc on s.
+func RegisterAdminServer(s *grpc.Server, proc *Process) {
+	methods := []struct {
+		name string
+		fn   adminMethod
+	}{
+		{"ListSessions", (*adminServer).listSessions},
+		{"GetSessionStats", (*adminServer).getSessionStats},
+		{"ForceCloseSession", (*adminServer).forceCloseSession},
+		{"SetLogLevel", (*adminServer).setLogLevel},
+		{"Snapshot", (*adminServer).snapshot},
+		{"GetTenantUsage", (*adminServer).getTenantUsage},
+		{"Savepoint", (*adminServer).savepoint},
+		{"ImportSavepoint", (*adminServer).importSavepoint},
+	}
+
+	desc := grpc.ServiceDesc{
+		ServiceName: AdminServiceName,
+		HandlerType: (*any)(nil),
+		Metadata:    "planx/plugin/admin/v1/admin.proto",
+	}
+	for _, m := range methods {
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: m.name,
+			Handler:    adminHandler(m.name, m.fn),
+		})
+	}
+	desc.Streams = append(desc.Streams, grpc.StreamDesc{
+		StreamName:    "TapBatches",
+		Handler:       tapBatchesHandler,
+		ServerStreams: true,
+	})
+	s.RegisterService(&desc, &adminServer{proc: proc})
+}
+
+func tapBatchesHandler(srv any, stream grpc.ServerStream) error {
+	a := srv.(*adminServer)
+	if err := a.proc.authorizeAdmin(stream.Context()); err != nil {
+		return err
+	}
+	if err := stream.RecvMsg(new(structpb.Struct)); err != nil {
+		return err
+	}
+	if a.proc.tap == nil || a.proc.cfg.TapTarget != tapAdmin {
+		return status.Error(codes.FailedPrecondition, "tap_target is not \"admin\"")
+	}
+
+	ch, unsubscribe := a.proc.tap.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case tb := <-ch:
+			data, err := json.Marshal(tb)
+			if err != nil {
+				return err
+			}
+			msg := new(structpb.Struct)
+			if err := protojson.Unmarshal(data, msg); err != nil {
+				return err
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func adminHandler(name string, fn adminMethod) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			a := srv.(*adminServer)
+			if err := a.proc.authorizeAdmin(ctx); err != nil {
+				return nil, err
+			}
+			out, err := fn(a, ctx, req.(*structpb.Struct))
+			if err != nil {
+				return nil, err
+			}
+			return structpb.NewStruct(out)
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + AdminServiceName + "/" + name}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+func (a *adminServer) listSessions(ctx context.Context, _ *structpb.Struct) (map[string]any, error) {
+	sessions := []any{}
+	for _, s := range a.proc.Sessions() {
+		sessions = append(sessions, sessionSummary(s))
+	}
+	return map[string]any{"sessions": sessions}, nil
+}
+
+func (a *adminServer) getSessionStats(ctx context.Context, req *structpb.Struct) (map[string]any, error) {
+	id := req.GetFields()["session_id"].GetStringValue()
+	for _, s := range a.proc.Sessions() {
+		if s.SessionID == id {
+			return jsonMap(newSessionReport(s))
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "session %q not found", id)
+}
+
+func (a *adminServer) snapshot(ctx context.Context, _ *structpb.Struct) (map[string]any, error) {
+	return jsonMap(a.proc.Snapshot())
+}
+
+func (a *adminServer) getTenantUsage(ctx context.Context, req *structpb.Struct) (map[string]any, error) {
+	id, filter := req.GetFields()["tenant_id"]
+	tenants := []TenantUsage{}
+	for _, u := range a.proc.TenantUsage() {
+		if !filter || u.TenantID == id.GetStringValue() {
+			tenants = append(tenants, u)
+		}
+	}
+	return jsonMap(map[string]any{"tenants": tenants})
+}
+
+func (a *adminServer) forceCloseSession(ctx context.Context, req *structpb.Struct) (map[string]any, error) {
+	id := req.GetFields()["session_id"].GetStringValue()
+	if !a.proc.CloseSession(ctx, id) {
+		return nil, status.Errorf(codes.NotFound, "session %q not found", id)
+	}
+	a.proc.log.Warn("planx: session force-closed by admin", "session_id", id)
+	return map[string]any{}, nil
+}
+
+func (a *adminServer) setLogLevel(ctx context.Context, req *structpb.Struct) (map[string]any, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.GetFields()["level"].GetStringValue())); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	prev := a.proc.SetLogLevel(level)
+	return map[string]any{"previous_level": prev.String()}, nil
+}
+
+// savepoint takes a savepoint of the sessions listed in session_ids, or
+// all of them, and returns it as base64-encoded JSON, see Savepoint.
+func (a *adminServer) savepoint(ctx context.Context, req *structpb.Struct) (map[string]any, error) {
+	var ids []string
+	for _, v := range req.GetFields()["session_ids"].GetListValue().GetValues() {
+		ids = append(ids, v.GetStringValue())
+	}
+	sp, err := a.proc.Savepoint(ctx, req.GetFields()["savepoint_id"].GetStringValue(), ids)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(sp)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"savepoint_id": sp.ID,
+		"sessions":     savepointSummary(sp),
+		"savepoint":    base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+func (a *adminServer) importSavepoint(ctx context.Context, req *structpb.Struct) (map[string]any, error) {
+	data, err := base64.StdEncoding.DecodeString(req.GetFields()["savepoint"].GetStringValue())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "savepoint: %v", err)
+	}
+	var sp Savepoint
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "savepoint: %v", err)
+	}
+	if err := a.proc.ImportSavepoint(ctx, &sp); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"savepoint_id": sp.ID,
+		"sessions":     savepointSummary(&sp),
+	}, nil
+}
+
+// savepointSummary lists what the engine needs to restore each session
+// of sp.
+func savepointSummary(sp *Savepoint) []any {
+	out := []any{}
+	for _, s := range sp.Sessions {
+		out = append(out, map[string]any{
+			"session_id": s.SessionID,
+			"tenant_id":  s.TenantID,
+			"role":       s.Role,
+			"connector":  s.Connector,
+			"namespace":  s.Namespace,
+		})
+	}
+	return out
+}
+
+func sessionSummary(s SessionSnapshot) map[string]any {
+	return map[string]any{
+		"session_id": s.SessionID,
+		"tenant_id":  s.TenantID,
+		"role":       s.Labels.Role,
+		"connector":  s.Labels.Connector,
+		"created_at": formatTime(s.Created),
+	}
+}
+
+// jsonMap converts v to the generic form structpb accepts, following
+// its JSON encoding.
+func jsonMap(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuditEventType string
+
+const (
+	AuditSessionCreated AuditEventType = "session_created"
+	AuditSessionDenied  AuditEventType = "session_denied"
+	AuditStreamOpened   AuditEventType = "stream_opened"
+	AuditStreamClosed   AuditEventType = "stream_closed"
+	AuditSessionError   AuditEventType = "session_error"
+	AuditSessionClosed  AuditEventType = "session_closed"
+)
+
+// AuditEvent records a step in the life of a session, attributed to the
+// engine that drove it.
+type AuditEvent struct {
+	Time      time.Time      `json:"time"`
+	Type      AuditEventType `json:"type"`
+	SessionID string         `json:"session_id"`
+	TenantID  string         `json:"tenant_id,omitempty"`
+	Role      string         `json:"role"`
+	Connector string         `json:"connector,omitempty"`
+	// EngineID is the x-planx-engine-id metadata of CreateSession and
+	// EngineAddr the peer address it came from. EnginePeer is the
+	// verified mTLS identity of the engine: its SPIFFE ID or common name.
+	EngineID   string `json:"engine_id,omitempty"`
+	EngineAddr string `json:"engine_addr,omitempty"`
+	EnginePeer string `json:"engine_peer,omitempty"`
+	// CallerID, CallerAddr and CallerPeer identify, in the same way, who
+	// called CloseSession or the admin close of a session_closed event.
+	// They are empty when the SDK itself closed the session, e.g. at
+	// shutdown.
+	CallerID   string `json:"caller_id,omitempty"`
+	CallerAddr string `json:"caller_addr,omitempty"`
+	CallerPeer string `json:"caller_peer,omitempty"`
+	// Method and Error describe the SPI call of a session_error event
+	// and the failure, if any, that ended a stream or session.
+	Method string `json:"method,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+const fileTargetPrefix = "file:"
+
+func validAuditLog(spec string) bool {
+	return spec == "" || spec == "log" || strings.HasPrefix(spec, fileTargetPrefix)
+}
+
+// newAuditor returns the audit sink for an audit_log spec and the
+// optional callback, or nil when auditing is off.
+func newAuditor(spec string, callback func(AuditEvent), log Logger) (func(AuditEvent), error) {
+	var sinks []func(AuditEvent)
+
+	switch {
+	case spec == "log":
+		sinks = append(sinks, func(e AuditEvent) {
+			log.Info("planx: audit",
+				"type", e.Type,
+				"session_id", e.SessionID,
+				"tenant_id", e.TenantID,
+				"role", e.Role,
+				"connector", e.Connector,
+				"engine_id", e.EngineID,
+				"engine_addr", e.EngineAddr,
+				"engine_peer", e.EnginePeer,
+				"caller_id", e.CallerID,
+				"caller_addr", e.CallerAddr,
+				"caller_peer", e.CallerPeer,
+				"method", e.Method,
+				"error", e.Error,
+			)
+		})
+
+	case strings.HasPrefix(spec, fileTargetPrefix):
+		path := strings.TrimPrefix(spec, fileTargetPrefix)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("planx: open audit log: %w", err)
+		}
+		var mu sync.Mutex
+		enc := json.NewEncoder(f)
+		sinks = append(sinks, func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := enc.Encode(e); err != nil {
+				log.Error("planx: write audit log", "path", path, "error", err)
+			}
+		})
+	}
+
+	if callback != nil {
+		sinks = append(sinks, callback)
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return func(e AuditEvent) {
+		for _, sink := range sinks {
+			sink(e)
+		}
+	}, nil
+}
+
+// audit emits an event about a session when auditing is enabled.
+func (r *Process) audit(meta *sessionMeta, typ AuditEventType, method string, err error) {
+	r.auditBy(meta, engineIdentity{}, typ, method, err)
+}
+
+// auditBy is audit for an event caused by an RPC from caller.
+func (r *Process) auditBy(meta *sessionMeta, caller engineIdentity, typ AuditEventType, method string, err error) {
+	if r.auditor == nil {
+		return
+	}
+	e := AuditEvent{
+		Time:       time.Now().UTC(),
+		Type:       typ,
+		SessionID:  meta.id,
+		TenantID:   meta.tenant,
+		Role:       meta.labels.Role,
+		Connector:  meta.labels.Connector,
+		EngineID:   meta.engine.id,
+		EngineAddr: meta.engine.addr,
+		EnginePeer: meta.engine.peer.String(),
+		CallerID:   caller.id,
+		CallerAddr: caller.addr,
+		CallerPeer: caller.peer.String(),
+		Method:     method,
+	}
+	if err != nil {
+		e.Error = meta.secrets.Scrub(err.Error())
+	}
+	r.auditor(e)
+}
@@ -0,0 +1,45 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuthRequest describes a session the engine asks to create.
+type AuthRequest struct {
+	TenantID  string
+	Role      string
+	Connector string
+	// EngineID is the engine's self-reported x-planx-engine-id; Peer is
+	// its verified mTLS identity, empty without mTLS.
+	EngineID string
+	Peer     session.Peer
+}
+
+// authorize asks the plugin's authorizer, if any, whether the session
+// described by meta may be created. A denial is audited and returned as
+// PERMISSION_DENIED.
+func (r *Process) authorize(ctx context.Context, meta *sessionMeta) error {
+	if r.authorizer == nil {
+		return nil
+	}
+	err := r.authorizer(ctx, AuthRequest{
+		TenantID:  meta.tenant,
+		Role:      meta.labels.Role,
+		Connector: meta.labels.Connector,
+		EngineID:  meta.engine.id,
+		Peer:      meta.engine.peer,
+	})
+	if err == nil {
+		return nil
+	}
+	r.audit(meta, AuditSessionDenied, "", err)
+	meta.log.Warn("planx: session denied", "error", err)
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.PermissionDenied, err.Error())
+}
@@ -0,0 +1,107 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newBudget(cfg Config) flow.Budget {
+	return flow.Budget{
+		Batches: flow.NewLimit(cfg.MaxInflightBatches),
+		Bytes:   flow.NewLimit(cfg.MaxBufferedBytes),
+		Calls:   flow.NewLimit(cfg.MaxConcurrentCalls),
+	}
+}
+
+// call runs an SPI method within the process-wide concurrent call
+// budget and the panic policy, and records its latency and outcome.
+func (r *Process) call(ctx context.Context, meta *sessionMeta, method string, fn func() error) error {
+	n, err := r.budget.Calls.Acquire(ctx, 1)
+	if errors.Is(err, context.Canceled) {
+		// The caller went away while waiting: not a failure either.
+		return status.FromContextError(err).Err()
+	}
+	if err != nil {
+		return r.fail(meta, method, status.Error(codes.ResourceExhausted, "plugin concurrent call budget exhausted"))
+	}
+	defer r.budget.Calls.Release(n)
+
+	// Only the SPI itself is timed here; RPC handler time, which adds
+	// admission, decoding and budget waits, is recorded by timeRPC.
+	start := time.Now()
+	err = r.guard(meta, method, fn)
+	r.metrics.SPICall(meta.labels, method, time.Since(start), err)
+	if err == nil || ctx.Err() != nil {
+		// Errors caused by the caller going away are not failures.
+		return err
+	}
+	r.audit(meta, AuditSessionError, method, err)
+	return r.fail(meta, method, err)
+}
+
+// reserve takes one in-flight batch and size buffered bytes from the
+// process budget until the returned func is called.
+func (r *Process) reserve(ctx context.Context, size int) (func(), error) {
+	if r.handingOff.Load() {
+		return nil, errHandingOff
+	}
+	batches, err := r.budget.Batches.Acquire(ctx, 1)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, "plugin in-flight batch budget exhausted")
+	}
+	bytes, err := r.budget.Bytes.Acquire(ctx, int64(size))
+	if err != nil {
+		r.budget.Batches.Release(batches)
+		return nil, status.Error(codes.ResourceExhausted, "plugin buffered bytes budget exhausted")
+	}
+	return func() {
+		r.budget.Bytes.Release(bytes)
+		r.budget.Batches.Release(batches)
+	}, nil
+}
+
+// unacked counts source batches that were sent but not yet acknowledged
+// by the engine, and holds their share of the in-flight batch budget.
+type unacked struct {
+	mu    sync.Mutex
+	limit *flow.Limit
+	count int64
+	held  int64
+}
+
+func (u *unacked) acquire(ctx context.Context) error {
+	n, err := u.limit.Acquire(ctx, 1)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.count++
+	u.held += n
+	u.mu.Unlock()
+	return nil
+}
+
+// ack settles up to n acknowledged batches; a negative n settles all.
+func (u *unacked) ack(n int64) {
+	u.mu.Lock()
+	if n < 0 || n > u.count {
+		n = u.count
+	}
+	u.count -= n
+	release := min(n, u.held)
+	u.held -= release
+	u.mu.Unlock()
+	u.limit.Release(release)
+}
+
+func (u *unacked) inflight() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.count
+}
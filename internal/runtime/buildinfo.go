@@ -0,0 +1,61 @@
+package runtime
+
+import "runtime/debug"
+
+const sdkModule = "github.com/planx-lab/planx-sdk-go"
+
+type BuildInfo struct {
+	PluginModule  string `json:"plugin_module,omitempty"`
+	PluginVersion string `json:"plugin_version,omitempty"`
+	SDKVersion    string `json:"sdk_version,omitempty"`
+	GoVersion     string `json:"go_version,omitempty"`
+	Commit        string `json:"commit,omitempty"`
+	CommitTime    string `json:"commit_time,omitempty"`
+	Modified      bool   `json:"modified,omitempty"`
+}
+
+// ReadBuildInfo collects version information embedded by the Go
+// toolchain. Values set through ldflags take precedence; see sdk/version.go.
+func ReadBuildInfo(version, commit, commitTime string) BuildInfo {
+	var bi BuildInfo
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		bi.PluginModule = info.Main.Path
+		bi.PluginVersion = info.Main.Version
+		bi.GoVersion = info.GoVersion
+
+		if info.Main.Path == sdkModule {
+			bi.SDKVersion = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == sdkModule {
+				bi.SDKVersion = dep.Version
+				if dep.Replace != nil {
+					bi.SDKVersion = dep.Replace.Version
+				}
+			}
+		}
+
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				bi.Commit = s.Value
+			case "vcs.time":
+				bi.CommitTime = s.Value
+			case "vcs.modified":
+				bi.Modified = s.Value == "true"
+			}
+		}
+	}
+
+	if version != "" {
+		bi.PluginVersion = version
+	}
+	if commit != "" {
+		bi.Commit = commit
+	}
+	if commitTime != "" {
+		bi.CommitTime = commitTime
+	}
+	return bi
+}
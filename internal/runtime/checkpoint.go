@@ -0,0 +1,239 @@
+package runtime
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"google.golang.org/grpc/metadata"
+)
+
+// Checkpoint barriers. The engine starts checkpoint <id> by sending
+// x-planx-checkpoint-id: <id> with an Ack to each source session. The
+// source snapshots its SPI before its next read and sends a barrier: a
+// batch without records whose metadata holds BarrierKey: <id>, plus
+// BarrierSizeKey on success or BarrierErrorKey if the snapshot failed.
+// Barriers take no credit and are not acked.
+//
+// The engine forwards barriers to processors and sinks, which snapshot
+// once the batches already in flight for the session are done; batches
+// sent after the barrier wait for the snapshot. A processor returns the
+// barrier to be passed on; a sink returns once its snapshot completes.
+// Either returns an error if it fails.
+//
+// Once every session of the pipeline has taken checkpoint <id>, the
+// engine commits it: with x-planx-checkpoint-committed: <id> on a source
+// Ack, and with a batch marked CommitKey: <id> sent to processors and
+// sinks like a barrier.
+const (
+	BarrierKey      = "planx.barrier"
+	BarrierSizeKey  = "planx.barrier.size"
+	BarrierErrorKey = "planx.barrier.error"
+	CommitKey       = "planx.commit"
+
+	checkpointMetadata = "x-planx-checkpoint-id"
+	committedMetadata  = "x-planx-checkpoint-committed"
+)
+
+// NewBarrier returns the barrier batch of checkpoint id.
+func NewBarrier(id string) *batch.Batch {
+	return &batch.Batch{Metadata: map[string]string{BarrierKey: id}}
+}
+
+// NewCommit returns the batch committing checkpoint id.
+func NewCommit(id string) *batch.Batch {
+	return &batch.Batch{Metadata: map[string]string{CommitKey: id}}
+}
+
+// BarrierID returns the checkpoint b is the barrier of, if it is one.
+func BarrierID(b *batch.Batch) (string, bool) {
+	return marker(b, BarrierKey)
+}
+
+// CommitID returns the checkpoint b commits, if it is a commit.
+func CommitID(b *batch.Batch) (string, bool) {
+	return marker(b, CommitKey)
+}
+
+func marker(b *batch.Batch, key string) (string, bool) {
+	if b == nil || b.Len() > 0 {
+		return "", false
+	}
+	id, ok := b.Metadata[key]
+	return id, ok && id != ""
+}
+
+func incoming(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// control is a barrier or, if commit is set, a commit for a source.
+type control struct {
+	id     string
+	commit bool
+}
+
+// controls queues the barriers and commits requested of a source session
+// until its send loop reaches them, in the order they were requested.
+type controls struct {
+	mu   sync.Mutex
+	list []control
+}
+
+func (c *controls) add(id string, commit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list = append(c.list, control{id, commit})
+}
+
+func (c *controls) take() []control {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.list
+	c.list = nil
+	return list
+}
+
+// aligner makes a session's barriers and commits wait for the batches before them
+// and hold back the batches after them.
+type aligner struct {
+	mu sync.RWMutex
+}
+
+// batch admits a data batch until the returned func is called.
+func (a *aligner) batch() func() {
+	a.mu.RLock()
+	return a.mu.RUnlock
+}
+
+// barrier waits for batches in flight and blocks new ones until the
+// returned func is called.
+func (a *aligner) barrier() func() {
+	a.mu.Lock()
+	return a.mu.Unlock
+}
+
+// snapshot takes checkpoint id of spi and returns its size in bytes. An
+// SPI that cannot snapshot completes every checkpoint with nothing.
+func (r *Process) snapshot(ctx context.Context, meta *sessionMeta, spi any, id string) (int, error) {
+	s, ok := spi.(Snapshotter)
+	if !ok {
+		return 0, nil
+	}
+
+	start := time.Now()
+	var size int
+	err := r.call(ctx, meta, "Snapshot", func() (err error) {
+		size, err = s.Snapshot(ctx, id)
+		return supported(err)
+	})
+	d := time.Since(start)
+	consecutive := meta.checkpoints.record(d, size, err)
+	if err != nil {
+		meta.log.Warn("planx: checkpoint failed",
+			"checkpoint_id", id, "error", err, "consecutive_failures", consecutive)
+		r.checkpointFailed(ctx, meta, spi, id, err, consecutive)
+		return 0, err
+	}
+	meta.log.Debug("planx: checkpoint completed",
+		"checkpoint_id", id, "size", size, "duration", d)
+	return size, nil
+}
+
+// checkpointFailed tells spi that checkpoint id failed, for it to alert
+// or change how it runs.
+func (r *Process) checkpointFailed(ctx context.Context, meta *sessionMeta, spi any, id string, err error, consecutive int) {
+	h, ok := spi.(CheckpointFailureHandler)
+	if !ok {
+		return
+	}
+	_ = r.call(ctx, meta, "OnCheckpointFailed", func() error {
+		h.OnCheckpointFailed(ctx, id, err, consecutive)
+		return nil
+	})
+}
+
+// CheckpointStats describes the checkpoints a session took.
+type CheckpointStats struct {
+	Completed int64
+	Failed    int64
+	// ConsecutiveFailures counts the checkpoints that failed since the
+	// last one that completed.
+	ConsecutiveFailures int64
+	// Duration is the time spent in all checkpoints; LastDuration and
+	// LastSize are those of the last one that completed.
+	Duration     time.Duration
+	LastDuration time.Duration
+	LastSize     int64
+}
+
+type checkpointStats struct {
+	completed, failed, consecutive atomic.Int64
+	duration, lastDuration         atomic.Int64 // nanoseconds
+	lastSize                       atomic.Int64
+}
+
+// record counts a checkpoint that took d and returns the number of
+// failures in a row.
+func (c *checkpointStats) record(d time.Duration, size int, err error) int {
+	c.duration.Add(int64(d))
+	if err != nil {
+		c.failed.Add(1)
+		return int(c.consecutive.Add(1))
+	}
+	c.completed.Add(1)
+	c.consecutive.Store(0)
+	c.lastDuration.Store(int64(d))
+	c.lastSize.Store(int64(size))
+	return 0
+}
+
+func (c *checkpointStats) stats() CheckpointStats {
+	return CheckpointStats{
+		Completed:           c.completed.Load(),
+		Failed:              c.failed.Load(),
+		ConsecutiveFailures: c.consecutive.Load(),
+		Duration:            time.Duration(c.duration.Load()),
+		LastDuration:        time.Duration(c.lastDuration.Load()),
+		LastSize:            c.lastSize.Load(),
+	}
+}
+
+// barrierFor takes checkpoint id of a source and returns the barrier
+// reporting its outcome.
+func (r *Process) barrierFor(ctx context.Context, meta *sessionMeta, spi any, id string) *batch.Batch {
+	b := NewBarrier(id)
+	size, err := r.snapshot(ctx, meta, spi, id)
+	if err != nil {
+		b.Metadata[BarrierErrorKey] = err.Error()
+	} else {
+		b.Metadata[BarrierSizeKey] = strconv.Itoa(size)
+	}
+	return b
+}
+
+// commit tells spi that checkpoint id is complete across the pipeline, so
+// it may publish what it held back for it.
+func (r *Process) commit(ctx context.Context, meta *sessionMeta, spi any, id string) error {
+	c, ok := spi.(Committer)
+	if !ok {
+		return nil
+	}
+	err := r.call(ctx, meta, "Commit", func() error {
+		return supported(c.Commit(ctx, id))
+	})
+	if err != nil {
+		meta.log.Warn("planx: checkpoint commit failed", "checkpoint_id", id, "error", err)
+		return err
+	}
+	meta.log.Debug("planx: checkpoint committed", "checkpoint_id", id)
+	return nil
+}
@@ -0,0 +1,433 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+)
+
+// Config holds the SDK runtime settings. Each field's JSON name is also
+// its key in the config file and engine-provided config, its environment
+// variable (PLANX_ + upper case) and its flag (--planx- + dashes).
+type Config struct {
+	ListenAddress string      `json:"listen_address"`
+	HandshakeFile string      `json:"handshake_file"`
+	Protocols     string      `json:"protocols"`
+	PanicPolicy   PanicPolicy `json:"panic_policy"`
+
+	// TLSCertFile and TLSKeyFile enable TLS on the plugin listener.
+	// TLSClientCAFile additionally requires the engine to present a
+	// client certificate signed by one of its CAs; the verified identity
+	// is then passed to SPIs and recorded in audit events.
+	TLSCertFile     string `json:"tls_cert_file"`
+	TLSKeyFile      string `json:"tls_key_file"`
+	TLSClientCAFile string `json:"tls_client_ca_file"`
+	// SPIFFEEndpointSocket, e.g. "unix:///run/spire/sockets/agent.sock",
+	// takes the plugin's certificate and the trust bundle for engine
+	// certificates from a SPIFFE Workload API instead, and follows their
+	// rotation. It implies mTLS and excludes the tls_* files.
+	SPIFFEEndpointSocket string `json:"spiffe_endpoint_socket"`
+
+	// Production disables debug features (tap_target, gateway_address)
+	// and requires admin_token for the admin service and debug endpoint.
+	Production bool `json:"production"`
+
+	// HandoffSocket, e.g. "/run/planx/orders.sock", enables hot
+	// upgrades: the process listens on the unix socket while it serves,
+	// and a new process started with the same socket takes its sessions
+	// over before serving, after which the old one drains and stops.
+	// HandoffDrainTimeout bounds how long the old process waits for the
+	// engine to close its sessions.
+	HandoffSocket       string        `json:"handoff_socket"`
+	HandoffDrainTimeout time.Duration `json:"handoff_drain_timeout"`
+
+	// AdminService registers the admin gRPC service (AdminServiceName)
+	// on the plugin listener for live inspection and management.
+	AdminService bool `json:"admin_service"`
+	// DebugAddress, when set, serves the JSON process snapshot at
+	// http://DebugAddress/debug/planx/snapshot.
+	DebugAddress string `json:"debug_address"`
+	// GatewayAddress, when set, serves an HTTP/JSON gateway onto the
+	// plugin's connectors at http://GatewayAddress/sessions, to try them
+	// out with curl during development. It is not allowed in production
+	// mode.
+	GatewayAddress string `json:"gateway_address"`
+	// AdminToken, when set, must be presented as "authorization: Bearer
+	// <token>" metadata on admin calls and as the Authorization header on
+	// the debug endpoint.
+	AdminToken string `json:"admin_token"`
+
+	// StateBackend holds session state: "memory" (the default),
+	// "file:<dir>" or "redis://[:password@]host:port[/db]", optionally
+	// with a retention policy such as "file:<dir>?ttl=24h".
+	StateBackend string `json:"state_backend"`
+	// StateChangelog, e.g. "file:<dir>", is where the state of sessions
+	// created with x-planx-state-changelog: true is mirrored, so it can be
+	// rebuilt if the state backend loses it.
+	StateChangelog string `json:"state_changelog"`
+	// CheckpointFullInterval makes checkpoints incremental: a session's
+	// state is stored in full every that many checkpoints and, in
+	// between, as the entries changed since the checkpoint before, so a
+	// barrier costs what changed rather than all the state. Restoring
+	// reads back to the last full checkpoint. Zero or one, the default,
+	// stores every checkpoint in full.
+	CheckpointFullInterval int `json:"checkpoint_full_interval"`
+
+	// AuditLog selects where session audit events go: "log" (the SDK
+	// logger) or "file:<path>" (JSON lines). Empty disables them unless
+	// the plugin installs an audit handler.
+	AuditLog string `json:"audit_log"`
+
+	// UsageFlushInterval is how often per-tenant usage is handed to the
+	// plugin's usage handler, if it installed one.
+	UsageFlushInterval time.Duration `json:"usage_flush_interval"`
+
+	// TapTarget mirrors a sample of batches for debugging to "log"
+	// (without payloads), "file:<path>" (JSON lines, each encrypted when
+	// the plugin has a key provider) or "admin" (the
+	// admin TapBatches stream). Empty disables the tap. Batches are selected when their
+	// metadata matches every key=value in TapFilter and then with
+	// probability TapPercent/100. Each mirrored batch keeps at most
+	// TapMaxRecords records truncated to TapMaxPayload bytes.
+	TapTarget     string  `json:"tap_target"`
+	TapFilter     string  `json:"tap_filter"`
+	TapPercent    float64 `json:"tap_percent"`
+	TapMaxRecords int     `json:"tap_max_records"`
+	TapMaxPayload int     `json:"tap_max_payload"`
+
+	// LogSampleFirst enables per-session log sampling: within each
+	// LogSampleInterval the first LogSampleFirst messages with the same
+	// level and text are logged, then every LogSampleThereafter-th.
+	// Errors are never sampled.
+	LogSampleFirst      int           `json:"log_sample_first"`
+	LogSampleThereafter int           `json:"log_sample_thereafter"`
+	LogSampleInterval   time.Duration `json:"log_sample_interval"`
+	// LogRedactKeys is a comma-separated list of log and metadata keys
+	// whose values are replaced in session logs.
+	LogRedactKeys string `json:"log_redact_keys"`
+	// SecretConfigKeys is a comma-separated list of words that mark a
+	// connector config field as secret when its name contains one,
+	// case-insensitively. The values of secret fields, and of fields
+	// decrypted by the key provider, are scrubbed from the session's
+	// log lines, errors and panics.
+	SecretConfigKeys string `json:"secret_config_keys"`
+
+	// MetricsBackend selects where SDK metrics go: "prometheus" (the
+	// default), "otlp" or "none".
+	MetricsBackend MetricsBackend `json:"metrics_backend"`
+	// MetricsAddress, when set, serves Prometheus metrics at
+	// http://MetricsAddress/metrics.
+	MetricsAddress string `json:"metrics_address"`
+	// OTLPEndpoint is the OTLP/gRPC collector for the otlp backend. When
+	// empty the OTEL_EXPORTER_OTLP_* environment variables apply.
+	OTLPEndpoint string `json:"otlp_endpoint"`
+
+	// AdaptiveWindow enables AIMD sizing of the source send window
+	// between 1 and AdaptiveWindowMax, always bounded by engine credits.
+	AdaptiveWindow    bool `json:"adaptive_window"`
+	AdaptiveWindowMax int  `json:"adaptive_window_max"`
+
+	// StallWarnInterval is how long a source stream may wait for credit
+	// before each stall warning is logged.
+	StallWarnInterval time.Duration `json:"stall_warn_interval"`
+
+	// AckCoalesceInterval merges source acks that arrive within the
+	// interval into one window update. It is also advertised to the
+	// engine in the handshake as the suggested ack batching interval.
+	AckCoalesceInterval time.Duration `json:"ack_coalesce_interval"`
+
+	// FlowPolicy is the default flow-control policy of sessions, see
+	// flow.ParsePolicy. The engine may override it per session.
+	FlowPolicy string `json:"flow_policy"`
+
+	// InboundWindow limits unprocessed batches in flight toward each
+	// sink or processor session. Zero means unlimited.
+	InboundWindow int `json:"inbound_window"`
+
+	// Process-wide limits shared by all sessions. Zero means unlimited.
+	MaxInflightBatches int64 `json:"max_inflight_batches"`
+	MaxBufferedBytes   int64 `json:"max_buffered_bytes"`
+	MaxConcurrentCalls int64 `json:"max_concurrent_calls"`
+
+	// SessionRate limits CreateSession to that many sessions per second
+	// across all engines, and SessionCallerRate per engine, identified
+	// by mTLS identity, else by host. Bursts default to one
+	// second's worth. Sessions over a limit are rejected with
+	// RESOURCE_EXHAUSTED before the SPI is created. Zero means unlimited.
+	SessionRate        float64 `json:"session_rate"`
+	SessionBurst       int     `json:"session_burst"`
+	SessionCallerRate  float64 `json:"session_caller_rate"`
+	SessionCallerBurst int     `json:"session_caller_burst"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddress: "127.0.0.1:0",
+		HandshakeFile: "planx.handshake",
+		PanicPolicy:   PanicRecover,
+
+		LogSampleInterval: time.Second,
+		SecretConfigKeys:  "password,passwd,secret,token,api_key,apikey,private_key,credential",
+
+		UsageFlushInterval: time.Minute,
+
+		TapPercent:    100,
+		TapMaxRecords: 10,
+		TapMaxPayload: 256,
+
+		MetricsBackend: MetricsPrometheus,
+
+		AdaptiveWindowMax: 1024,
+		StallWarnInterval: 30 * time.Second,
+		FlowPolicy:        flow.PolicyCredit,
+
+		HandoffDrainTimeout: 30 * time.Second,
+	}
+}
+
+func (c Config) validate() error {
+	switch c.PanicPolicy {
+	case PanicRecover, PanicCrash:
+	default:
+		return fmt.Errorf("planx: panic_policy must be %q or %q, got %q",
+			PanicRecover, PanicCrash, c.PanicPolicy)
+	}
+	switch c.MetricsBackend {
+	case MetricsPrometheus, MetricsOTLP, MetricsNone:
+	default:
+		return fmt.Errorf("planx: unknown metrics_backend %q", c.MetricsBackend)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("planx: tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		return fmt.Errorf("planx: tls_client_ca_file requires tls_cert_file")
+	}
+	if c.SPIFFEEndpointSocket != "" && c.TLSCertFile != "" {
+		return fmt.Errorf("planx: spiffe_endpoint_socket and tls_cert_file are mutually exclusive")
+	}
+	if !validStateBackend(c.StateBackend) {
+		return fmt.Errorf("planx: state_backend must be \"memory\", \"file:<dir>\" or \"redis://...\", got %q", c.StateBackend)
+	}
+	if c.StateChangelog != "" && !strings.HasPrefix(c.StateChangelog, fileTargetPrefix) {
+		return fmt.Errorf("planx: state_changelog must be \"file:<dir>\", got %q", c.StateChangelog)
+	}
+	if c.CheckpointFullInterval < 0 {
+		return fmt.Errorf("planx: checkpoint_full_interval must not be negative")
+	}
+	if !validAuditLog(c.AuditLog) {
+		return fmt.Errorf("planx: audit_log must be \"log\" or \"file:<path>\", got %q", c.AuditLog)
+	}
+	if c.HandoffSocket != "" && c.HandoffDrainTimeout <= 0 {
+		return fmt.Errorf("planx: handoff_drain_timeout must be positive")
+	}
+	if c.UsageFlushInterval <= 0 {
+		return fmt.Errorf("planx: usage_flush_interval must be positive")
+	}
+	if !validTapTarget(c.TapTarget) {
+		return fmt.Errorf("planx: tap_target must be \"log\", \"admin\" or \"file:<path>\", got %q", c.TapTarget)
+	}
+	if c.TapTarget == tapAdmin && !c.AdminService {
+		return fmt.Errorf("planx: tap_target \"admin\" requires admin_service")
+	}
+	if c.LogSampleFirst > 0 && c.LogSampleInterval <= 0 {
+		return fmt.Errorf("planx: log_sample_interval must be positive")
+	}
+	if _, err := flow.ParsePolicy(c.FlowPolicy, 0); err != nil {
+		return fmt.Errorf("planx: %w", err)
+	}
+	if err := c.validateProduction(); err != nil {
+		return err
+	}
+	if c.SessionRate < 0 || c.SessionCallerRate < 0 || c.SessionBurst < 0 || c.SessionCallerBurst < 0 {
+		return fmt.Errorf("planx: session rate limits must not be negative")
+	}
+	return nil
+}
+
+func (c Config) logOptions(level *slog.LevelVar, redact func(string, any) any) logging.Options {
+	opts := logging.Options{
+		Level:            level,
+		Redact:           redact,
+		SampleFirst:      c.LogSampleFirst,
+		SampleThereafter: c.LogSampleThereafter,
+		SampleInterval:   c.LogSampleInterval,
+	}
+	if c.LogRedactKeys != "" {
+		opts.RedactKeys = strings.Split(c.LogRedactKeys, ",")
+	}
+	return opts
+}
+
+func validStateBackend(spec string) bool {
+	if strings.HasPrefix(spec, "redis://") {
+		return true
+	}
+	spec, _, _ = strings.Cut(spec, "?")
+	return spec == "" || spec == "memory" || strings.HasPrefix(spec, fileTargetPrefix)
+}
+
+func (c Config) secretKeys() []string {
+	var keys []string
+	for _, k := range strings.Split(c.SecretConfigKeys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, strings.ToLower(k))
+		}
+	}
+	return keys
+}
+
+const (
+	configFileKey   = "config_file"
+	engineConfigEnv = "PLANX_ENGINE_CONFIG"
+)
+
+// ResolveConfig layers, lowest to highest precedence: defaults, the JSON
+// config file named by --planx-config-file or PLANX_CONFIG_FILE,
+// PLANX_* environment variables, --planx-* flags in args, and the JSON
+// object the engine passes in PLANX_ENGINE_CONFIG.
+func ResolveConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+	flags := parseFlags(args)
+
+	path := getenv(envName(configFileKey))
+	if v, ok := flags[configFileKey]; ok {
+		path = v
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("planx: read config file: %w", err)
+		}
+		if err := applyJSON(&cfg, data); err != nil {
+			return cfg, fmt.Errorf("planx: config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEach(&cfg, func(key string) (string, bool) {
+		v := getenv(envName(key))
+		return v, v != ""
+	}); err != nil {
+		return cfg, fmt.Errorf("planx: environment: %w", err)
+	}
+
+	if err := applyEach(&cfg, func(key string) (string, bool) {
+		v, ok := flags[key]
+		return v, ok
+	}); err != nil {
+		return cfg, fmt.Errorf("planx: flags: %w", err)
+	}
+
+	if data := getenv(engineConfigEnv); data != "" {
+		if err := applyJSON(&cfg, []byte(data)); err != nil {
+			return cfg, fmt.Errorf("planx: engine config: %w", err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func envName(key string) string {
+	return "PLANX_" + strings.ToUpper(key)
+}
+
+// parseFlags picks --planx-key=value and --planx-key value pairs out of
+// args, leaving everything else to the plugin's own flag handling.
+func parseFlags(args []string) map[string]string {
+	out := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimLeft(args[i], "-")
+		if arg == args[i] || !strings.HasPrefix(arg, "planx-") {
+			continue
+		}
+		arg = strings.TrimPrefix(arg, "planx-")
+
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			value = args[i]
+		} else if !ok {
+			value = "true"
+		}
+		out[strings.ReplaceAll(name, "-", "_")] = value
+	}
+	return out
+}
+
+func applyJSON(cfg *Config, data []byte) error {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return applyEach(cfg, func(key string) (string, bool) {
+		v, ok := raw[key]
+		if !ok || v == nil {
+			return "", false
+		}
+		return fmt.Sprint(v), true
+	})
+}
+
+func applyEach(cfg *Config, lookup func(key string) (string, bool)) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, raw string) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported config type %s", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Data planes. The engine offers the data planes it can use with
+// x-planx-data-plane on CreateSession, most preferred first, as a
+// comma-separated list or repeated values. The plugin opens the first one
+// it has and answers with its name in the same header, plus the
+// parameters the engine needs to reach it, e.g. an address, in
+// x-planx-data-plane-params. From then on the Batch messages of the
+// session carry a reference to a packed batch in the data plane instead
+// of the batch itself, both ways; acks, barriers, credit and every other
+// control stay on the gRPC services. Without a match the session keeps
+// its batches inline.
+const (
+	dataPlaneMetadata       = "x-planx-data-plane"
+	dataPlaneParamsMetadata = "x-planx-data-plane-params"
+)
+
+// DataPlane moves packed batches between the engine and the plugin
+// outside the gRPC streams, for transports that carry large batches more
+// cheaply, such as Arrow Flight or shared memory. Batches stay opaque: a
+// data plane moves the bytes the codec packed, and the references it
+// returns are opaque to the SDK too. Implementations are safe for
+// concurrent use across sessions.
+type DataPlane interface {
+	// Name is what the engine offers in x-planx-data-plane.
+	Name() string
+	// Open attaches a session to the plane and returns the parameters
+	// sent to the engine.
+	Open(ctx context.Context, sessionID string) (params string, err error)
+	// Put hands a packed batch of the session to the engine and returns
+	// the reference sent in its place.
+	Put(sessionID string, packed []byte) (ref []byte, err error)
+	// Get returns the packed batch that ref, received from the engine,
+	// refers to. The bytes may belong to the plane; they stay valid until
+	// Release is called with ref, once the batch is done with.
+	Get(sessionID string, ref []byte) ([]byte, error)
+	Release(sessionID string, ref []byte)
+	// Close detaches a session, releasing what the plane holds for it.
+	Close(sessionID string) error
+}
+
+// Discarder is implemented by data planes that take back a batch handed
+// to them with Put whose reference never reached the engine, as its send
+// failed.
+type Discarder interface {
+	Discard(sessionID string, ref []byte)
+}
+
+// sessionPlane is the data plane a session negotiated; nil when its
+// batches travel inline.
+type sessionPlane struct {
+	DataPlane
+	session string
+}
+
+// out returns what to send the engine for a packed batch.
+func (p *sessionPlane) out(packed []byte) ([]byte, error) {
+	if p == nil {
+		return packed, nil
+	}
+	ref, err := p.Put(p.session, packed)
+	if err != nil {
+		return nil, fmt.Errorf("planx: data plane %s: %w", p.Name(), err)
+	}
+	return ref, nil
+}
+
+// discard takes back a reference out returned that was not sent.
+func (p *sessionPlane) discard(ref []byte) {
+	if p == nil {
+		return
+	}
+	if d, ok := p.DataPlane.(Discarder); ok {
+		d.Discard(p.session, ref)
+	}
+}
+
+// in returns the packed batch a payload received from the engine holds,
+// and the func to call once it is done with.
+func (p *sessionPlane) in(payload []byte) ([]byte, func(), error) {
+	if p == nil {
+		return payload, func() {}, nil
+	}
+	packed, err := p.Get(p.session, payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("planx: data plane %s: %w", p.Name(), err)
+	}
+	return packed, func() { p.Release(p.session, payload) }, nil
+}
+
+func (p *sessionPlane) close(log Logger) {
+	if p == nil {
+		return
+	}
+	if err := p.Close(p.session); err != nil {
+		log.Warn("planx: data plane close failed", "data_plane", p.Name(), "error", err)
+	}
+}
+
+// openDataPlane opens the data plane of a new session, if the engine
+// offered one the plugin has, and tells the engine how to reach it.
+func (r *Process) openDataPlane(ctx context.Context, meta *sessionMeta) (*sessionPlane, error) {
+	dp := r.chooseDataPlane(ctx)
+	if dp == nil {
+		return nil, nil
+	}
+	params, err := dp.Open(ctx, meta.id)
+	if err != nil {
+		return nil, fmt.Errorf("planx: data plane %s: %w", dp.Name(), err)
+	}
+	// Outside a gRPC call, as in plugintest, there is no one to tell.
+	grpc.SetHeader(ctx, metadata.Pairs(
+		dataPlaneMetadata, dp.Name(),
+		dataPlaneParamsMetadata, params,
+	))
+	meta.log.Debug("planx: data plane opened", "data_plane", dp.Name())
+	return &sessionPlane{DataPlane: dp, session: meta.id}, nil
+}
+
+func (r *Process) chooseDataPlane(ctx context.Context) DataPlane {
+	if len(r.dataPlanes) == 0 {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(dataPlaneMetadata) {
+		for _, name := range strings.Split(v, ",") {
+			if dp, ok := r.dataPlanes[strings.TrimSpace(name)]; ok {
+				return dp
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Delivery guarantees an engine can ask of a session with the
+// x-planx-delivery metadata on CreateSession.
+const (
+	// DeliveryAtLeastOnce replays batches after the last checkpoint on
+	// recovery. This is the default.
+	DeliveryAtLeastOnce = "at_least_once"
+	// DeliveryExactlyOnce additionally requires the source to checkpoint
+	// its position and the sink to commit what it wrote per checkpoint,
+	// so replayed batches are never published twice. A plugin that
+	// accepts it sets x-planx-delivery: exactly_once in the response
+	// headers; one that cannot fails CreateSession.
+	DeliveryExactlyOnce = "exactly_once"
+
+	deliveryMetadata = "x-planx-delivery"
+)
+
+// exactlyOnce reports whether the engine asked for exactly-once delivery.
+func exactlyOnce(ctx context.Context) (bool, error) {
+	switch v := incoming(ctx, deliveryMetadata); v {
+	case "", DeliveryAtLeastOnce:
+		return false, nil
+	case DeliveryExactlyOnce:
+		return true, nil
+	default:
+		return false, status.Errorf(codes.InvalidArgument, "unknown delivery %q", v)
+	}
+}
+
+// confirmExactlyOnce tells the engine the session runs exactly-once.
+// Outside a gRPC call, as in plugintest, there is no one to tell.
+func confirmExactlyOnce(ctx context.Context) {
+	grpc.SetHeader(ctx, metadata.Pairs(deliveryMetadata, DeliveryExactlyOnce))
+}
@@ -0,0 +1,204 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorCategory classifies session and batch failures so the engine and
+// dashboards can tell bad configuration from infrastructure trouble.
+type ErrorCategory string
+
+const (
+	ErrUserConfig ErrorCategory = "user_config"
+	ErrTransient  ErrorCategory = "retryable_transient"
+	ErrFatal      ErrorCategory = "fatal_system"
+	ErrDataFormat ErrorCategory = "data_format"
+	// ErrUncategorized covers errors that carry no category and whose
+	// gRPC code does not imply one.
+	ErrUncategorized ErrorCategory = "uncategorized"
+)
+
+var errorCategories = [...]ErrorCategory{
+	ErrUncategorized, ErrUserConfig, ErrTransient, ErrFatal, ErrDataFormat,
+}
+
+// code is the gRPC status code a category is reported with.
+func (c ErrorCategory) code() codes.Code {
+	switch c {
+	case ErrUserConfig:
+		return codes.InvalidArgument
+	case ErrTransient:
+		return codes.Unavailable
+	case ErrFatal:
+		return codes.Internal
+	case ErrDataFormat:
+		return codes.DataLoss
+	}
+	return codes.Unknown
+}
+
+type categorizedError struct {
+	category ErrorCategory
+	err      error
+}
+
+// CategorizeError wraps err with a category. Wrapping a nil error returns
+// nil.
+func CategorizeError(c ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &categorizedError{category: c, err: err}
+}
+
+func (e *categorizedError) Error() string { return e.err.Error() }
+func (e *categorizedError) Unwrap() error { return e.err }
+
+func (e *categorizedError) GRPCStatus() *status.Status {
+	return status.New(e.category.code(), e.err.Error())
+}
+
+// ErrorCategoryOf returns the category of err: the innermost explicit
+// category if any, otherwise one implied by a known error or gRPC code.
+func ErrorCategoryOf(err error) ErrorCategory {
+	var ce *categorizedError
+	if errors.As(err, &ce) {
+		return ce.category
+	}
+	var pe *panicError
+	if errors.As(err, &pe) {
+		return ErrFatal
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrTransient
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+			return ErrTransient
+		case codes.InvalidArgument, codes.FailedPrecondition:
+			return ErrUserConfig
+		case codes.DataLoss:
+			return ErrDataFormat
+		case codes.Internal:
+			return ErrFatal
+		}
+	}
+	return ErrUncategorized
+}
+
+// ErrorDomain is the ErrorInfo domain of failures reported by the SDK.
+// The ErrorInfo reason is the upper-cased category and its metadata
+// holds "category", "method" (for SPI calls), "error" and, when known,
+// "record_index". A RetryInfo detail carries any retry-after hint.
+const ErrorDomain = "planx.plugin"
+
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+// WithRetryAfter attaches a retry delay hint to err.
+func WithRetryAfter(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryAfterError{err: err, delay: d}
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+type recordError struct {
+	err   error
+	index int
+}
+
+// WithRecordIndex records which record of the batch caused err.
+func WithRecordIndex(err error, index int) error {
+	if err == nil {
+		return nil
+	}
+	return &recordError{err: err, index: index}
+}
+
+func (e *recordError) Error() string { return e.err.Error() }
+func (e *recordError) Unwrap() error { return e.err }
+
+// failure is an error returned to the engine, carrying structured
+// details in its gRPC status.
+type failure struct {
+	category ErrorCategory
+	method   string
+	err      error
+	secrets  *logging.Secrets
+}
+
+func (f *failure) Error() string { return f.secrets.Scrub(f.err.Error()) }
+func (f *failure) Unwrap() error { return f.err }
+
+func (f *failure) GRPCStatus() *status.Status {
+	code := f.category.code()
+	var ce *categorizedError
+	if s, ok := status.FromError(f.err); ok && !errors.As(f.err, &ce) {
+		if len(s.Details()) > 0 {
+			p := s.Proto()
+			p.Message = f.secrets.Scrub(p.Message)
+			return status.FromProto(p)
+		}
+		code = s.Code()
+	}
+	st := status.New(code, f.Error())
+
+	info := &errdetails.ErrorInfo{
+		Reason: strings.ToUpper(string(f.category)),
+		Domain: ErrorDomain,
+		Metadata: map[string]string{
+			"category": string(f.category),
+			"error":    f.Error(),
+		},
+	}
+	if f.method != "" {
+		info.Metadata["method"] = f.method
+	}
+	var re *recordError
+	if errors.As(f.err, &re) {
+		info.Metadata["record_index"] = strconv.Itoa(re.index)
+	}
+	details := []protoadapt.MessageV1{info}
+
+	var ra *retryAfterError
+	if errors.As(f.err, &ra) && ra.delay > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(ra.delay)})
+	}
+
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// fail counts err against the session under its category and returns it
+// with a gRPC status carrying the category's code and failure details.
+// method is the SPI method that failed, if any.
+func (r *Process) fail(meta *sessionMeta, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	c := ErrorCategoryOf(err)
+	meta.errors[slices.Index(errorCategories[:], c)].Add(1)
+	r.metrics.Error(meta.labels, c)
+	return &failure{category: c, method: method, err: err, secrets: meta.secrets}
+}
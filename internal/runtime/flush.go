@@ -0,0 +1,76 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Flush and end-of-stream markers. The engine has a processor or sink
+// flush what it buffered with a batch without records whose metadata
+// holds FlushKey: <id>, sent like a barrier: the flush waits for the
+// batches already in flight for the session, and the batches sent after
+// it wait for the flush. The SDK calls the SPI's Flush; a processor
+// returns the marker to be passed on, a sink returns once it is flushed.
+//
+// A batch marked EndKey: <id> ends a bounded stream: it flushes the
+// session the same way, and the session refuses batches sent after it
+// with FailedPrecondition. A processor passes it on, so the engine knows
+// once the sink has it that every batch of the stream was written and
+// flushed, and can close the sessions.
+const (
+	FlushKey = "planx.flush"
+	EndKey   = "planx.end"
+)
+
+// NewFlush returns the batch requesting flush id.
+func NewFlush(id string) *batch.Batch {
+	return &batch.Batch{Metadata: map[string]string{FlushKey: id}}
+}
+
+// NewEnd returns the batch ending stream id.
+func NewEnd(id string) *batch.Batch {
+	return &batch.Batch{Metadata: map[string]string{EndKey: id}}
+}
+
+// FlushID returns the flush b requests, if it is a flush.
+func FlushID(b *batch.Batch) (string, bool) {
+	return marker(b, FlushKey)
+}
+
+// EndID returns the stream b ends, if it is an end-of-stream.
+func EndID(b *batch.Batch) (string, bool) {
+	return marker(b, EndKey)
+}
+
+// flushMarker returns the id of a flush or end-of-stream, and whether it
+// is the latter.
+func flushMarker(b *batch.Batch) (id string, end, ok bool) {
+	if id, ok := EndID(b); ok {
+		return id, true, true
+	}
+	id, ok = FlushID(b)
+	return id, false, ok
+}
+
+var errStreamEnded = status.Error(codes.FailedPrecondition, "planx: stream ended")
+
+// flush has spi write out what it buffered, for flush or end-of-stream
+// id. An SPI that does not buffer has nothing to do.
+func (r *Process) flush(ctx context.Context, meta *sessionMeta, spi any, id string) error {
+	f, ok := spi.(Flusher)
+	if !ok {
+		return nil
+	}
+	err := r.call(ctx, meta, "Flush", func() error {
+		return supported(f.Flush(ctx))
+	})
+	if err != nil {
+		meta.log.Warn("planx: flush failed", "flush_id", id, "error", err)
+		return err
+	}
+	meta.log.Debug("planx: flushed", "flush_id", id)
+	return nil
+}
@@ -0,0 +1,410 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The HTTP/JSON gateway drives the plugin's connectors the way the engine
+// does, through the same servers, for poking at them with curl:
+//
+//	POST   /sessions               {"role": "sink", "connector": "orders", "config": {...}}
+//	POST   /sessions/{id}/batches  {"records": [{"payload": {...}, "metadata": {...}}]}
+//	GET    /sessions/{id}/batches  the next batch of a source session
+//	DELETE /sessions/{id}
+//
+// A session is backfilled with "mode": "backfill" and the optional RFC
+// 3339 bounds "backfill_start" and "backfill_end". A sink answers a
+// batch with 204, a processor with the batch it produced. A source
+// session's stream opens with the session, with one credit that each GET
+// acks; a GET answers 204 if no batch came within ?wait= (default 10s)
+// and 410 once the stream has ended.
+//
+// A record payload given as a JSON string is the string's bytes; any
+// other JSON value is its encoding. Payloads are returned as JSON if they
+// are valid JSON, as a string if they are UTF-8 and in payload_base64
+// otherwise.
+const (
+	gatewayWait     = 10 * time.Second
+	gatewayMaxBody  = 64 << 20
+	gatewayEngineID = "planx-gateway"
+)
+
+type gateway struct {
+	servers Servers
+	codec   batch.Codec
+
+	mu       sync.Mutex
+	sessions map[string]*gatewaySession
+}
+
+type gatewaySession struct {
+	role string
+	md   metadata.MD
+
+	// Source sessions only: the stream's batches, and its result once
+	// done is closed.
+	batches chan *pb.Batch
+	stop    context.CancelFunc
+	done    chan struct{}
+	err     error
+}
+
+type gatewayCreate struct {
+	Role      string          `json:"role"`
+	Connector string          `json:"connector"`
+	TenantID  string          `json:"tenant_id"`
+	Config    json.RawMessage `json:"config"`
+
+	Mode          string    `json:"mode"`
+	BackfillStart time.Time `json:"backfill_start"`
+	BackfillEnd   time.Time `json:"backfill_end"`
+}
+
+type gatewayBatch struct {
+	Records  []gatewayRecord   `json:"records"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type gatewayRecord struct {
+	Payload       json.RawMessage   `json:"payload,omitempty"`
+	PayloadBase64 []byte            `json:"payload_base64,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+func newGateway(r *Process, servers Servers) http.Handler {
+	g := &gateway{servers: servers, codec: batch.NewCodec(), sessions: make(map[string]*gatewaySession)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", g.create)
+	mux.HandleFunc("DELETE /sessions/{id}", g.close)
+	mux.HandleFunc("POST /sessions/{id}/batches", g.write)
+	mux.HandleFunc("GET /sessions/{id}/batches", g.read)
+	return r.requireToken(mux)
+}
+
+// serveGateway serves the gateway onto servers on addr.
+func serveGateway(addr string, r *Process, servers Servers) {
+	h := newGateway(r, servers)
+	go func() {
+		if err := http.ListenAndServe(addr, h); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.log.Error("planx: gateway stopped", "address", addr, "error", err)
+		}
+	}()
+	r.log.Warn("planx: HTTP gateway enabled, for development only", "address", addr)
+}
+
+func (g *gateway) create(w http.ResponseWriter, req *http.Request) {
+	var in gatewayCreate
+	if err := decodeGateway(w, req, &in); err != nil {
+		gatewayError(w, err)
+		return
+	}
+	md := metadata.Pairs("x-planx-engine-id", gatewayEngineID)
+	if in.Connector != "" {
+		md.Set("x-planx-connector", in.Connector)
+	}
+	if in.TenantID != "" {
+		md.Set("x-planx-tenant-id", in.TenantID)
+	}
+	SetModeMetadata(md, session.Mode{Name: in.Mode, Start: in.BackfillStart, End: in.BackfillEnd})
+	ctx := metadata.NewIncomingContext(req.Context(), md)
+	create := &pb.SessionCreateRequest{Config: in.Config}
+
+	var resp *pb.SessionCreateResponse
+	var err error
+	switch in.Role {
+	case "source":
+		if g.servers.Source != nil {
+			resp, err = g.servers.Source.CreateSession(ctx, create)
+		}
+	case "sink":
+		if g.servers.Sink != nil {
+			resp, err = g.servers.Sink.CreateSession(ctx, create)
+		}
+	case "processor":
+		if g.servers.Processor != nil {
+			resp, err = g.servers.Processor.CreateSession(ctx, create)
+		}
+	default:
+		err = status.Errorf(codes.InvalidArgument, "role must be source, sink or processor, not %q", in.Role)
+	}
+	if err == nil && resp == nil {
+		err = status.Errorf(codes.NotFound, "plugin has no %s connectors", in.Role)
+	}
+	if err != nil {
+		gatewayError(w, err)
+		return
+	}
+
+	md = md.Copy()
+	md.Set("x-planx-session-id", resp.SessionId)
+	sess := &gatewaySession{role: in.Role, md: md}
+	if in.Role == "source" {
+		g.openStream(sess, resp.SessionId)
+	}
+	g.mu.Lock()
+	g.sessions[resp.SessionId] = sess
+	g.mu.Unlock()
+
+	writeGateway(w, http.StatusCreated, map[string]string{"session_id": resp.SessionId, "role": in.Role})
+}
+
+// openStream runs the stream of a source session until it is closed.
+func (g *gateway) openStream(sess *gatewaySession, id string) {
+	ctx, stop := context.WithCancel(metadata.NewIncomingContext(context.Background(), sess.md))
+	sess.batches, sess.stop, sess.done = make(chan *pb.Batch), stop, make(chan struct{})
+	go func() {
+		defer close(sess.done)
+		sess.err = g.servers.Source.OpenStream(
+			&pb.StreamOpenRequest{SessionId: id, InitialWindow: 1},
+			&gatewayStream{ctx: ctx, out: sess.batches},
+		)
+	}()
+}
+
+func (g *gateway) session(w http.ResponseWriter, req *http.Request) (string, *gatewaySession, bool) {
+	id := req.PathValue("id")
+	g.mu.Lock()
+	sess, ok := g.sessions[id]
+	g.mu.Unlock()
+	if !ok {
+		gatewayError(w, status.Errorf(codes.NotFound, "session %q not found", id))
+	}
+	return id, sess, ok
+}
+
+func (g *gateway) write(w http.ResponseWriter, req *http.Request) {
+	_, sess, ok := g.session(w, req)
+	if !ok {
+		return
+	}
+	var in gatewayBatch
+	if err := decodeGateway(w, req, &in); err != nil {
+		gatewayError(w, err)
+		return
+	}
+	packed, err := g.codec.Pack(in.batch())
+	if err != nil {
+		gatewayError(w, err)
+		return
+	}
+	ctx := metadata.NewIncomingContext(req.Context(), sess.md)
+	msg := &pb.Batch{Payload: packed}
+
+	switch sess.role {
+	case "sink":
+		if _, err := g.servers.Sink.WriteBatch(ctx, msg); err != nil {
+			gatewayError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "processor":
+		out, err := g.servers.Processor.Process(ctx, msg)
+		if err != nil {
+			gatewayError(w, err)
+			return
+		}
+		b, err := g.codec.Unpack(out.Payload)
+		if err != nil {
+			gatewayError(w, err)
+			return
+		}
+		writeGateway(w, http.StatusOK, newGatewayBatch(b))
+	default:
+		gatewayError(w, status.Error(codes.FailedPrecondition, "batches are read from source sessions, not written"))
+	}
+}
+
+func (g *gateway) read(w http.ResponseWriter, req *http.Request) {
+	id, sess, ok := g.session(w, req)
+	if !ok {
+		return
+	}
+	if sess.role != "source" {
+		gatewayError(w, status.Errorf(codes.FailedPrecondition, "batches are written to %s sessions, not read", sess.role))
+		return
+	}
+	wait := gatewayWait
+	if v := req.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			gatewayError(w, status.Errorf(codes.InvalidArgument, "wait: %v", err))
+			return
+		}
+		wait = d
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case msg := <-sess.batches:
+		b, err := g.codec.Unpack(msg.Payload)
+		if err != nil {
+			gatewayError(w, err)
+			return
+		}
+		if _, isBarrier := BarrierID(b); !isBarrier {
+			g.servers.Source.Ack(metadata.NewIncomingContext(req.Context(), sess.md),
+				&pb.AckRequest{SessionId: id, NewWindow: 1})
+		}
+		writeGateway(w, http.StatusOK, newGatewayBatch(b))
+	case <-sess.done:
+		if sess.err != nil && !errors.Is(sess.err, io.EOF) && !errors.Is(sess.err, context.Canceled) {
+			gatewayError(w, sess.err)
+			return
+		}
+		writeGateway(w, http.StatusGone, map[string]string{"error": "source stream ended"})
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+	case <-req.Context().Done():
+	}
+}
+
+func (g *gateway) close(w http.ResponseWriter, req *http.Request) {
+	id, sess, ok := g.session(w, req)
+	if !ok {
+		return
+	}
+	g.mu.Lock()
+	delete(g.sessions, id)
+	g.mu.Unlock()
+
+	ctx := metadata.NewIncomingContext(req.Context(), sess.md)
+	close := &pb.SessionCloseRequest{SessionId: id}
+	var err error
+	switch sess.role {
+	case "source":
+		sess.stop()
+		<-sess.done
+		_, err = g.servers.Source.CloseSession(ctx, close)
+	case "sink":
+		_, err = g.servers.Sink.CloseSession(ctx, close)
+	case "processor":
+		_, err = g.servers.Processor.CloseSession(ctx, close)
+	}
+	if err != nil {
+		gatewayError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (in gatewayBatch) batch() *batch.Batch {
+	b := &batch.Batch{Metadata: in.Metadata, Records: make([]batch.Record, len(in.Records))}
+	for i, rec := range in.Records {
+		b.Records[i] = batch.Record{Payload: rec.payload(), Metadata: rec.Metadata}
+	}
+	return b
+}
+
+func (rec gatewayRecord) payload() []byte {
+	if rec.Payload == nil {
+		return rec.PayloadBase64
+	}
+	var s string
+	if json.Unmarshal(rec.Payload, &s) == nil {
+		return []byte(s)
+	}
+	return rec.Payload
+}
+
+func newGatewayBatch(b *batch.Batch) gatewayBatch {
+	out := gatewayBatch{Metadata: b.Metadata, Records: make([]gatewayRecord, len(b.Records))}
+	for i, rec := range b.Records {
+		out.Records[i].Metadata = rec.Metadata
+		switch {
+		case json.Valid(rec.Payload):
+			out.Records[i].Payload = json.RawMessage(rec.Payload)
+		case utf8.Valid(rec.Payload):
+			s, _ := json.Marshal(string(rec.Payload))
+			out.Records[i].Payload = s
+		default:
+			out.Records[i].PayloadBase64 = rec.Payload
+		}
+	}
+	return out
+}
+
+func decodeGateway(w http.ResponseWriter, req *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, gatewayMaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return status.Errorf(codes.InvalidArgument, "request body: %v", err)
+	}
+	return nil
+}
+
+func writeGateway(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// gatewayError answers with the HTTP status closest to the gRPC status of
+// err.
+func gatewayError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.OutOfRange:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted, codes.FailedPrecondition:
+		code = http.StatusConflict
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	case codes.Unimplemented:
+		code = http.StatusNotImplemented
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		code = http.StatusGatewayTimeout
+	}
+	msg := err.Error()
+	if s, ok := status.FromError(err); ok {
+		msg = s.Message()
+	}
+	writeGateway(w, code, map[string]string{"error": msg})
+}
+
+// gatewayStream is the server side of a source stream opened by the
+// gateway, handing batches to out until ctx is done.
+type gatewayStream struct {
+	ctx context.Context
+	out chan<- *pb.Batch
+}
+
+func (s *gatewayStream) Send(b *pb.Batch) error {
+	select {
+	case s.out <- b:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *gatewayStream) Context() context.Context     { return s.ctx }
+func (s *gatewayStream) SetHeader(metadata.MD) error  { return nil }
+func (s *gatewayStream) SendHeader(metadata.MD) error { return nil }
+func (s *gatewayStream) SetTrailer(metadata.MD)       {}
+func (s *gatewayStream) SendMsg(m any) error          { return s.Send(m.(*pb.Batch)) }
+func (s *gatewayStream) RecvMsg(any) error            { return io.EOF }
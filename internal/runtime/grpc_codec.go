@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// batchPayloadField is the field number of Batch.payload, its only field.
+const batchPayloadField protowire.Number = 1
+
+// payloadPool holds the buffers incoming Batch payloads are read into.
+var payloadPool = mem.DefaultBufferPool()
+
+// batchCodec is the gRPC codec of the plugin servers. It encodes Batch
+// messages itself, as they hold nothing but a packed batch: Marshal sends
+// the payload as it is behind a field header instead of copying it into
+// a marshaled message, and Unmarshal copies it once, into a buffer from
+// payloadPool that releaseBatches returns when the call is done. Other
+// messages, and Batch messages with fields this version does not know,
+// go through the proto codec.
+type batchCodec struct {
+	proto encoding.CodecV2
+}
+
+func newBatchCodec() batchCodec {
+	return batchCodec{proto: encoding.GetCodecV2(proto.Name)}
+}
+
+func (c batchCodec) Name() string { return proto.Name }
+
+func (c batchCodec) Marshal(v any) (mem.BufferSlice, error) {
+	b, ok := v.(*pb.Batch)
+	if !ok {
+		return c.proto.Marshal(v)
+	}
+	payload := b.GetPayload()
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	header := protowire.AppendTag(make([]byte, 0, 2*binary.MaxVarintLen64), batchPayloadField, protowire.BytesType)
+	header = protowire.AppendVarint(header, uint64(len(payload)))
+	return mem.BufferSlice{mem.SliceBuffer(header), mem.SliceBuffer(payload)}, nil
+}
+
+func (c batchCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	b, ok := v.(*pb.Batch)
+	if !ok {
+		return c.proto.Unmarshal(data, v)
+	}
+	if data.Len() == 0 {
+		b.Payload = nil
+		return nil
+	}
+	r := data.Reader()
+	defer r.Close()
+	n, err := readBatchHeader(r)
+	if err != nil {
+		return c.proto.Unmarshal(data, v)
+	}
+	buf := payloadPool.Get(n)
+	if _, err := io.ReadFull(r, *buf); err != nil {
+		payloadPool.Put(buf)
+		return err
+	}
+	b.Payload = *buf
+	return nil
+}
+
+var errBatchLayout = errors.New("planx: batch message is not a single payload")
+
+// readBatchHeader reads the header of the payload field from r and
+// returns the payload length, which must be all that follows.
+func readBatchHeader(r *mem.Reader) (int, error) {
+	var header []byte
+	for len(header) < 2*binary.MaxVarintLen64 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		header = append(header, c)
+		num, typ, tn := protowire.ConsumeTag(header)
+		if tn < 0 {
+			continue
+		}
+		if num != batchPayloadField || typ != protowire.BytesType {
+			return 0, errBatchLayout
+		}
+		n, vn := protowire.ConsumeVarint(header[tn:])
+		if vn < 0 {
+			continue
+		}
+		if n != uint64(r.Remaining()) {
+			return 0, errBatchLayout
+		}
+		return int(n), nil
+	}
+	return 0, errBatchLayout
+}
+
+// releaseBatches returns the payload of an incoming Batch to payloadPool
+// once the call is done with it. It runs first, around every other
+// interceptor, so that calls they reject are covered too.
+func releaseBatches(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if b, ok := req.(*pb.Batch); ok && len(b.Payload) > 0 {
+		defer payloadPool.Put(&b.Payload)
+	}
+	return handler(ctx, req)
+}
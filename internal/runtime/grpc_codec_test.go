@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"bytes"
+	"testing"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"google.golang.org/grpc/mem"
+)
+
+// FuzzBatchCodecUnmarshal feeds the Batch header parser arbitrary wire
+// data: it must fail or read a payload that marshals back to the same
+// message.
+func FuzzBatchCodecUnmarshal(f *testing.F) {
+	c := newBatchCodec()
+	for _, payload := range [][]byte{[]byte("packed"), bytes.Repeat([]byte{0x80}, 200)} {
+		m, err := c.Marshal(&pb.Batch{Payload: payload})
+		if err != nil {
+			f.Fatal(err)
+		}
+		msg := m.Materialize()
+		f.Add(msg)
+		f.Add(msg[:len(msg)-1])
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x0a})
+	f.Add([]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add([]byte{0x12, 0x01, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var b pb.Batch
+		if err := c.Unmarshal(mem.BufferSlice{mem.SliceBuffer(data)}, &b); err != nil {
+			return
+		}
+		if !bytes.HasSuffix(data, b.Payload) {
+			t.Fatalf("payload %x is not the tail of %x", b.Payload, data)
+		}
+		m, err := c.Marshal(&b)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var again pb.Batch
+		if err := c.Unmarshal(m, &again); err != nil {
+			t.Fatalf("Unmarshal of a marshaled batch: %v", err)
+		}
+		if !bytes.Equal(b.Payload, again.Payload) {
+			t.Fatalf("payload %x came back as %x", b.Payload, again.Payload)
+		}
+	})
+}
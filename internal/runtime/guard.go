@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type PanicPolicy string
+
+const (
+	// PanicRecover converts panics in SPI methods into errors for the
+	// session that triggered them. This is the default.
+	PanicRecover PanicPolicy = "recover"
+	// PanicCrash lets panics propagate and terminate the process.
+	PanicCrash PanicPolicy = "crash"
+)
+
+type panicError struct {
+	method string
+	value  any
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("plugin panic in %s: %v", e.method, e.value)
+}
+
+func (e *panicError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, e.Error())
+}
+
+// guard runs an SPI call under the configured panic policy.
+func (r *Process) guard(meta *sessionMeta, method string, fn func() error) (err error) {
+	if r.cfg.PanicPolicy == PanicCrash {
+		return fn()
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			r.panics.Add(1)
+			meta.panics.Add(1)
+			r.metrics.Panic(meta.labels, method)
+			meta.log.Error("planx: recovered panic in plugin",
+				"method", method,
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()),
+			)
+			err = &panicError{method: method, value: v}
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Hot upgrades. A process started with handoff_socket listens on it
+// while it serves. A new process started with the same socket, before it
+// serves, exchanges JSON messages with the one listening:
+//
+//	new → old  {"type": "handoff"}
+//	old → new  {"type": "savepoint", "savepoint": {...}}
+//	new → old  {"type": "ready", "address": "..."}
+//	old → new  {"type": "released"}
+//
+// On the request the old process refuses new sessions and batches with
+// UNAVAILABLE and exports its sessions as a savepoint, which the new one
+// imports and names in the savepoint field of its handshake: the engine
+// recreates the sessions there, restored from it. Once the new process
+// is ready, the old one gives up the socket and drains: it waits
+// handoff_drain_timeout for the engine to close its sessions, closes
+// what remains and stops. If the new process fails before it is ready,
+// the old one carries on serving, its sessions untouched since the
+// savepoint. Sessions it closes once they are handed over keep their
+// state, ephemeral ones included, for the new process to restore.
+//
+// Both processes share the state backend during the overlap, a file
+// backend's dir included: the engine must close a session in the old
+// process before it recreates it in the new one.
+const (
+	handoffRequest  = "handoff"
+	handoffExport   = "savepoint"
+	handoffReady    = "ready"
+	handoffReleased = "released"
+	handoffFailed   = "error"
+
+	// handoffTimeout bounds each step of the exchange.
+	handoffTimeout = time.Minute
+	drainPoll      = 100 * time.Millisecond
+)
+
+type handoffMessage struct {
+	Type      string     `json:"type"`
+	Savepoint *Savepoint `json:"savepoint,omitempty"`
+	Address   string     `json:"address,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+var errHandingOff = status.Error(codes.Unavailable, "plugin is handing its sessions over to a new process")
+
+// handoff is the new process's side of a handoff in progress.
+type handoff struct {
+	conn      net.Conn
+	dec       *json.Decoder
+	enc       *json.Encoder
+	savepoint *Savepoint
+}
+
+// takeOver imports the sessions of the process listening on the handoff
+// socket at path. It returns nil if no process is listening.
+func takeOver(path string, r *Process) (*handoff, error) {
+	conn, err := net.DialTimeout("unix", path, handoffTimeout)
+	if err != nil {
+		// No socket, or a stale one left by a process that is gone.
+		return nil, nil
+	}
+	h := &handoff{conn: conn, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn)}
+	resp, err := h.exchange(handoffMessage{Type: handoffRequest}, handoffExport)
+	if err == nil && resp.Savepoint == nil {
+		err = errors.New("no savepoint")
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+		err = r.ImportSavepoint(ctx, resp.Savepoint)
+		cancel()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("planx: handoff from %s: %w", path, err)
+	}
+	h.savepoint = resp.Savepoint
+	r.log.Info("planx: sessions handed over", "savepoint_id", h.savepoint.ID, "sessions", len(h.savepoint.Sessions))
+	return h, nil
+}
+
+// ready tells the old process that this one serves on address and waits
+// for it to give up the socket.
+func (h *handoff) ready(address string) error {
+	defer h.conn.Close()
+	_, err := h.exchange(handoffMessage{Type: handoffReady, Address: address}, handoffReleased)
+	return err
+}
+
+// exchange sends msg and reads the answer, which must be of type want.
+func (h *handoff) exchange(msg handoffMessage, want string) (handoffMessage, error) {
+	var resp handoffMessage
+	h.conn.SetDeadline(time.Now().Add(handoffTimeout))
+	if err := h.enc.Encode(msg); err != nil {
+		return resp, err
+	}
+	if err := h.dec.Decode(&resp); err != nil {
+		return resp, err
+	}
+	switch resp.Type {
+	case want:
+		return resp, nil
+	case handoffFailed:
+		return resp, errors.New(resp.Error)
+	default:
+		return resp, fmt.Errorf("unexpected %q message", resp.Type)
+	}
+}
+
+// serveHandoff listens on the handoff socket at path, handing the
+// process's sessions over to the first new process that completes the
+// exchange, then calls stop once they are drained.
+func serveHandoff(path string, r *Process, stop func()) error {
+	os.Remove(path)
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("planx: handoff socket: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			if r.handOff(conn, func() { lis.Close() }) {
+				r.drain(stop)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// handOff runs the old process's side of the exchange on conn, calling
+// release to give up the socket before telling the new process it may
+// listen on it. It reports whether the new process took the sessions
+// over.
+func (r *Process) handOff(conn net.Conn, release func()) (done bool) {
+	defer conn.Close()
+	dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	var msg handoffMessage
+	if err := dec.Decode(&msg); err != nil || msg.Type != handoffRequest {
+		return false
+	}
+
+	r.handingOff.Store(true)
+	var sp *Savepoint
+	defer func() {
+		if !done {
+			r.handOver(sp, false)
+			r.handingOff.Store(false)
+			r.log.Warn("planx: handoff aborted, serving on")
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+	sp, err := r.Savepoint(ctx, "handoff-"+util.NewSessionID(), nil)
+	cancel()
+	if err != nil {
+		r.log.Error("planx: handoff savepoint failed", "error", err)
+		enc.Encode(handoffMessage{Type: handoffFailed, Error: err.Error()})
+		return false
+	}
+	// The sessions exported keep their state when closed from now on,
+	// even ephemeral ones: the new process restores them from it.
+	r.handOver(sp, true)
+	if err := enc.Encode(handoffMessage{Type: handoffExport, Savepoint: sp}); err != nil {
+		return false
+	}
+
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	if err := dec.Decode(&msg); err != nil || msg.Type != handoffReady {
+		return false
+	}
+	r.log.Info("planx: handed sessions over, draining", "savepoint_id", sp.ID, "address", msg.Address)
+	release()
+	enc.Encode(handoffMessage{Type: handoffReleased})
+	return true
+}
+
+// handOver marks the sessions of sp as handed over, or no longer if
+// aborted.
+func (r *Process) handOver(sp *Savepoint, handedOver bool) {
+	if sp == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handedOver == nil {
+		r.handedOver = make(map[string]bool)
+	}
+	for _, s := range sp.Sessions {
+		if handedOver {
+			r.handedOver[s.SessionID] = true
+		} else {
+			delete(r.handedOver, s.SessionID)
+		}
+	}
+}
+
+// drain waits until the engine has closed every session or
+// handoff_drain_timeout has passed, closes the sessions left and calls
+// stop.
+func (r *Process) drain(stop func()) {
+	r.mu.Lock()
+	servers := append([]sessionServer(nil), r.servers...)
+	r.mu.Unlock()
+	open := func() (n int) {
+		for _, s := range servers {
+			n += len(s.snapshots())
+		}
+		return n
+	}
+
+	deadline := time.Now().Add(r.cfg.HandoffDrainTimeout)
+	for open() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPoll)
+	}
+	for _, s := range servers {
+		for _, sess := range s.snapshots() {
+			s.forceClose(context.Background(), sess.SessionID)
+		}
+	}
+	r.log.Info("planx: drained, stopping")
+	stop()
+}
@@ -0,0 +1,62 @@
+package runtime
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// flowPolicy builds the flow-control policy of a session being created.
+// The engine may override the configured flow_policy per session with
+// the x-planx-flow-policy metadata.
+func (r *Process) flowPolicy(ctx context.Context, initial int) (flow.Policy, error) {
+	spec := r.cfg.FlowPolicy
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-planx-flow-policy"); len(v) > 0 {
+			spec = v[0]
+		}
+	}
+
+	p, err := flow.ParsePolicy(spec, initial)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return p, nil
+}
+
+// inboundPolicy returns the policy limiting batches in flight toward a
+// sink or processor session. A credit policy without an inbound_window
+// is unlimited.
+func (r *Process) inboundPolicy(ctx context.Context) (flow.Policy, error) {
+	p, err := r.flowPolicy(ctx, r.cfg.InboundWindow)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.(*flow.Window); ok && r.cfg.InboundWindow <= 0 {
+		return flow.Unlimited{}, nil
+	}
+	return p, nil
+}
+
+// admit takes an inbound credit for one batch, waiting until the call's
+// deadline, and advertises the remaining credits in the x-planx-window
+// response header. The returned func gives the credit back.
+func admit(ctx context.Context, p flow.Policy) (func(), error) {
+	if _, ok := p.(flow.Unlimited); ok {
+		return func() {}, nil
+	}
+
+	if !p.TryAcquire() {
+		if err := p.AcquireContext(ctx); err != nil {
+			return nil, status.Error(codes.ResourceExhausted, "session inbound window exhausted")
+		}
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-planx-window", strconv.Itoa(p.Stats().Credits)))
+	return func() { p.Release(1) }, nil
+}
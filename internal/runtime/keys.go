@@ -0,0 +1,194 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+)
+
+// KeyProvider encrypts and decrypts SDK-managed secrets. Encrypt uses
+// the current key and reports its ID; Decrypt must accept every key ID
+// still in use, so keys can be rotated without re-encrypting old data.
+// Implementations may call out to a KMS or Vault transit.
+type KeyProvider interface {
+	Encrypt(ctx context.Context, plaintext []byte) (keyID string, ciphertext []byte, err error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// encPrefix marks an encrypted config value:
+// "enc:v1:<key id>:<base64 ciphertext>".
+const encPrefix = "enc:v1:"
+
+// EncryptValue encrypts plaintext with kp into the form accepted in
+// connector configs, either as the whole config or as a string field.
+func EncryptValue(ctx context.Context, kp KeyProvider, plaintext []byte) (string, error) {
+	keyID, ct, err := kp.Encrypt(ctx, plaintext)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(keyID, ":") {
+		return "", fmt.Errorf("planx: key id %q must not contain ':'", keyID)
+	}
+	return encPrefix + keyID + ":" + base64.StdEncoding.EncodeToString(ct), nil
+}
+
+// DecryptValue reverses EncryptValue. It accepts any key kp still
+// knows, so values written before a key rotation remain readable.
+func DecryptValue(ctx context.Context, kp KeyProvider, v string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(v, encPrefix)
+	if !ok {
+		return nil, errors.New("not an encrypted value")
+	}
+	keyID, data, ok := strings.Cut(rest, ":")
+	if !ok {
+		return nil, errors.New("malformed encrypted value")
+	}
+	ct, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	return kp.Decrypt(ctx, keyID, ct)
+}
+
+// decryptConfig returns config with encrypted parts replaced by their
+// plaintext: the whole blob if it is one encrypted value, otherwise
+// every JSON string field holding one. Decrypted fields are added to
+// secrets. Configs without encrypted values are returned unchanged.
+func (r *Process) decryptConfig(ctx context.Context, config []byte, secrets *logging.Secrets) ([]byte, error) {
+	if !bytes.Contains(config, []byte(encPrefix)) {
+		return config, nil
+	}
+	if r.keys == nil {
+		return nil, CategorizeError(ErrUserConfig, errors.New("planx: config is encrypted but the plugin has no key provider"))
+	}
+
+	if trimmed := bytes.TrimSpace(config); bytes.HasPrefix(trimmed, []byte(encPrefix)) {
+		plain, err := DecryptValue(ctx, r.keys, string(trimmed))
+		if err != nil {
+			return nil, CategorizeError(ErrUserConfig, fmt.Errorf("planx: decrypt config: %w", err))
+		}
+		return plain, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		// Not JSON: leave it to the SPI to reject.
+		return config, nil
+	}
+	doc, err := r.decryptFields(ctx, doc, "", secrets)
+	if err != nil {
+		return nil, CategorizeError(ErrUserConfig, err)
+	}
+	return json.Marshal(doc)
+}
+
+func (r *Process) decryptFields(ctx context.Context, v any, path string, secrets *logging.Secrets) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.HasPrefix(v, encPrefix) {
+			return v, nil
+		}
+		plain, err := DecryptValue(ctx, r.keys, v)
+		if err != nil {
+			return nil, fmt.Errorf("planx: decrypt config field %s: %w", path, err)
+		}
+		secrets.Add(string(plain))
+		return string(plain), nil
+	case map[string]any:
+		for k, e := range v {
+			d, err := r.decryptFields(ctx, e, path+"."+k, secrets)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = d
+		}
+	case []any:
+		for i, e := range v {
+			d, err := r.decryptFields(ctx, e, fmt.Sprintf("%s[%d]", path, i), secrets)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = d
+		}
+	}
+	return v, nil
+}
+
+// sealedLines encrypts each line written to w as a separate value, so a
+// file of them can be appended to across restarts and key rotations.
+type sealedLines struct {
+	w    io.Writer
+	keys KeyProvider
+}
+
+func (s *sealedLines) Write(line []byte) (int, error) {
+	v, err := EncryptValue(context.Background(), s.keys, bytes.TrimSuffix(line, []byte("\n")))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(s.w, v+"\n"); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
+
+// localKeys is a KeyProvider over AES-256-GCM keys held in memory.
+type localKeys struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider returns a KeyProvider that encrypts with the
+// 32-byte AES-256-GCM key keys[current] and decrypts with any of keys.
+func NewLocalKeyProvider(current string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("planx: current key %q not in key set", current)
+	}
+	lk := &localKeys{current: current, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("planx: key %q must be 32 bytes, got %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if lk.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return lk, nil
+}
+
+func (k *localKeys) Encrypt(_ context.Context, plaintext []byte) (string, []byte, error) {
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.current, aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (k *localKeys) Decrypt(_ context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	aead, ok := k.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ct, nil)
+}
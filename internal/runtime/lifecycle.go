@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+)
+
+// Optional SPI capabilities. SDK wrappers always implement these and
+// return errors.ErrUnsupported when the plugin SPI does not.
+
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+type Checkpointer interface {
+	Checkpoint(ctx context.Context) ([]byte, error)
+	Restore(ctx context.Context, checkpoint []byte) error
+}
+
+// Snapshotter takes part in checkpoint barriers: Snapshot persists
+// checkpoint id of the SPI and its state and returns its size in bytes.
+type Snapshotter interface {
+	Snapshot(ctx context.Context, id string) (int, error)
+}
+
+// Committer is told when a checkpoint it took is complete across the
+// pipeline.
+type Committer interface {
+	Commit(ctx context.Context, id string) error
+}
+
+// CheckpointFailureHandler is told when a checkpoint of the SPI failed,
+// with the number of its checkpoints in a row that have.
+type CheckpointFailureHandler interface {
+	OnCheckpointFailed(ctx context.Context, id string, err error, consecutive int)
+}
+
+// Savepointer exports checkpoint id of the SPI, as stored, with the
+// state namespace it belongs to.
+type Savepointer interface {
+	ExportCheckpoint(ctx context.Context, id string) (namespace string, data []byte, err error)
+}
+
+// HandOverer is told, before it is closed, that its session was handed
+// over to another plugin process, which now owns the session's state.
+type HandOverer interface {
+	HandOver()
+}
+
+type Seeker interface {
+	Seek(ctx context.Context, position []byte) error
+}
+
+type ConfigUpdater interface {
+	UpdateConfig(ctx context.Context, config []byte) error
+}
+
+func supported(err error) error {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
+
+// shutdownSPI drains and flushes the SPI, when it supports it, then
+// closes it. Close is called even if draining or flushing fails.
+func shutdownSPI(ctx context.Context, spi interface{ Close() error }) error {
+	var errs []error
+	if d, ok := spi.(Drainer); ok {
+		errs = append(errs, supported(d.Drain(ctx)))
+	}
+	if f, ok := spi.(Flusher); ok {
+		errs = append(errs, supported(f.Flush(ctx)))
+	}
+	errs = append(errs, spi.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,15 @@
+package runtime
+
+import "log/slog"
+
+// Logger is the subset of sdk.Logger the runtime logs through.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+}
+
+func defaultLogger() Logger {
+	return slog.Default()
+}
@@ -0,0 +1,153 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"google.golang.org/grpc"
+)
+
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+type MetricsBackend string
+
+const (
+	MetricsPrometheus MetricsBackend = "prometheus"
+	MetricsOTLP       MetricsBackend = "otlp"
+	MetricsNone       MetricsBackend = "none"
+)
+
+// Labels are the dimensions every SDK measurement carries.
+type Labels struct {
+	Role      string
+	Connector string
+}
+
+// Metrics receives instrumentation events from the plugin servers.
+type Metrics interface {
+	SessionCreated(l Labels)
+	SessionClosed(l Labels)
+	Batch(l Labels, direction string, records, bytes int)
+	SPICall(l Labels, method string, d time.Duration, err error)
+	Panic(l Labels, method string)
+	Error(l Labels, category ErrorCategory)
+	// RPC records the time a unary gRPC handler took end to end,
+	// including decoding, admission and the SPI call.
+	RPC(method string, d time.Duration, err error)
+	// Session returns the recorder of the measurements the SPI of a
+	// session defines, and the func to call once the session is gone.
+	Session(l Labels, sessionID, tenantID string) (session.Metrics, func())
+}
+
+// FlowSnapshot is the flow-control state of one session.
+type FlowSnapshot struct {
+	SessionID string
+	Labels    Labels
+	Stats     flow.Stats
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) SessionCreated(Labels)                        {}
+func (nopMetrics) SessionClosed(Labels)                         {}
+func (nopMetrics) Batch(Labels, string, int, int)               {}
+func (nopMetrics) SPICall(Labels, string, time.Duration, error) {}
+func (nopMetrics) Panic(Labels, string)                         {}
+func (nopMetrics) Error(Labels, ErrorCategory)                  {}
+func (nopMetrics) RPC(string, time.Duration, error)             {}
+
+func (nopMetrics) Session(Labels, string, string) (session.Metrics, func()) {
+	return nopSessionMetrics{}, func() {}
+}
+
+type nopSessionMetrics struct{}
+
+func (nopSessionMetrics) Add(string, float64)     {}
+func (nopSessionMetrics) Set(string, float64)     {}
+func (nopSessionMetrics) Observe(string, float64) {}
+
+// pluginMetricName turns a plugin-defined measurement name into one
+// valid for both backends, joined to prefix with sep: characters other
+// than letters, digits and underscores become underscores.
+func pluginMetricName(prefix, sep, name string) string {
+	b := []byte(prefix + sep)
+	for _, c := range []byte(name) {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// sessionServer is implemented by the plugin servers so the process can
+// inspect and manage the sessions they hold.
+type sessionServer interface {
+	snapshots() []SessionSnapshot
+	// forceClose closes a session as if the engine had called
+	// CloseSession. It reports whether the session existed.
+	forceClose(ctx context.Context, id string) bool
+	// savepoint checkpoints the sessions want selects as savepoint id.
+	savepoint(ctx context.Context, id string, want func(string) bool) ([]SavepointSession, error)
+}
+
+func (r *Process) addServer(s sessionServer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.servers = append(r.servers, s)
+}
+
+// Sessions returns a snapshot of every open session.
+func (r *Process) Sessions() []SessionSnapshot {
+	r.mu.Lock()
+	servers := append([]sessionServer(nil), r.servers...)
+	r.mu.Unlock()
+
+	var out []SessionSnapshot
+	for _, s := range servers {
+		out = append(out, s.snapshots()...)
+	}
+	return out
+}
+
+// FlowStats returns the flow-control state of every open session.
+func (r *Process) FlowStats() []FlowSnapshot {
+	sessions := r.Sessions()
+	out := make([]FlowSnapshot, len(sessions))
+	for i, s := range sessions {
+		out[i] = s.FlowSnapshot
+	}
+	return out
+}
+
+// CloseSession force-closes the open session id of any role.
+func (r *Process) CloseSession(ctx context.Context, id string) bool {
+	r.mu.Lock()
+	servers := append([]sessionServer(nil), r.servers...)
+	r.mu.Unlock()
+
+	for _, s := range servers {
+		if s.forceClose(ctx, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeRPC is the unary interceptor behind Metrics.RPC.
+func (r *Process) timeRPC(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	r.metrics.RPC(info.FullMethod, time.Since(start), err)
+	return resp, err
+}
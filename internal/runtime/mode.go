@@ -0,0 +1,72 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Session modes. The engine sets x-planx-mode: backfill on CreateSession
+// for a session that reads historical data rather than tailing, with
+// optional RFC 3339 bounds in x-planx-backfill-start and
+// x-planx-backfill-end. SPIs read it from the session; without the
+// metadata a session is live.
+const (
+	modeMetadata          = "x-planx-mode"
+	backfillStartMetadata = "x-planx-backfill-start"
+	backfillEndMetadata   = "x-planx-backfill-end"
+)
+
+// sessionMode returns the mode the engine asked of a new session.
+func sessionMode(ctx context.Context) (session.Mode, error) {
+	var m session.Mode
+	switch m.Name = incoming(ctx, modeMetadata); m.Name {
+	case "":
+		m.Name = session.ModeLive
+	case session.ModeLive, session.ModeBackfill:
+	default:
+		return m, status.Errorf(codes.InvalidArgument, "unknown mode %q", m.Name)
+	}
+
+	for _, b := range []struct {
+		key string
+		t   *time.Time
+	}{
+		{backfillStartMetadata, &m.Start},
+		{backfillEndMetadata, &m.End},
+	} {
+		v := incoming(ctx, b.key)
+		if v == "" {
+			continue
+		}
+		if !m.Backfill() {
+			return m, status.Errorf(codes.InvalidArgument, "%s requires %s: %s", b.key, modeMetadata, session.ModeBackfill)
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return m, status.Errorf(codes.InvalidArgument, "%s: %v", b.key, err)
+		}
+		*b.t = t
+	}
+	if !m.Start.IsZero() && !m.End.IsZero() && m.End.Before(m.Start) {
+		return m, status.Errorf(codes.InvalidArgument, "backfill ends before it starts")
+	}
+	return m, nil
+}
+
+// SetModeMetadata sets the metadata asking a new session for mode m.
+func SetModeMetadata(md metadata.MD, m session.Mode) {
+	if m.Name != "" && m.Name != session.ModeLive {
+		md.Set(modeMetadata, m.Name)
+	}
+	if !m.Start.IsZero() {
+		md.Set(backfillStartMetadata, m.Start.Format(time.RFC3339Nano))
+	}
+	if !m.End.IsZero() {
+		md.Set(backfillEndMetadata, m.End.Format(time.RFC3339Nano))
+	}
+}
@@ -0,0 +1,384 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/status"
+)
+
+const meterName = "github.com/planx-lab/planx-sdk-go"
+
+// otelMetrics records the same instruments as the Prometheus backend
+// through an OpenTelemetry meter.
+type otelMetrics struct {
+	sessionsCreated metric.Int64Counter
+	sessionsActive  metric.Int64UpDownCounter
+	batches         metric.Int64Counter
+	records         metric.Int64Counter
+	bytes           metric.Int64Counter
+	spiCalls        metric.Int64Counter
+	spiErrors       metric.Int64Counter
+	spiDuration     metric.Float64Histogram
+	panics          metric.Int64Counter
+	errors          metric.Int64Counter
+	rpcDuration     metric.Float64Histogram
+
+	// plugin holds the instruments of the measurements SPIs define,
+	// created on first use; nil for those that failed.
+	meter  metric.Meter
+	log    Logger
+	mu     sync.Mutex
+	plugin map[string]any
+}
+
+// newOTLPMeterProvider exports over OTLP/gRPC. The endpoint defaults to
+// the standard OTEL_EXPORTER_OTLP_* environment variables when empty.
+func newOTLPMeterProvider(ctx context.Context, endpoint string) (*sdkmetric.MeterProvider, error) {
+	var opts []otlpmetricgrpc.Option
+	if endpoint != "" {
+		opts = append(opts, otlpmetricgrpc.WithEndpoint(endpoint))
+	}
+	exp, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp))), nil
+}
+
+func newOTelMetrics(mp metric.MeterProvider, proc *Process) (*otelMetrics, error) {
+	meter := mp.Meter(meterName)
+	m := &otelMetrics{meter: meter, log: proc.log, plugin: make(map[string]any)}
+
+	var err error
+	counter := func(name, desc string) metric.Int64Counter {
+		if err != nil {
+			return nil
+		}
+		var c metric.Int64Counter
+		c, err = meter.Int64Counter(name, metric.WithDescription(desc))
+		return c
+	}
+
+	m.sessionsCreated = counter("planx.sessions.created", "Sessions created.")
+	m.batches = counter("planx.batches", "Batches moved between the engine and the plugin.")
+	m.records = counter("planx.records", "Records moved between the engine and the plugin.")
+	m.bytes = counter("planx.bytes", "Packed batch bytes moved between the engine and the plugin.")
+	m.spiCalls = counter("planx.spi.calls", "Calls into plugin SPI methods.")
+	m.spiErrors = counter("planx.spi.errors", "SPI calls that returned an error.")
+	m.panics = counter("planx.spi.panics", "Panics recovered from plugin SPI methods.")
+	m.errors = counter("planx.errors", "Session and batch failures by error category.")
+	if err != nil {
+		return nil, err
+	}
+
+	if m.sessionsActive, err = meter.Int64UpDownCounter("planx.sessions.active",
+		metric.WithDescription("Sessions currently open.")); err != nil {
+		return nil, err
+	}
+	if m.spiDuration, err = meter.Float64Histogram("planx.spi.duration",
+		metric.WithDescription("Time spent in plugin SPI methods, excluding SDK overhead."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if m.rpcDuration, err = meter.Float64Histogram("planx.rpc.duration",
+		metric.WithDescription("Latency of unary plugin gRPC handlers, SDK overhead included."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+
+	if err := registerOTelFlow(meter, proc); err != nil {
+		return nil, err
+	}
+	if err := registerOTelTenants(meter, proc); err != nil {
+		return nil, err
+	}
+	if err := registerOTelState(meter, proc); err != nil {
+		return nil, err
+	}
+	if err := registerOTelCheckpoints(meter, proc); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func registerOTelFlow(meter metric.Meter, proc *Process) error {
+	credits, err := meter.Int64ObservableGauge("planx.flow.credits",
+		metric.WithDescription("Batches the session may currently move."))
+	if err != nil {
+		return err
+	}
+	stalls, err := meter.Int64ObservableCounter("planx.flow.stalls",
+		metric.WithDescription("Acquires that had to wait for flow-control credit."))
+	if err != nil {
+		return err
+	}
+	wait, err := meter.Float64ObservableCounter("planx.flow.wait",
+		metric.WithDescription("Time spent waiting for flow-control credit."), metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	acks, err := meter.Int64ObservableCounter("planx.flow.acks",
+		metric.WithDescription("Credit grants received from the engine."))
+	if err != nil {
+		return err
+	}
+	interval, err := meter.Float64ObservableGauge("planx.flow.ack_interval",
+		metric.WithDescription("Moving average time between credit grants."), metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, f := range proc.FlowStats() {
+			attrs := metric.WithAttributes(
+				attribute.String("role", f.Labels.Role),
+				attribute.String("connector", f.Labels.Connector),
+				attribute.String("session_id", f.SessionID),
+			)
+			o.ObserveInt64(credits, int64(f.Stats.Credits), attrs)
+			o.ObserveInt64(stalls, f.Stats.Stalls, attrs)
+			o.ObserveFloat64(wait, f.Stats.WaitTime.Seconds(), attrs)
+			o.ObserveInt64(acks, f.Stats.Releases, attrs)
+			o.ObserveFloat64(interval, f.Stats.ReleaseInterval.Seconds(), attrs)
+		}
+		return nil
+	}, credits, stalls, wait, acks, interval)
+	return err
+}
+
+func registerOTelTenants(meter metric.Meter, proc *Process) error {
+	records, err := meter.Int64ObservableCounter("planx.tenant.records",
+		metric.WithDescription("Records moved per tenant across all sessions."))
+	if err != nil {
+		return err
+	}
+	bytes, err := meter.Int64ObservableCounter("planx.tenant.bytes",
+		metric.WithDescription("Packed batch bytes moved per tenant across all sessions."), metric.WithUnit("By"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, u := range proc.TenantUsage() {
+			for dir, t := range map[string]TrafficStats{DirectionIn: u.In, DirectionOut: u.Out} {
+				attrs := metric.WithAttributes(
+					attribute.String("tenant_id", u.TenantID),
+					attribute.String("direction", dir),
+				)
+				o.ObserveInt64(records, t.Records, attrs)
+				o.ObserveInt64(bytes, t.Bytes, attrs)
+			}
+		}
+		return nil
+	}, records, bytes)
+	return err
+}
+
+func registerOTelCheckpoints(meter metric.Meter, proc *Process) error {
+	completed, err := meter.Int64ObservableCounter("planx.checkpoints",
+		metric.WithDescription("Checkpoints the session completed."))
+	if err != nil {
+		return err
+	}
+	failed, err := meter.Int64ObservableCounter("planx.checkpoint.failures",
+		metric.WithDescription("Checkpoints of the session that failed."))
+	if err != nil {
+		return err
+	}
+	consecutive, err := meter.Int64ObservableGauge("planx.checkpoint.consecutive_failures",
+		metric.WithDescription("Checkpoints of the session that failed since the last one completed."))
+	if err != nil {
+		return err
+	}
+	duration, err := meter.Float64ObservableCounter("planx.checkpoint.duration",
+		metric.WithDescription("Time the session spent taking checkpoints."), metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	lastDuration, err := meter.Float64ObservableGauge("planx.checkpoint.last_duration",
+		metric.WithDescription("Time the session's last completed checkpoint took."), metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	lastSize, err := meter.Int64ObservableGauge("planx.checkpoint.last_size",
+		metric.WithDescription("Size of the session's last completed checkpoint."), metric.WithUnit("By"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, s := range proc.Sessions() {
+			cp := s.Checkpoints
+			if cp.Completed+cp.Failed == 0 {
+				continue
+			}
+			attrs := metric.WithAttributes(
+				attribute.String("role", s.Labels.Role),
+				attribute.String("connector", s.Labels.Connector),
+				attribute.String("session_id", s.SessionID),
+			)
+			o.ObserveInt64(completed, cp.Completed, attrs)
+			o.ObserveInt64(failed, cp.Failed, attrs)
+			o.ObserveInt64(consecutive, cp.ConsecutiveFailures, attrs)
+			o.ObserveFloat64(duration, cp.Duration.Seconds(), attrs)
+			o.ObserveFloat64(lastDuration, cp.LastDuration.Seconds(), attrs)
+			o.ObserveInt64(lastSize, cp.LastSize, attrs)
+		}
+		return nil
+	}, completed, failed, consecutive, duration, lastDuration, lastSize)
+	return err
+}
+
+func registerOTelState(meter metric.Meter, proc *Process) error {
+	entries, err := meter.Int64ObservableGauge("planx.state.entries",
+		metric.WithDescription("Entries held by the state backend."))
+	if err != nil {
+		return err
+	}
+	bytes, err := meter.Int64ObservableGauge("planx.state.size",
+		metric.WithDescription("Size of the state backend's files."), metric.WithUnit("By"))
+	if err != nil {
+		return err
+	}
+	expired, err := meter.Int64ObservableCounter("planx.state.expired",
+		metric.WithDescription("State entries removed after their TTL."))
+	if err != nil {
+		return err
+	}
+	compactions, err := meter.Int64ObservableCounter("planx.state.compactions",
+		metric.WithDescription("State logs compacted."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		st, ok := proc.StateStats()
+		if !ok {
+			return nil
+		}
+		o.ObserveInt64(entries, st.Entries)
+		o.ObserveInt64(bytes, st.Bytes)
+		o.ObserveInt64(expired, st.Expired)
+		o.ObserveInt64(compactions, st.Compactions)
+		return nil
+	}, entries, bytes, expired, compactions)
+	return err
+}
+
+func otelAttrs(l Labels, kv ...string) metric.MeasurementOption {
+	attrs := []attribute.KeyValue{
+		attribute.String("role", l.Role),
+		attribute.String("connector", l.Connector),
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, attribute.String(kv[i], kv[i+1]))
+	}
+	return metric.WithAttributes(attrs...)
+}
+
+func (m *otelMetrics) SessionCreated(l Labels) {
+	ctx := context.Background()
+	m.sessionsCreated.Add(ctx, 1, otelAttrs(l))
+	m.sessionsActive.Add(ctx, 1, otelAttrs(l))
+}
+
+func (m *otelMetrics) SessionClosed(l Labels) {
+	m.sessionsActive.Add(context.Background(), -1, otelAttrs(l))
+}
+
+func (m *otelMetrics) Batch(l Labels, direction string, records, bytes int) {
+	ctx := context.Background()
+	attrs := otelAttrs(l, "direction", direction)
+	m.batches.Add(ctx, 1, attrs)
+	m.records.Add(ctx, int64(records), attrs)
+	m.bytes.Add(ctx, int64(bytes), attrs)
+}
+
+func (m *otelMetrics) SPICall(l Labels, method string, d time.Duration, err error) {
+	ctx := context.Background()
+	attrs := otelAttrs(l, "method", method)
+	m.spiCalls.Add(ctx, 1, attrs)
+	m.spiDuration.Record(ctx, d.Seconds(), attrs)
+	if err != nil {
+		m.spiErrors.Add(ctx, 1, attrs)
+	}
+}
+
+func (m *otelMetrics) Panic(l Labels, method string) {
+	m.panics.Add(context.Background(), 1, otelAttrs(l, "method", method))
+}
+
+func (m *otelMetrics) Error(l Labels, category ErrorCategory) {
+	m.errors.Add(context.Background(), 1, otelAttrs(l, "category", string(category)))
+}
+
+func (m *otelMetrics) RPC(method string, d time.Duration, err error) {
+	m.rpcDuration.Record(context.Background(), d.Seconds(), metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("code", status.Code(err).String()),
+	))
+}
+
+// Session needs no cleanup: the SDK's meter keeps what it recorded.
+func (m *otelMetrics) Session(l Labels, sessionID, tenantID string) (session.Metrics, func()) {
+	attrs := otelAttrs(l, "session_id", sessionID, "tenant_id", tenantID)
+	return otelSession{m: m, attrs: attrs}, func() {}
+}
+
+// pluginInstrument returns the instrument of the measurement name,
+// creating it with create if it is new, like pluginVec.
+func (m *otelMetrics) pluginInstrument(name string, create func(name string) (any, error)) any {
+	name = pluginMetricName("planx.plugin", ".", name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := m.plugin[name]; ok {
+		return i
+	}
+	i, err := create(name)
+	if err != nil {
+		m.log.Warn("planx: plugin metric not created", "metric", name, "error", err)
+		i = nil
+	}
+	m.plugin[name] = i
+	return i
+}
+
+// otelSession records the measurements of one session's SPI.
+type otelSession struct {
+	m     *otelMetrics
+	attrs metric.MeasurementOption
+}
+
+func (s otelSession) Add(name string, delta float64) {
+	c, ok := s.m.pluginInstrument(name, func(name string) (any, error) {
+		return s.m.meter.Float64Counter(name, metric.WithDescription(pluginHelp))
+	}).(metric.Float64Counter)
+	if ok && delta >= 0 {
+		c.Add(context.Background(), delta, s.attrs)
+	}
+}
+
+func (s otelSession) Set(name string, value float64) {
+	g, ok := s.m.pluginInstrument(name, func(name string) (any, error) {
+		return s.m.meter.Float64Gauge(name, metric.WithDescription(pluginHelp))
+	}).(metric.Float64Gauge)
+	if ok {
+		g.Record(context.Background(), value, s.attrs)
+	}
+}
+
+func (s otelSession) Observe(name string, value float64) {
+	h, ok := s.m.pluginInstrument(name, func(name string) (any, error) {
+		return s.m.meter.Float64Histogram(name, metric.WithDescription(pluginHelp))
+	}).(metric.Float64Histogram)
+	if ok {
+		h.Record(context.Background(), value, s.attrs)
+	}
+}
@@ -0,0 +1,177 @@
+package runtime
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/credentials"
+)
+
+// Process holds state shared by all plugin servers in a process.
+type Process struct {
+	cfg      Config
+	log      Logger
+	logLevel *slog.LevelVar
+	redact   func(key string, value any) any
+	budget   flow.Budget
+	codec    *countingCodec
+	metrics  Metrics
+	gatherer prometheus.Gatherer
+	panics   atomic.Int64
+	auditor  func(AuditEvent)
+	tap      *tap
+	tenants  *tenantLedger
+	creds    credentials.TransportCredentials
+	// sessionLimit is nil when session creation is not rate-limited.
+	sessionLimit *sessionLimiter
+	// keys decrypts encrypted connector configs; nil if none are expected.
+	keys KeyProvider
+	// authorizer is nil when every session is allowed.
+	authorizer func(context.Context, AuthRequest) error
+	// importCheckpoint is nil when savepoints cannot be imported.
+	importCheckpoint func(ctx context.Context, namespace, id string, data []byte) error
+	// stateStats is nil when the plugin has no state backend to report.
+	stateStats func() (StateStats, bool)
+	// dataPlanes holds the data planes sessions may negotiate, by name.
+	dataPlanes map[string]DataPlane
+	// handingOff is set while the process hands its sessions over to a
+	// new one; it refuses new sessions and batches meanwhile.
+	handingOff atomic.Bool
+
+	mu      sync.Mutex
+	servers []sessionServer
+	// handedOver holds the sessions exported by a handoff savepoint,
+	// whose state the new process owns.
+	handedOver map[string]bool
+}
+
+type Options struct {
+	// Logger defaults to the slog default logger.
+	Logger Logger
+	// Registerer receives the SDK's Prometheus collectors; Gatherer is
+	// served on the metrics endpoint. Prometheus metrics are disabled
+	// when Registerer is nil.
+	Registerer prometheus.Registerer
+	Gatherer   prometheus.Gatherer
+	// Redact, when set, rewrites every value before the SDK logs it.
+	Redact func(key string, value any) any
+	// Audit, when set, receives every session audit event in addition
+	// to the audit_log destination.
+	Audit func(AuditEvent)
+	// Authorize, when set, is called before every SPI Init and denies
+	// the session by returning an error.
+	Authorize func(context.Context, AuthRequest) error
+	// Keys, when set, decrypts encrypted connector configs before Init.
+	Keys KeyProvider
+	// ImportCheckpoint, when set, stores checkpoint id of a state
+	// namespace, as exported by Savepointer, for ImportSavepoint.
+	ImportCheckpoint func(ctx context.Context, namespace, id string, data []byte) error
+	// StateStats, when set, reports the state backend for the planx_state
+	// metrics and the process snapshot; it returns false if the backend
+	// keeps no stats.
+	StateStats func() (StateStats, bool)
+	// DataPlanes are offered to sessions whose engine asks for one of
+	// them with x-planx-data-plane.
+	DataPlanes []DataPlane
+	// Usage, when set, receives per-tenant traffic every
+	// usage_flush_interval.
+	Usage func([]TenantUsage)
+	// MeterProvider is used by the otlp backend instead of creating an
+	// OTLP exporter from the config.
+	MeterProvider metric.MeterProvider
+}
+
+func NewProcess(cfg Config, opts Options) (*Process, error) {
+	r := &Process{
+		cfg:      cfg,
+		log:      opts.Logger,
+		logLevel: new(slog.LevelVar),
+		redact:   opts.Redact,
+		budget:   newBudget(cfg),
+		codec:    newCountingCodec(batch.NewCodec()),
+		tenants:  newTenantLedger(),
+		metrics:  nopMetrics{},
+		gatherer: opts.Gatherer,
+
+		authorizer: opts.Authorize,
+		keys:       opts.Keys,
+
+		importCheckpoint: opts.ImportCheckpoint,
+		stateStats:       opts.StateStats,
+	}
+	for _, dp := range opts.DataPlanes {
+		if r.dataPlanes == nil {
+			r.dataPlanes = make(map[string]DataPlane)
+		}
+		r.dataPlanes[dp.Name()] = dp
+	}
+	if r.log == nil {
+		r.log = defaultLogger()
+	}
+	r.logLevel.Set(slog.LevelDebug)
+	// Sampling is per session; the process logger only filters.
+	logOpts := cfg.logOptions(r.logLevel, r.redact)
+	logOpts.SampleFirst = 0
+	r.log = logging.Wrap(r.log, logging.NewFilter(logOpts))
+
+	auditor, err := newAuditor(cfg.AuditLog, opts.Audit, r.log)
+	if err != nil {
+		return nil, err
+	}
+	r.auditor = auditor
+
+	if r.tap, err = newTap(cfg, r.log, r.keys); err != nil {
+		return nil, err
+	}
+	if r.creds, err = newServerCredentials(cfg, r.log); err != nil {
+		return nil, err
+	}
+	r.sessionLimit = newSessionLimiter(cfg)
+	if opts.Usage != nil {
+		r.flushUsage(cfg.UsageFlushInterval, opts.Usage)
+	}
+
+	switch cfg.MetricsBackend {
+	case MetricsPrometheus:
+		if opts.Registerer == nil {
+			break
+		}
+		m, err := newPrometheusMetrics(opts.Registerer, r)
+		if err != nil {
+			return nil, err
+		}
+		r.metrics = m
+
+	case MetricsOTLP:
+		mp := opts.MeterProvider
+		if mp == nil {
+			p, err := newOTLPMeterProvider(context.Background(), cfg.OTLPEndpoint)
+			if err != nil {
+				return nil, err
+			}
+			mp = p
+		}
+		m, err := newOTelMetrics(mp, r)
+		if err != nil {
+			return nil, err
+		}
+		r.metrics = m
+	}
+	return r, nil
+}
+
+// SetLogLevel drops SDK and session log messages below level and returns
+// the previous setting. The default, debug, leaves filtering to the
+// configured logger.
+func (r *Process) SetLogLevel(level slog.Level) slog.Level {
+	prev := r.logLevel.Level()
+	r.logLevel.Set(level)
+	return prev
+}
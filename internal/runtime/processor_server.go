@@ -1,10 +1,13 @@
 package runtime
 
 import (
+	"bytes"
 	"context"
+	"sync/atomic"
 
 	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
 	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
 	"github.com/planx-lab/planx-sdk-go/internal/session"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -13,28 +16,60 @@ import (
 
 type ProcessorSPI interface {
 	Init(ctx context.Context, config []byte) error
-	Process(batch any) (any, error)
+	Process(ctx context.Context, b *batch.Batch) (*batch.Batch, error)
 	Close() error
 }
 
 type ProcessorServer struct {
 	pb.UnimplementedProcessorPluginServer
 
-	factory  func() ProcessorSPI
-	sessions *session.Manager[*processorSession]
-	codec    batch.Codec
+	proc      *Process
+	factories map[string]func() ProcessorSPI
+	sessions  *session.Manager[*processorSession]
+	codec     batch.Codec
 }
 
 type processorSession struct {
-	spi ProcessorSPI
+	*sessionMeta
+	spi      ProcessorSPI
+	inflight flow.Policy
+	align    aligner
+	// ended is set once the session took an end-of-stream.
+	ended atomic.Bool
 }
 
-func NewProcessorServer(factory func() ProcessorSPI) *ProcessorServer {
-	return &ProcessorServer{
-		factory:  factory,
-		sessions: session.NewManager[*processorSession](),
-		codec:    batch.NewCodec(),
+func NewProcessorServer(proc *Process, factories map[string]func() ProcessorSPI) *ProcessorServer {
+	p := &ProcessorServer{
+		proc:      proc,
+		factories: factories,
+		sessions:  session.NewManager[*processorSession](),
+		codec:     proc.codec,
 	}
+	proc.addServer(p)
+	return p
+}
+
+func (p *ProcessorServer) snapshots() []SessionSnapshot {
+	var out []SessionSnapshot
+	for _, sess := range p.sessions.All() {
+		out = append(out, sess.snapshot(sess.inflight.Stats()))
+	}
+	return out
+}
+
+func (p *ProcessorServer) savepoint(ctx context.Context, id string, want func(string) bool) ([]SavepointSession, error) {
+	var out []SavepointSession
+	for _, sess := range p.sessions.All() {
+		if !want(sess.id) {
+			continue
+		}
+		sp, err := p.proc.savepointSession(ctx, sess.sessionMeta, sess.spi, id, sess.align.barrier)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sp)
+	}
+	return out, nil
 }
 
 func (p *ProcessorServer) CreateSession(
@@ -42,16 +77,24 @@ func (p *ProcessorServer) CreateSession(
 	req *pb.SessionCreateRequest,
 ) (*pb.SessionCreateResponse, error) {
 
-	spi := p.factory()
-	if err := spi.Init(ctx, req.Config); err != nil {
+	inflight, err := p.proc.inboundPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spi, meta, err := createSPI(p.proc, ctx, RoleProcessor, p.factories, req.Config)
+	if err != nil {
 		return nil, err
 	}
 
-	id := generateSessionID()
-	p.sessions.Add(id, &processorSession{spi: spi})
+	p.sessions.Add(meta.id, &processorSession{
+		sessionMeta: meta,
+		spi:         spi,
+		inflight:    inflight,
+	})
 
 	return &pb.SessionCreateResponse{
-		SessionId: id,
+		SessionId: meta.id,
 	}, nil
 }
 
@@ -75,22 +118,95 @@ func (p *ProcessorServer) Process(
 		return nil, status.Error(codes.NotFound, "session not found")
 	}
 
-	in, err := p.codec.Unpack(batchMsg.Payload)
+	release, err := admit(ctx, sess.inflight)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	packedIn, done, err := sess.plane.in(batchMsg.Payload)
+	if err != nil {
+		return nil, p.proc.fail(sess.sessionMeta, "", err)
+	}
+	defer done()
 
-	out, err := sess.spi.Process(in)
+	free, err := p.proc.reserve(ctx, len(packedIn))
 	if err != nil {
 		return nil, err
 	}
+	defer free()
 
-	packed, err := p.codec.Pack(out)
+	in, err := p.codec.Unpack(packedIn)
 	if err != nil {
+		return nil, p.proc.fail(sess.sessionMeta, "", CategorizeError(ErrDataFormat, err))
+	}
+	batch.Hold(in)
+	defer batch.Done(in)
+
+	if id, ok := BarrierID(in); ok {
+		defer sess.align.barrier()()
+		if _, err := p.proc.snapshot(ctx, sess.sessionMeta, sess.spi, id); err != nil {
+			return nil, err
+		}
+		return p.reply(ctx, sess.sessionMeta, bytes.Clone(packedIn))
+	}
+	if id, ok := CommitID(in); ok {
+		defer sess.align.barrier()()
+		if err := p.proc.commit(ctx, sess.sessionMeta, sess.spi, id); err != nil {
+			return nil, err
+		}
+		return p.reply(ctx, sess.sessionMeta, bytes.Clone(packedIn))
+	}
+	if id, end, ok := flushMarker(in); ok {
+		defer sess.align.barrier()()
+		if err := p.proc.flush(ctx, sess.sessionMeta, sess.spi, id); err != nil {
+			return nil, err
+		}
+		if end {
+			sess.ended.Store(true)
+		}
+		return p.reply(ctx, sess.sessionMeta, bytes.Clone(packedIn))
+	}
+	defer sess.align.batch()()
+	if sess.ended.Load() {
+		return nil, errStreamEnded
+	}
+
+	var out *batch.Batch
+	if err := p.proc.call(ctx, sess.sessionMeta, "Process", func() (err error) {
+		out, err = sess.spi.Process(ctx, in)
+		return err
+	}); err != nil {
 		return nil, err
 	}
 
-	return &pb.Batch{Payload: packed}, nil
+	packed, err := p.codec.Pack(out)
+	if err != nil {
+		return nil, p.proc.fail(sess.sessionMeta, "", CategorizeError(ErrDataFormat, err))
+	}
+
+	p.proc.recordBatch(sess.sessionMeta, DirectionIn, in, len(packedIn))
+	p.proc.recordBatch(sess.sessionMeta, DirectionOut, out, len(packed))
+	batch.Done(out)
+
+	return p.reply(ctx, sess.sessionMeta, packed)
+}
+
+// reply returns the message carrying a packed batch back to the engine.
+// Barriers and commits are passed on in a copy, as the payload they came
+// in goes back to its pool when the call returns. The reply is not seen
+// to be sent, so a call the engine has given up on by then is answered
+// with its context error, the batch given back to the data plane.
+func (p *ProcessorServer) reply(ctx context.Context, meta *sessionMeta, packed []byte) (*pb.Batch, error) {
+	payload, err := meta.plane.out(packed)
+	if err != nil {
+		return nil, p.proc.fail(meta, "", err)
+	}
+	if err := ctx.Err(); err != nil {
+		meta.plane.discard(payload)
+		return nil, status.FromContextError(err).Err()
+	}
+	return &pb.Batch{Payload: payload}, nil
 }
 
 func (p *ProcessorServer) CloseSession(
@@ -98,11 +214,15 @@ func (p *ProcessorServer) CloseSession(
 	req *pb.SessionCloseRequest,
 ) (*pb.Empty, error) {
 
-	sess, ok := p.sessions.Get(req.SessionId)
+	p.forceClose(ctx, req.SessionId)
+	return &pb.Empty{}, nil
+}
+
+func (p *ProcessorServer) forceClose(ctx context.Context, id string) bool {
+	sess, ok := p.sessions.Get(id)
 	if ok {
-		_ = sess.spi.Close()
-		p.sessions.Remove(req.SessionId)
+		p.proc.closeSPI(ctx, sess.sessionMeta, sess.spi)
+		p.sessions.Remove(id)
 	}
-
-	return &pb.Empty{}, nil
+	return ok
 }
@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// validateProduction enforces production mode: debug features are off
+// and every admin or debug surface requires admin_token.
+func (c Config) validateProduction() error {
+	if !c.Production {
+		return nil
+	}
+	if c.TapTarget != "" {
+		return fmt.Errorf("planx: tap_target is not allowed in production mode")
+	}
+	if c.GatewayAddress != "" {
+		return fmt.Errorf("planx: gateway_address is not allowed in production mode")
+	}
+	if (c.AdminService || c.DebugAddress != "") && c.AdminToken == "" {
+		return fmt.Errorf("planx: admin_service and debug_address require admin_token in production mode")
+	}
+	return nil
+}
+
+const bearerPrefix = "Bearer "
+
+// checkToken reports whether the "Bearer <token>" credential matches
+// admin_token. Any credential passes when no token is configured.
+func (r *Process) checkToken(credential string) bool {
+	if r.cfg.AdminToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(credential, bearerPrefix)
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(r.cfg.AdminToken)) == 1
+}
+
+// authorizeAdmin checks the authorization metadata of an admin call.
+func (r *Process) authorizeAdmin(ctx context.Context) error {
+	var credential string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			credential = v[0]
+		}
+	}
+	if !r.checkToken(credential) {
+		return status.Error(codes.Unauthenticated, "admin call requires a valid bearer token")
+	}
+	return nil
+}
+
+// requireToken wraps a debug HTTP handler with the admin_token check.
+func (r *Process) requireToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.checkToken(req.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
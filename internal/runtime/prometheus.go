@@ -0,0 +1,377 @@
+package runtime
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	baseLabels   = []string{"role", "connector"}
+	dirLabels    = []string{"role", "connector", "direction"}
+	methodLabels = []string{"role", "connector", "method"}
+	flowLabels   = []string{"role", "connector", "session_id"}
+	errorLabels  = []string{"role", "connector", "category"}
+	rpcLabels    = []string{"method", "code"}
+	pluginLabels = []string{"role", "connector", "session_id", "tenant_id"}
+
+	// spiBuckets span 0.5ms to ~16s and are shared by the SPI and RPC
+	// histograms so the two can be compared per bucket.
+	spiBuckets = prometheus.ExponentialBuckets(0.0005, 2, 16)
+)
+
+type prometheusMetrics struct {
+	sessionsCreated *prometheus.CounterVec
+	sessionsActive  *prometheus.GaugeVec
+	batches         *prometheus.CounterVec
+	records         *prometheus.CounterVec
+	bytes           *prometheus.CounterVec
+	spiCalls        *prometheus.CounterVec
+	spiErrors       *prometheus.CounterVec
+	spiDuration     *prometheus.HistogramVec
+	panics          *prometheus.CounterVec
+	errors          *prometheus.CounterVec
+	rpcDuration     *prometheus.HistogramVec
+
+	// plugin holds the vectors of the measurements SPIs define, created
+	// and registered on first use; nil for those that failed to register.
+	reg    prometheus.Registerer
+	log    Logger
+	mu     sync.Mutex
+	plugin map[string]prometheus.Collector
+}
+
+func newPrometheusMetrics(reg prometheus.Registerer, proc *Process) (*prometheusMetrics, error) {
+	m := &prometheusMetrics{
+		sessionsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_sessions_created_total",
+			Help: "Sessions created.",
+		}, baseLabels),
+		sessionsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "planx_sessions_active",
+			Help: "Sessions currently open.",
+		}, baseLabels),
+		batches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_batches_total",
+			Help: "Batches moved between the engine and the plugin.",
+		}, dirLabels),
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_records_total",
+			Help: "Records moved between the engine and the plugin.",
+		}, dirLabels),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_bytes_total",
+			Help: "Packed batch bytes moved between the engine and the plugin.",
+		}, dirLabels),
+		spiCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_spi_calls_total",
+			Help: "Calls into plugin SPI methods.",
+		}, methodLabels),
+		spiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_spi_errors_total",
+			Help: "SPI calls that returned an error.",
+		}, methodLabels),
+		spiDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "planx_spi_duration_seconds",
+			Help:    "Time spent in plugin SPI methods, excluding SDK overhead.",
+			Buckets: spiBuckets,
+		}, methodLabels),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_spi_panics_total",
+			Help: "Panics recovered from plugin SPI methods.",
+		}, methodLabels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "planx_errors_total",
+			Help: "Session and batch failures by error category.",
+		}, errorLabels),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "planx_rpc_duration_seconds",
+			Help:    "Latency of unary plugin gRPC handlers, SDK overhead included.",
+			Buckets: spiBuckets,
+		}, rpcLabels),
+
+		reg:    reg,
+		log:    proc.log,
+		plugin: make(map[string]prometheus.Collector),
+	}
+
+	collectors := []prometheus.Collector{
+		m.sessionsCreated, m.sessionsActive,
+		m.batches, m.records, m.bytes,
+		m.spiCalls, m.spiErrors, m.spiDuration, m.panics, m.errors,
+		m.rpcDuration,
+		&flowCollector{proc: proc},
+		&tenantCollector{proc: proc},
+		&stateCollector{proc: proc},
+		&checkpointCollector{proc: proc},
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *prometheusMetrics) SessionCreated(l Labels) {
+	m.sessionsCreated.WithLabelValues(l.Role, l.Connector).Inc()
+	m.sessionsActive.WithLabelValues(l.Role, l.Connector).Inc()
+}
+
+func (m *prometheusMetrics) SessionClosed(l Labels) {
+	m.sessionsActive.WithLabelValues(l.Role, l.Connector).Dec()
+}
+
+func (m *prometheusMetrics) Batch(l Labels, direction string, records, bytes int) {
+	m.batches.WithLabelValues(l.Role, l.Connector, direction).Inc()
+	m.records.WithLabelValues(l.Role, l.Connector, direction).Add(float64(records))
+	m.bytes.WithLabelValues(l.Role, l.Connector, direction).Add(float64(bytes))
+}
+
+func (m *prometheusMetrics) SPICall(l Labels, method string, d time.Duration, err error) {
+	m.spiCalls.WithLabelValues(l.Role, l.Connector, method).Inc()
+	m.spiDuration.WithLabelValues(l.Role, l.Connector, method).Observe(d.Seconds())
+	if err != nil {
+		m.spiErrors.WithLabelValues(l.Role, l.Connector, method).Inc()
+	}
+}
+
+func (m *prometheusMetrics) Panic(l Labels, method string) {
+	m.panics.WithLabelValues(l.Role, l.Connector, method).Inc()
+}
+
+func (m *prometheusMetrics) Error(l Labels, category ErrorCategory) {
+	m.errors.WithLabelValues(l.Role, l.Connector, string(category)).Inc()
+}
+
+func (m *prometheusMetrics) RPC(method string, d time.Duration, err error) {
+	m.rpcDuration.WithLabelValues(method, status.Code(err).String()).Observe(d.Seconds())
+}
+
+func (m *prometheusMetrics) Session(l Labels, sessionID, tenantID string) (session.Metrics, func()) {
+	s := prometheusSession{m: m, lv: []string{l.Role, l.Connector, sessionID, tenantID}}
+	return s, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, c := range m.plugin {
+			if v, ok := c.(interface{ DeletePartialMatch(prometheus.Labels) int }); ok {
+				v.DeletePartialMatch(prometheus.Labels{"session_id": sessionID})
+			}
+		}
+	}
+}
+
+// pluginVec returns the vector of the measurement name, creating it
+// with create if it is new. A name keeps the kind it was first recorded
+// with; recording it as another kind finds no vector of that kind.
+func (m *prometheusMetrics) pluginVec(name string, create func(name string) prometheus.Collector) prometheus.Collector {
+	name = pluginMetricName("planx_plugin", "_", name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.plugin[name]; ok {
+		return c
+	}
+	c := create(name)
+	if err := m.reg.Register(c); err != nil {
+		m.log.Warn("planx: plugin metric not registered", "metric", name, "error", err)
+		c = nil
+	}
+	m.plugin[name] = c
+	return c
+}
+
+const pluginHelp = "Plugin-defined measurement."
+
+// prometheusSession records the measurements of one session's SPI.
+type prometheusSession struct {
+	m  *prometheusMetrics
+	lv []string
+}
+
+func (s prometheusSession) Add(name string, delta float64) {
+	c, ok := s.m.pluginVec(name, func(name string) prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: pluginHelp}, pluginLabels)
+	}).(*prometheus.CounterVec)
+	if ok && delta >= 0 {
+		c.WithLabelValues(s.lv...).Add(delta)
+	}
+}
+
+func (s prometheusSession) Set(name string, value float64) {
+	g, ok := s.m.pluginVec(name, func(name string) prometheus.Collector {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: pluginHelp}, pluginLabels)
+	}).(*prometheus.GaugeVec)
+	if ok {
+		g.WithLabelValues(s.lv...).Set(value)
+	}
+}
+
+func (s prometheusSession) Observe(name string, value float64) {
+	h, ok := s.m.pluginVec(name, func(name string) prometheus.Collector {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: pluginHelp}, pluginLabels)
+	}).(*prometheus.HistogramVec)
+	if ok {
+		h.WithLabelValues(s.lv...).Observe(value)
+	}
+}
+
+var (
+	flowCreditsDesc = prometheus.NewDesc("planx_flow_credits",
+		"Batches the session may currently move.", flowLabels, nil)
+	flowStallsDesc = prometheus.NewDesc("planx_flow_stalls_total",
+		"Acquires that had to wait for flow-control credit.", flowLabels, nil)
+	flowWaitDesc = prometheus.NewDesc("planx_flow_wait_seconds_total",
+		"Time spent waiting for flow-control credit.", flowLabels, nil)
+	flowAcksDesc = prometheus.NewDesc("planx_flow_acks_total",
+		"Credit grants received from the engine.", flowLabels, nil)
+	flowAckIntervalDesc = prometheus.NewDesc("planx_flow_ack_interval_seconds",
+		"Moving average time between credit grants.", flowLabels, nil)
+)
+
+// flowCollector reads flow-control state from live sessions at scrape
+// time rather than tracking it on the hot path.
+type flowCollector struct {
+	proc *Process
+}
+
+func (c *flowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- flowCreditsDesc
+	ch <- flowStallsDesc
+	ch <- flowWaitDesc
+	ch <- flowAcksDesc
+	ch <- flowAckIntervalDesc
+}
+
+func (c *flowCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, f := range c.proc.FlowStats() {
+		lv := []string{f.Labels.Role, f.Labels.Connector, f.SessionID}
+		ch <- prometheus.MustNewConstMetric(flowCreditsDesc, prometheus.GaugeValue, float64(f.Stats.Credits), lv...)
+		ch <- prometheus.MustNewConstMetric(flowStallsDesc, prometheus.CounterValue, float64(f.Stats.Stalls), lv...)
+		ch <- prometheus.MustNewConstMetric(flowWaitDesc, prometheus.CounterValue, f.Stats.WaitTime.Seconds(), lv...)
+		ch <- prometheus.MustNewConstMetric(flowAcksDesc, prometheus.CounterValue, float64(f.Stats.Releases), lv...)
+		ch <- prometheus.MustNewConstMetric(flowAckIntervalDesc, prometheus.GaugeValue, f.Stats.ReleaseInterval.Seconds(), lv...)
+	}
+}
+
+var (
+	tenantRecordsDesc = prometheus.NewDesc("planx_tenant_records_total",
+		"Records moved per tenant across all sessions.", []string{"tenant_id", "direction"}, nil)
+	tenantBytesDesc = prometheus.NewDesc("planx_tenant_bytes_total",
+		"Packed batch bytes moved per tenant across all sessions.", []string{"tenant_id", "direction"}, nil)
+)
+
+type tenantCollector struct {
+	proc *Process
+}
+
+func (c *tenantCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tenantRecordsDesc
+	ch <- tenantBytesDesc
+}
+
+func (c *tenantCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, u := range c.proc.TenantUsage() {
+		for dir, t := range map[string]TrafficStats{DirectionIn: u.In, DirectionOut: u.Out} {
+			ch <- prometheus.MustNewConstMetric(tenantRecordsDesc, prometheus.CounterValue, float64(t.Records), u.TenantID, dir)
+			ch <- prometheus.MustNewConstMetric(tenantBytesDesc, prometheus.CounterValue, float64(t.Bytes), u.TenantID, dir)
+		}
+	}
+}
+
+var (
+	checkpointsDesc = prometheus.NewDesc("planx_checkpoints_total",
+		"Checkpoints the session completed.", flowLabels, nil)
+	checkpointFailuresDesc = prometheus.NewDesc("planx_checkpoint_failures_total",
+		"Checkpoints of the session that failed.", flowLabels, nil)
+	checkpointConsecutiveDesc = prometheus.NewDesc("planx_checkpoint_consecutive_failures",
+		"Checkpoints of the session that failed since the last one completed.", flowLabels, nil)
+	checkpointDurationDesc = prometheus.NewDesc("planx_checkpoint_duration_seconds_total",
+		"Time the session spent taking checkpoints.", flowLabels, nil)
+	checkpointLastDurationDesc = prometheus.NewDesc("planx_checkpoint_last_duration_seconds",
+		"Time the session's last completed checkpoint took.", flowLabels, nil)
+	checkpointLastSizeDesc = prometheus.NewDesc("planx_checkpoint_last_size_bytes",
+		"Size of the session's last completed checkpoint.", flowLabels, nil)
+)
+
+// checkpointCollector reads the checkpoint counters of live sessions at
+// scrape time. Sessions that never took a checkpoint are left out.
+type checkpointCollector struct {
+	proc *Process
+}
+
+func (c *checkpointCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- checkpointsDesc
+	ch <- checkpointFailuresDesc
+	ch <- checkpointConsecutiveDesc
+	ch <- checkpointDurationDesc
+	ch <- checkpointLastDurationDesc
+	ch <- checkpointLastSizeDesc
+}
+
+func (c *checkpointCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.proc.Sessions() {
+		cp := s.Checkpoints
+		if cp.Completed+cp.Failed == 0 {
+			continue
+		}
+		lv := []string{s.Labels.Role, s.Labels.Connector, s.SessionID}
+		ch <- prometheus.MustNewConstMetric(checkpointsDesc, prometheus.CounterValue, float64(cp.Completed), lv...)
+		ch <- prometheus.MustNewConstMetric(checkpointFailuresDesc, prometheus.CounterValue, float64(cp.Failed), lv...)
+		ch <- prometheus.MustNewConstMetric(checkpointConsecutiveDesc, prometheus.GaugeValue, float64(cp.ConsecutiveFailures), lv...)
+		ch <- prometheus.MustNewConstMetric(checkpointDurationDesc, prometheus.CounterValue, cp.Duration.Seconds(), lv...)
+		ch <- prometheus.MustNewConstMetric(checkpointLastDurationDesc, prometheus.GaugeValue, cp.LastDuration.Seconds(), lv...)
+		ch <- prometheus.MustNewConstMetric(checkpointLastSizeDesc, prometheus.GaugeValue, float64(cp.LastSize), lv...)
+	}
+}
+
+var (
+	stateEntriesDesc = prometheus.NewDesc("planx_state_entries",
+		"Entries held by the state backend.", nil, nil)
+	stateBytesDesc = prometheus.NewDesc("planx_state_bytes",
+		"Size of the state backend's files.", nil, nil)
+	stateExpiredDesc = prometheus.NewDesc("planx_state_expired_total",
+		"State entries removed after their TTL.", nil, nil)
+	stateCompactionsDesc = prometheus.NewDesc("planx_state_compactions_total",
+		"State logs compacted.", nil, nil)
+)
+
+// stateCollector reads the state backend's stats at scrape time.
+type stateCollector struct {
+	proc *Process
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateEntriesDesc
+	ch <- stateBytesDesc
+	ch <- stateExpiredDesc
+	ch <- stateCompactionsDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	st, ok := c.proc.StateStats()
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(stateEntriesDesc, prometheus.GaugeValue, float64(st.Entries))
+	ch <- prometheus.MustNewConstMetric(stateBytesDesc, prometheus.GaugeValue, float64(st.Bytes))
+	ch <- prometheus.MustNewConstMetric(stateExpiredDesc, prometheus.CounterValue, float64(st.Expired))
+	ch <- prometheus.MustNewConstMetric(stateCompactionsDesc, prometheus.CounterValue, float64(st.Compactions))
+}
+
+// serveMetrics exposes the gatherer on addr at /metrics.
+func serveMetrics(addr string, g prometheus.Gatherer, log Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("planx: metrics endpoint stopped", "address", addr, "error", err)
+		}
+	}()
+}
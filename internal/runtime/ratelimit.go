@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxCallerBuckets bounds the per-caller state kept by sessionLimiter.
+// Beyond it, callers whose buckets have refilled are forgotten, else the
+// one seen least recently.
+const maxCallerBuckets = 1024
+
+// bucket is a token bucket refilled at rate tokens per second up to
+// burst. seen is when its caller last asked for a token.
+type bucket struct {
+	tokens     float64
+	last, seen time.Time
+}
+
+// ready refills b and reports whether it holds a token.
+func (b *bucket) ready(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	return b.tokens >= 1
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// sessionLimiter rate-limits session creation globally and per caller.
+type sessionLimiter struct {
+	rate, callerRate   float64
+	burst, callerBurst int
+
+	mu      sync.Mutex
+	global  bucket
+	callers map[string]*bucket
+}
+
+// newSessionLimiter returns nil when neither limit is configured.
+func newSessionLimiter(cfg Config) *sessionLimiter {
+	if cfg.SessionRate <= 0 && cfg.SessionCallerRate <= 0 {
+		return nil
+	}
+	l := &sessionLimiter{
+		rate:        cfg.SessionRate,
+		burst:       burstFor(cfg.SessionRate, cfg.SessionBurst),
+		callerRate:  cfg.SessionCallerRate,
+		callerBurst: burstFor(cfg.SessionCallerRate, cfg.SessionCallerBurst),
+		callers:     make(map[string]*bucket),
+	}
+	l.global.tokens = float64(l.burst)
+	return l
+}
+
+// burstFor defaults the burst to one second's worth of sessions.
+func burstFor(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return max(1, int(math.Ceil(rate)))
+}
+
+// allow takes a token for caller and a global one, or neither unless
+// both limits admit the session. It returns a RESOURCE_EXHAUSTED error
+// naming the limit that was hit.
+func (l *sessionLimiter) allow(caller string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	var b *bucket
+	if l.callerRate > 0 {
+		var ok bool
+		if b, ok = l.callers[caller]; !ok {
+			l.prune(now)
+			b = &bucket{tokens: float64(l.callerBurst), last: now}
+			l.callers[caller] = b
+		}
+		b.seen = now
+		if !b.ready(now, l.callerRate, l.callerBurst) {
+			return status.Errorf(codes.ResourceExhausted, "session creation rate limit exceeded for caller %q", caller)
+		}
+	}
+	if l.rate > 0 {
+		if !l.global.ready(now, l.rate, l.burst) {
+			return status.Error(codes.ResourceExhausted, "plugin session creation rate limit exceeded")
+		}
+		l.global.tokens--
+	}
+	if b != nil {
+		b.tokens--
+	}
+	return nil
+}
+
+func (l *sessionLimiter) prune(now time.Time) {
+	if len(l.callers) < maxCallerBuckets {
+		return
+	}
+	var (
+		oldest string
+		seen   time.Time
+	)
+	for k, b := range l.callers {
+		if b.refill(now, l.callerRate, l.callerBurst); b.tokens >= float64(l.callerBurst) {
+			delete(l.callers, k)
+		} else if seen.IsZero() || b.seen.Before(seen) {
+			oldest, seen = k, b.seen
+		}
+	}
+	if len(l.callers) >= maxCallerBuckets {
+		delete(l.callers, oldest)
+	}
+}
+
+// callerKey identifies the engine behind a request for per-caller
+// limits: its verified mTLS identity, else its host. The engine ID it
+// reports is not used, as an engine could change it to get a fresh
+// bucket.
+func (e engineIdentity) callerKey() string {
+	if p := e.peer.String(); p != "" {
+		return p
+	}
+	if host, _, err := net.SplitHostPort(e.addr); err == nil {
+		return host
+	}
+	return e.addr
+}
+
+// admit applies the session creation rate limits to a new session.
+func (r *Process) admit(meta *sessionMeta) error {
+	if r.sessionLimit == nil {
+		return nil
+	}
+	err := r.sessionLimit.allow(meta.engine.callerKey())
+	if err != nil {
+		meta.log.Warn("planx: session rejected", "error", err)
+	}
+	return err
+}
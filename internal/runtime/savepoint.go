@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SavepointVersion is the format version of the savepoints written by
+// this SDK.
+const SavepointVersion = 1
+
+// Savepoint is a portable export of session checkpoints, taken by an
+// operator rather than the engine. Imported into another plugin process,
+// it lets the engine move sessions there: each is recreated on its
+// Namespace with x-planx-restore-checkpoint: <ID>.
+type Savepoint struct {
+	Version  int                `json:"version"`
+	ID       string             `json:"id"`
+	Created  time.Time          `json:"created"`
+	Sessions []SavepointSession `json:"sessions"`
+}
+
+// SavepointSession is the checkpoint of one session, as its SPI stores
+// it.
+type SavepointSession struct {
+	SessionID  string `json:"session_id"`
+	TenantID   string `json:"tenant_id,omitempty"`
+	Role       string `json:"role"`
+	Connector  string `json:"connector"`
+	Namespace  string `json:"namespace"`
+	Checkpoint []byte `json:"checkpoint"`
+}
+
+// Savepoint checkpoints the sessions named by sessionIDs, or all open
+// sessions when there are none, as savepoint id and exports them. An
+// empty id is replaced with a generated one. Each session is
+// checkpointed between two of its batches, independently of the others,
+// so the engine should be paused for a savepoint consistent across a
+// pipeline.
+func (r *Process) Savepoint(ctx context.Context, id string, sessionIDs []string) (*Savepoint, error) {
+	if id == "" {
+		id = "savepoint-" + util.NewSessionID()
+	}
+	r.mu.Lock()
+	servers := append([]sessionServer(nil), r.servers...)
+	r.mu.Unlock()
+
+	want := func(sessionID string) bool {
+		return len(sessionIDs) == 0 || slices.Contains(sessionIDs, sessionID)
+	}
+	sp := &Savepoint{Version: SavepointVersion, ID: id, Created: time.Now()}
+	for _, s := range servers {
+		sessions, err := s.savepoint(ctx, id, want)
+		if err != nil {
+			return nil, err
+		}
+		sp.Sessions = append(sp.Sessions, sessions...)
+	}
+	for _, sid := range sessionIDs {
+		if !slices.ContainsFunc(sp.Sessions, func(s SavepointSession) bool { return s.SessionID == sid }) {
+			return nil, status.Errorf(codes.NotFound, "session %q not found", sid)
+		}
+	}
+	r.log.Info("planx: savepoint taken", "savepoint_id", id, "sessions", len(sp.Sessions))
+	return sp, nil
+}
+
+// ImportSavepoint stores the checkpoints of sp in the plugin's state, for
+// sessions to be restored from.
+func (r *Process) ImportSavepoint(ctx context.Context, sp *Savepoint) error {
+	if r.importCheckpoint == nil {
+		return status.Error(codes.FailedPrecondition, "plugin cannot import savepoints")
+	}
+	if sp.Version != SavepointVersion {
+		return status.Errorf(codes.InvalidArgument, "unsupported savepoint version %d", sp.Version)
+	}
+	if sp.ID == "" {
+		return status.Error(codes.InvalidArgument, "savepoint has no id")
+	}
+	for _, s := range sp.Sessions {
+		if err := r.importCheckpoint(ctx, s.Namespace, sp.ID, s.Checkpoint); err != nil {
+			return fmt.Errorf("planx: import savepoint %q of session %s: %w", sp.ID, s.SessionID, err)
+		}
+	}
+	r.log.Info("planx: savepoint imported", "savepoint_id", sp.ID, "sessions", len(sp.Sessions))
+	return nil
+}
+
+// savepointSession checkpoints one session as savepoint id while lock
+// keeps its batches out, then exports the checkpoint.
+func (r *Process) savepointSession(
+	ctx context.Context,
+	meta *sessionMeta,
+	spi any,
+	id string,
+	lock func() func(),
+) (SavepointSession, error) {
+
+	sp, ok := spi.(Savepointer)
+	if !ok {
+		return SavepointSession{}, status.Errorf(codes.FailedPrecondition,
+			"session %s cannot export checkpoints", meta.id)
+	}
+	unlock := lock()
+	_, err := r.snapshot(ctx, meta, spi, id)
+	unlock()
+	if err != nil {
+		return SavepointSession{}, err
+	}
+
+	var (
+		namespace string
+		data      []byte
+	)
+	if err := r.call(ctx, meta, "ExportCheckpoint", func() (err error) {
+		namespace, data, err = sp.ExportCheckpoint(ctx, id)
+		return err
+	}); err != nil {
+		return SavepointSession{}, err
+	}
+	return SavepointSession{
+		SessionID:  meta.id,
+		TenantID:   meta.tenant,
+		Role:       meta.labels.Role,
+		Connector:  meta.labels.Connector,
+		Namespace:  namespace,
+		Checkpoint: data,
+	}, nil
+}
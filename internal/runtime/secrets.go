@@ -0,0 +1,46 @@
+package runtime
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// configSecrets returns the string values in a JSON config held by
+// fields whose names contain one of keys, including every string nested
+// under such a field. Configs that are not JSON objects have none.
+func configSecrets(config []byte, keys []string) []string {
+	var doc any
+	if len(keys) == 0 || json.Unmarshal(config, &doc) != nil {
+		return nil
+	}
+	var out []string
+	collectSecrets(doc, keys, false, &out)
+	return out
+}
+
+func collectSecrets(v any, keys []string, secret bool, out *[]string) {
+	switch v := v.(type) {
+	case string:
+		if secret {
+			*out = append(*out, v)
+		}
+	case map[string]any:
+		for k, e := range v {
+			collectSecrets(e, keys, secret || isSecretKey(k, keys), out)
+		}
+	case []any:
+		for _, e := range v {
+			collectSecrets(e, keys, secret, out)
+		}
+	}
+}
+
+func isSecretKey(name string, keys []string) bool {
+	name = strings.ToLower(name)
+	for _, k := range keys {
+		if strings.Contains(name, k) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,33 +1,194 @@
 package runtime
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+	"github.com/planx-lab/planx-sdk-go/internal/session"
 	"github.com/planx-lab/planx-sdk-go/internal/util"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// ProtocolV4 is the only plugin protocol served by this SDK.
+const ProtocolV4 = "v4"
+
+var supportedProtocols = []string{ProtocolV4}
+
 type Handshake struct {
-	Protocol string `json:"protocol"`
-	Address  string `json:"address"`
+	Protocol   string          `json:"protocol"`
+	Address    string          `json:"address"`
+	Plugin     string          `json:"plugin,omitempty"`
+	Version    string          `json:"version,omitempty"`
+	Connectors []ConnectorInfo `json:"connectors,omitempty"`
+	Build      *BuildInfo      `json:"build,omitempty"`
+
+	// AckCoalesceInterval advises the engine to batch acks.
+	AckCoalesceInterval string `json:"ack_coalesce_interval,omitempty"`
+
+	// Savepoint is set when the process took over the sessions of the
+	// one it replaces: the engine recreates them here with
+	// x-planx-restore-checkpoint: <Savepoint>.
+	Savepoint string `json:"savepoint,omitempty"`
+
+	// PID is the plugin's process ID. Signature, present when the engine
+	// set PLANX_HANDSHAKE_SECRET, is the hex HMAC-SHA256 of
+	// SigningPayload keyed by that secret.
+	PID       int    `json:"pid,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
-func ServeGRPC(register func(*grpc.Server)) {
-	lis, err := net.Listen("tcp", "127.0.0.1:0")
+// handshakeSecretEnv holds the secret the handshake is signed with. The
+// engine should generate a new one for every launch, so a handshake left
+// behind by an earlier process cannot be replayed.
+const handshakeSecretEnv = "PLANX_HANDSHAKE_SECRET"
+
+// SigningPayload returns the handshake fields the engine acts on, in the
+// form that is signed: "planx-handshake-v1\n<protocol>\n<address>\n<pid>".
+func (h *Handshake) SigningPayload() []byte {
+	return fmt.Appendf(nil, "planx-handshake-v1\n%s\n%s\n%d", h.Protocol, h.Address, h.PID)
+}
+
+func (h *Handshake) sign(secret []byte) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(h.SigningPayload())
+	h.Signature = hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyHandshake reports whether h was signed with secret, i.e. written
+// by the process the engine launched with it.
+func VerifyHandshake(h Handshake, secret []byte) error {
+	got, err := hex.DecodeString(h.Signature)
+	if err != nil || h.Signature == "" {
+		return errors.New("planx: handshake is not signed")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(h.SigningPayload())
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("planx: handshake signature mismatch")
+	}
+	return nil
+}
+
+// PluginInfo describes what a plugin process serves.
+type PluginInfo struct {
+	Name       string
+	Version    string
+	Connectors []ConnectorInfo
+	Build      BuildInfo
+}
+
+type ConnectorInfo struct {
+	Role string `json:"role"`
+	Name string `json:"name"`
+}
+
+// NewGRPCServer returns a gRPC server with the SDK's interceptors and TLS
+// settings, the services added by register and, if enabled, the admin
+// service.
+func NewGRPCServer(proc *Process, register func(*grpc.Server), opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ForceServerCodecV2(newBatchCodec()),
+		grpc.ChainUnaryInterceptor(releaseBatches, proc.timeRPC, traceUnary),
+		grpc.ChainStreamInterceptor(traceStream),
+	}, opts...)
+	if proc.creds != nil {
+		opts = append(opts, grpc.Creds(proc.creds))
+	}
+	s := grpc.NewServer(opts...)
+	register(s)
+	if proc.cfg.AdminService {
+		RegisterAdminServer(s, proc)
+	}
+	return s
+}
+
+// Servers are the protocol servers of a process, one per role it has
+// connectors for; the others are nil.
+type Servers struct {
+	Source    *SourceServer
+	Sink      *SinkServer
+	Processor *ProcessorServer
+}
+
+// Register adds the servers to a gRPC server.
+func (s Servers) Register(server *grpc.Server) {
+	if s.Source != nil {
+		RegisterSourceServer(server, s.Source)
+	}
+	if s.Sink != nil {
+		RegisterSinkServer(server, s.Sink)
+	}
+	if s.Processor != nil {
+		RegisterProcessorServer(server, s.Processor)
+	}
+}
+
+func ServeGRPC(proc *Process, info PluginInfo, servers Servers) {
+	cfg := proc.cfg
+
+	protocol, err := negotiateProtocol(cfg.Protocols)
 	if err != nil {
 		panic(err)
 	}
 
-	grpcServer := grpc.NewServer()
-	register(grpcServer)
+	var ho *handoff
+	if cfg.HandoffSocket != "" {
+		if ho, err = takeOver(cfg.HandoffSocket, proc); err != nil {
+			panic(err)
+		}
+	}
+
+	lis, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		panic(err)
+	}
+
+	if cfg.MetricsBackend == MetricsPrometheus && cfg.MetricsAddress != "" && proc.gatherer != nil {
+		serveMetrics(cfg.MetricsAddress, proc.gatherer, proc.log)
+	}
+
+	if cfg.DebugAddress != "" {
+		serveDebug(cfg.DebugAddress, proc)
+	}
+
+	if cfg.GatewayAddress != "" {
+		serveGateway(cfg.GatewayAddress, proc, servers)
+	}
+
+	grpcServer := NewGRPCServer(proc, servers.Register)
 
 	hs := Handshake{
-		Protocol: "v4",
-		Address:  lis.Addr().String(),
+		Protocol:   protocol,
+		Address:    lis.Addr().String(),
+		Plugin:     info.Name,
+		Version:    info.Version,
+		Connectors: info.Connectors,
+		Build:      &info.Build,
+		PID:        os.Getpid(),
+	}
+	if cfg.AckCoalesceInterval > 0 {
+		hs.AckCoalesceInterval = cfg.AckCoalesceInterval.String()
+	}
+	if ho != nil {
+		hs.Savepoint = ho.savepoint.ID
+	}
+	if secret := os.Getenv(handshakeSecretEnv); secret != "" {
+		hs.sign([]byte(secret))
+		// Connectors and their child processes have no use for it.
+		os.Unsetenv(handshakeSecretEnv)
 	}
 
 	data, err := json.Marshal(hs)
@@ -35,19 +196,58 @@ func ServeGRPC(register func(*grpc.Server)) {
 		panic(err)
 	}
 
-	if err := os.WriteFile("planx.handshake", data, 0644); err != nil {
+	if err := os.WriteFile(cfg.HandshakeFile, data, 0644); err != nil {
 		panic(err)
 	}
 
+	proc.log.Info("planx plugin starting",
+		"plugin", info.Name,
+		"version", info.Version,
+		"sdk_version", info.Build.SDKVersion,
+		"commit", info.Build.Commit,
+		"address", hs.Address,
+	)
+
 	// NOTE: The first line written to STDOUT is reserved
 	// exclusively for Planx handshake JSON.
 	fmt.Printf("%s\n", data)
 
+	if ho != nil {
+		if err := ho.ready(hs.Address); err != nil {
+			proc.log.Warn("planx: handoff not acknowledged by the process replaced", "error", err)
+		}
+	}
+	if cfg.HandoffSocket != "" {
+		if err := serveHandoff(cfg.HandoffSocket, proc, grpcServer.Stop); err != nil {
+			panic(err)
+		}
+	}
+
+	// Serve returns nil once a handoff stops the server.
 	if err := grpcServer.Serve(lis); err != nil {
 		panic(err)
 	}
 }
 
+// negotiateProtocol picks the first protocol offered by the engine
+// (comma separated, in preference order) that the SDK supports.
+// An empty offer means the engine predates negotiation and speaks v4.
+func negotiateProtocol(offered string) (string, error) {
+	if strings.TrimSpace(offered) == "" {
+		return ProtocolV4, nil
+	}
+	for _, p := range strings.Split(offered, ",") {
+		p = strings.TrimSpace(p)
+		for _, s := range supportedProtocols {
+			if p == s {
+				return s, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("planx: no common plugin protocol (engine offers %q, sdk supports %q)",
+		offered, strings.Join(supportedProtocols, ","))
+}
+
 func RegisterSourceServer(s *grpc.Server, srv pb.SourcePluginServer) {
 	pb.RegisterSourcePluginServer(s, srv)
 }
@@ -63,3 +263,54 @@ func RegisterProcessorServer(s *grpc.Server, srv pb.ProcessorPluginServer) {
 func generateSessionID() string {
 	return util.NewSessionID()
 }
+
+// newSessionInfo returns the identity of a session being created. The
+// tenant is supplied by the engine in metadata; the peer comes from its
+// verified client certificate.
+func newSessionInfo(ctx context.Context, id string, log *logging.Filter) session.Info {
+	info := session.Info{ID: id, Log: log, Peer: peerIdentity(ctx)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-planx-tenant-id"); len(v) > 0 {
+			info.TenantID = v[0]
+		}
+		if v := md.Get("x-planx-state-namespace"); len(v) > 0 {
+			info.StateNamespace = v[0]
+		}
+		if v := md.Get("x-planx-restore-checkpoint"); len(v) > 0 {
+			info.RestoreCheckpoint = v[0]
+		}
+		if v := md.Get("x-planx-state-changelog"); len(v) > 0 {
+			info.StateChangelog = v[0] == "true"
+		}
+	}
+	return info
+}
+
+// lookupFactory selects the connector named by the engine in the
+// x-planx-connector metadata. The name may be omitted when the plugin
+// serves a single connector for the role.
+func lookupFactory[F any](ctx context.Context, factories map[string]F) (string, F, error) {
+	var zero F
+
+	var name string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-planx-connector"); len(v) > 0 {
+			name = v[0]
+		}
+	}
+
+	if name == "" && len(factories) == 1 {
+		for n, f := range factories {
+			return n, f, nil
+		}
+	}
+	if name == "" {
+		return "", zero, status.Error(codes.InvalidArgument, "missing connector name in metadata")
+	}
+
+	f, ok := factories[name]
+	if !ok {
+		return "", zero, status.Errorf(codes.NotFound, "connector %q not found", name)
+	}
+	return name, f, nil
+}
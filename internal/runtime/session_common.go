@@ -0,0 +1,236 @@
+package runtime
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	RoleSource    = "source"
+	RoleSink      = "sink"
+	RoleProcessor = "processor"
+)
+
+// sessionMeta identifies a session in logs and metrics and holds its
+// counters. It is shared by all server types.
+type sessionMeta struct {
+	id     string
+	tenant string
+	engine engineIdentity
+	labels Labels
+	// log is scoped to the session and applies its sampling and
+	// redaction settings, held in logFilter. secrets are also scrubbed
+	// from the session's errors and audit events.
+	log       Logger
+	logFilter *logging.Filter
+	secrets   *logging.Secrets
+	created   time.Time
+	panics    atomic.Int64
+	errors    [len(errorCategories)]atomic.Int64
+	traffic   [2]traffic // indexed by direction
+
+	checkpoints checkpointStats
+	// plane is nil unless the session negotiated a data plane.
+	plane *sessionPlane
+	// dropMetrics removes the measurements the SPI defined.
+	dropMetrics func()
+}
+
+type engineIdentity struct {
+	id   string
+	addr string
+	peer session.Peer
+}
+
+func engineFromContext(ctx context.Context) engineIdentity {
+	e := engineIdentity{peer: peerIdentity(ctx)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-planx-engine-id"); len(v) > 0 {
+			e.id = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.addr = p.Addr.String()
+	}
+	return e
+}
+
+type traffic struct {
+	batches, records, bytes atomic.Int64
+}
+
+func direction(d string) int {
+	if d == DirectionOut {
+		return 1
+	}
+	return 0
+}
+
+// recordBatch counts a batch that crossed the session in direction and
+// offers it to the debug tap.
+func (r *Process) recordBatch(meta *sessionMeta, dir string, b *batch.Batch, bytes int) {
+	t := &meta.traffic[direction(dir)]
+	t.batches.Add(1)
+	t.records.Add(int64(b.Len()))
+	t.bytes.Add(int64(bytes))
+	r.metrics.Batch(meta.labels, dir, b.Len(), bytes)
+	r.tenants.add(meta.tenant, dir, b.Len(), bytes)
+	if r.tap != nil {
+		r.tap.observe(meta, dir, b, bytes)
+	}
+}
+
+// SessionSnapshot describes one open session for introspection.
+type SessionSnapshot struct {
+	FlowSnapshot
+	TenantID string
+	Created  time.Time
+	Panics   int64
+	Errors   map[ErrorCategory]int64
+	In, Out  TrafficStats
+
+	Checkpoints CheckpointStats
+}
+
+type TrafficStats struct {
+	Batches int64 `json:"batches"`
+	Records int64 `json:"records"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (meta *sessionMeta) snapshot(stats flow.Stats) SessionSnapshot {
+	ts := func(t *traffic) TrafficStats {
+		return TrafficStats{t.batches.Load(), t.records.Load(), t.bytes.Load()}
+	}
+	errs := make(map[ErrorCategory]int64)
+	for i, c := range errorCategories {
+		if n := meta.errors[i].Load(); n > 0 {
+			errs[c] = n
+		}
+	}
+	return SessionSnapshot{
+		FlowSnapshot: FlowSnapshot{SessionID: meta.id, Labels: meta.labels, Stats: stats},
+		TenantID:     meta.tenant,
+		Created:      meta.created,
+		Panics:       meta.panics.Load(),
+		Errors:       errs,
+		In:           ts(&meta.traffic[0]),
+		Out:          ts(&meta.traffic[1]),
+
+		Checkpoints: meta.checkpoints.stats(),
+	}
+}
+
+type lifecycleSPI interface {
+	Init(ctx context.Context, config []byte) error
+	Close() error
+}
+
+// createSPI selects the connector requested by the engine and returns a
+// freshly initialized SPI instance for a new session.
+func createSPI[T lifecycleSPI](
+	r *Process,
+	ctx context.Context,
+	role string,
+	factories map[string]func() T,
+	config []byte,
+) (T, *sessionMeta, error) {
+	var spi T
+	if r.handingOff.Load() {
+		return spi, nil, errHandingOff
+	}
+
+	connector, factory, err := lookupFactory(ctx, factories)
+	if err != nil {
+		return spi, nil, err
+	}
+	eos, err := exactlyOnce(ctx)
+	if err != nil {
+		return spi, nil, err
+	}
+	mode, err := sessionMode(ctx)
+	if err != nil {
+		return spi, nil, err
+	}
+
+	secrets := &logging.Secrets{}
+	logOpts := r.cfg.logOptions(r.logLevel, r.redact)
+	logOpts.Secrets = secrets
+	info := newSessionInfo(ctx, generateSessionID(), logging.NewFilter(logOpts))
+	info.ExactlyOnce = eos
+	info.Mode = mode
+	info.CheckpointFullInterval = r.cfg.CheckpointFullInterval
+	meta := &sessionMeta{
+		id:        info.ID,
+		tenant:    info.TenantID,
+		engine:    engineFromContext(ctx),
+		labels:    Labels{Role: role, Connector: connector},
+		created:   time.Now(),
+		logFilter: info.Log,
+		secrets:   secrets,
+		log: logging.Wrap(r.log, info.Log,
+			"session_id", info.ID,
+			"tenant_id", info.TenantID,
+			"role", role,
+			"connector", connector,
+		),
+	}
+
+	if err := r.admit(meta); err != nil {
+		return spi, nil, err
+	}
+	if err := r.authorize(ctx, meta); err != nil {
+		return spi, nil, err
+	}
+
+	config, err = r.decryptConfig(ctx, config, secrets)
+	if err != nil {
+		return spi, nil, r.fail(meta, "Init", err)
+	}
+	secrets.Add(configSecrets(config, r.cfg.secretKeys())...)
+
+	if meta.plane, err = r.openDataPlane(ctx, meta); err != nil {
+		return spi, nil, r.fail(meta, "Init", err)
+	}
+	info.Metrics, meta.dropMetrics = r.metrics.Session(meta.labels, info.ID, info.TenantID)
+	if err := r.call(ctx, meta, "Init", func() error {
+		spi = factory()
+		return spi.Init(session.WithInfo(ctx, info), config)
+	}); err != nil {
+		meta.plane.close(meta.log)
+		meta.dropMetrics()
+		return spi, nil, err
+	}
+	if eos {
+		confirmExactlyOnce(ctx)
+	}
+
+	r.metrics.SessionCreated(meta.labels)
+	r.audit(meta, AuditSessionCreated, "", nil)
+	return spi, meta, nil
+}
+
+// closeSPI shuts down the SPI of a session that is being removed.
+func (r *Process) closeSPI(ctx context.Context, meta *sessionMeta, spi lifecycleSPI) {
+	r.mu.Lock()
+	handedOver := r.handedOver[meta.id]
+	r.mu.Unlock()
+	err := r.call(ctx, meta, "Close", func() error {
+		if h, ok := spi.(HandOverer); ok && handedOver {
+			h.HandOver()
+		}
+		return shutdownSPI(ctx, spi)
+	})
+	meta.plane.close(meta.log)
+	meta.dropMetrics()
+	r.metrics.SessionClosed(meta.labels)
+	r.auditBy(meta, engineFromContext(ctx), AuditSessionClosed, "", err)
+}
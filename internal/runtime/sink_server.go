@@ -2,9 +2,11 @@ package runtime
 
 import (
 	"context"
+	"sync/atomic"
 
 	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
 	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
 	"github.com/planx-lab/planx-sdk-go/internal/session"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -13,28 +15,60 @@ import (
 
 type SinkSPI interface {
 	Init(ctx context.Context, config []byte) error
-	WriteBatch(batch any) error
+	WriteBatch(ctx context.Context, b *batch.Batch) error
 	Close() error
 }
 
 type SinkServer struct {
 	pb.UnimplementedSinkPluginServer
 
-	factory  func() SinkSPI
-	sessions *session.Manager[*sinkSession]
-	codec    batch.Codec
+	proc      *Process
+	factories map[string]func() SinkSPI
+	sessions  *session.Manager[*sinkSession]
+	codec     batch.Codec
 }
 
 type sinkSession struct {
-	spi SinkSPI
+	*sessionMeta
+	spi      SinkSPI
+	inflight flow.Policy
+	align    aligner
+	// ended is set once the session took an end-of-stream.
+	ended atomic.Bool
 }
 
-func NewSinkServer(factory func() SinkSPI) *SinkServer {
-	return &SinkServer{
-		factory:  factory,
-		sessions: session.NewManager[*sinkSession](),
-		codec:    batch.NewCodec(),
+func NewSinkServer(proc *Process, factories map[string]func() SinkSPI) *SinkServer {
+	s := &SinkServer{
+		proc:      proc,
+		factories: factories,
+		sessions:  session.NewManager[*sinkSession](),
+		codec:     proc.codec,
 	}
+	proc.addServer(s)
+	return s
+}
+
+func (s *SinkServer) snapshots() []SessionSnapshot {
+	var out []SessionSnapshot
+	for _, sess := range s.sessions.All() {
+		out = append(out, sess.snapshot(sess.inflight.Stats()))
+	}
+	return out
+}
+
+func (s *SinkServer) savepoint(ctx context.Context, id string, want func(string) bool) ([]SavepointSession, error) {
+	var out []SavepointSession
+	for _, sess := range s.sessions.All() {
+		if !want(sess.id) {
+			continue
+		}
+		sp, err := s.proc.savepointSession(ctx, sess.sessionMeta, sess.spi, id, sess.align.barrier)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sp)
+	}
+	return out, nil
 }
 
 func (s *SinkServer) CreateSession(
@@ -42,16 +76,24 @@ func (s *SinkServer) CreateSession(
 	req *pb.SessionCreateRequest,
 ) (*pb.SessionCreateResponse, error) {
 
-	spi := s.factory()
-	if err := spi.Init(ctx, req.Config); err != nil {
+	inflight, err := s.proc.inboundPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spi, meta, err := createSPI(s.proc, ctx, RoleSink, s.factories, req.Config)
+	if err != nil {
 		return nil, err
 	}
 
-	id := generateSessionID()
-	s.sessions.Add(id, &sinkSession{spi: spi})
+	s.sessions.Add(meta.id, &sinkSession{
+		sessionMeta: meta,
+		spi:         spi,
+		inflight:    inflight,
+	})
 
 	return &pb.SessionCreateResponse{
-		SessionId: id,
+		SessionId: meta.id,
 	}, nil
 }
 
@@ -75,14 +117,67 @@ func (s *SinkServer) WriteBatch(
 		return nil, status.Error(codes.NotFound, "session not found")
 	}
 
-	b, err := s.codec.Unpack(batchMsg.Payload)
+	release, err := admit(ctx, sess.inflight)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	packed, done, err := sess.plane.in(batchMsg.Payload)
+	if err != nil {
+		return nil, s.proc.fail(sess.sessionMeta, "", err)
+	}
+	defer done()
 
-	if err := sess.spi.WriteBatch(b); err != nil {
+	free, err := s.proc.reserve(ctx, len(packed))
+	if err != nil {
 		return nil, err
 	}
+	defer free()
+
+	b, err := s.codec.Unpack(packed)
+	if err != nil {
+		return nil, s.proc.fail(sess.sessionMeta, "", CategorizeError(ErrDataFormat, err))
+	}
+	batch.Hold(b)
+	defer batch.Done(b)
+
+	if id, ok := BarrierID(b); ok {
+		defer sess.align.barrier()()
+		if _, err := s.proc.snapshot(ctx, sess.sessionMeta, sess.spi, id); err != nil {
+			return nil, err
+		}
+		return &pb.AckResponse{}, nil
+	}
+	if id, ok := CommitID(b); ok {
+		defer sess.align.barrier()()
+		if err := s.proc.commit(ctx, sess.sessionMeta, sess.spi, id); err != nil {
+			return nil, err
+		}
+		return &pb.AckResponse{}, nil
+	}
+	if id, end, ok := flushMarker(b); ok {
+		defer sess.align.barrier()()
+		if err := s.proc.flush(ctx, sess.sessionMeta, sess.spi, id); err != nil {
+			return nil, err
+		}
+		if end {
+			sess.ended.Store(true)
+		}
+		return &pb.AckResponse{}, nil
+	}
+	defer sess.align.batch()()
+	if sess.ended.Load() {
+		return nil, errStreamEnded
+	}
+
+	if err := s.proc.call(ctx, sess.sessionMeta, "WriteBatch", func() error {
+		return sess.spi.WriteBatch(ctx, b)
+	}); err != nil {
+		return nil, err
+	}
+
+	s.proc.recordBatch(sess.sessionMeta, DirectionIn, b, len(packed))
 
 	return &pb.AckResponse{}, nil
 }
@@ -92,11 +187,15 @@ func (s *SinkServer) CloseSession(
 	req *pb.SessionCloseRequest,
 ) (*pb.Empty, error) {
 
-	sess, ok := s.sessions.Get(req.SessionId)
+	s.forceClose(ctx, req.SessionId)
+	return &pb.Empty{}, nil
+}
+
+func (s *SinkServer) forceClose(ctx context.Context, id string) bool {
+	sess, ok := s.sessions.Get(id)
 	if ok {
-		_ = sess.spi.Close()
-		s.sessions.Remove(req.SessionId)
+		s.proc.closeSPI(ctx, sess.sessionMeta, sess.spi)
+		s.sessions.Remove(id)
 	}
-
-	return &pb.Empty{}, nil
+	return ok
 }
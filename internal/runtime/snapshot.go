@@ -0,0 +1,213 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	goruntime "runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/flow"
+)
+
+// ProcessSnapshot is the introspection view of a running plugin, served
+// by the admin Snapshot call and at /debug/planx/snapshot on
+// debug_address.
+type ProcessSnapshot struct {
+	Time       time.Time        `json:"time"`
+	Goroutines int              `json:"goroutines"`
+	Panics     int64            `json:"panics"`
+	Sessions   []sessionReport  `json:"sessions"`
+	Budget     map[string]usage `json:"budget"`
+	Codec      CodecStats       `json:"codec"`
+	State      *StateStats      `json:"state,omitempty"`
+}
+
+type usage struct {
+	Used int64 `json:"used"`
+	// Max is zero when the resource is unlimited.
+	Max int64 `json:"max"`
+}
+
+type sessionReport struct {
+	SessionID string                  `json:"session_id"`
+	TenantID  string                  `json:"tenant_id,omitempty"`
+	Role      string                  `json:"role"`
+	Connector string                  `json:"connector,omitempty"`
+	CreatedAt time.Time               `json:"created_at"`
+	Panics    int64                   `json:"panics"`
+	Errors    map[ErrorCategory]int64 `json:"errors,omitempty"`
+	Flow      flowReport              `json:"flow"`
+	In        TrafficStats            `json:"in"`
+	Out       TrafficStats            `json:"out"`
+	// Checkpoints is omitted until the session takes one.
+	Checkpoints *checkpointReport `json:"checkpoints,omitempty"`
+}
+
+type checkpointReport struct {
+	Completed           int64   `json:"completed"`
+	Failed              int64   `json:"failed"`
+	ConsecutiveFailures int64   `json:"consecutive_failures"`
+	DurationSeconds     float64 `json:"duration_seconds"`
+	LastDurationSeconds float64 `json:"last_duration_seconds"`
+	LastSize            int64   `json:"last_size"`
+}
+
+type flowReport struct {
+	Credits            int       `json:"credits"`
+	Acquires           int64     `json:"acquires"`
+	Stalls             int64     `json:"stalls"`
+	WaitSeconds        float64   `json:"wait_seconds"`
+	Acks               int64     `json:"acks"`
+	AckIntervalSeconds float64   `json:"ack_interval_seconds"`
+	LastAck            time.Time `json:"last_ack,omitzero"`
+}
+
+func newSessionReport(s SessionSnapshot) sessionReport {
+	var cp *checkpointReport
+	if c := s.Checkpoints; c.Completed+c.Failed > 0 {
+		cp = &checkpointReport{
+			Completed:           c.Completed,
+			Failed:              c.Failed,
+			ConsecutiveFailures: c.ConsecutiveFailures,
+			DurationSeconds:     c.Duration.Seconds(),
+			LastDurationSeconds: c.LastDuration.Seconds(),
+			LastSize:            c.LastSize,
+		}
+	}
+	return sessionReport{
+		SessionID: s.SessionID,
+		TenantID:  s.TenantID,
+		Role:      s.Labels.Role,
+		Connector: s.Labels.Connector,
+		CreatedAt: s.Created.UTC(),
+		Panics:    s.Panics,
+		Errors:    s.Errors,
+		Flow:      newFlowReport(s.Stats),
+		In:        s.In,
+		Out:       s.Out,
+
+		Checkpoints: cp,
+	}
+}
+
+func newFlowReport(st flow.Stats) flowReport {
+	return flowReport{
+		Credits:            st.Credits,
+		Acquires:           st.Acquires,
+		Stalls:             st.Stalls,
+		WaitSeconds:        st.WaitTime.Seconds(),
+		Acks:               st.Releases,
+		AckIntervalSeconds: st.ReleaseInterval.Seconds(),
+		LastAck:            st.LastRelease.UTC(),
+	}
+}
+
+// Snapshot captures the current state of the process.
+func (r *Process) Snapshot() ProcessSnapshot {
+	snap := ProcessSnapshot{
+		Time:       time.Now().UTC(),
+		Goroutines: goruntime.NumGoroutine(),
+		Panics:     r.panics.Load(),
+		Sessions:   []sessionReport{},
+		Budget:     make(map[string]usage),
+		Codec:      r.codec.stats(),
+	}
+	for _, s := range r.Sessions() {
+		snap.Sessions = append(snap.Sessions, newSessionReport(s))
+	}
+	for name, l := range map[string]*flow.Limit{
+		"inflight_batches": r.budget.Batches,
+		"buffered_bytes":   r.budget.Bytes,
+		"concurrent_calls": r.budget.Calls,
+	} {
+		used, max := l.Used()
+		snap.Budget[name] = usage{Used: used, Max: max}
+	}
+	if st, ok := r.StateStats(); ok {
+		snap.State = &st
+	}
+	return snap
+}
+
+// serveDebug exposes the process snapshot on addr.
+func serveDebug(addr string, r *Process) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/planx/snapshot", r.requireToken(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r.Snapshot())
+	})))
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.log.Error("planx: debug endpoint stopped", "address", addr, "error", err)
+		}
+	}()
+}
+
+// CodecStats counts batch encoding work done by the process.
+type CodecStats struct {
+	Packs         int64   `json:"packs"`
+	PackErrors    int64   `json:"pack_errors"`
+	PackedBytes   int64   `json:"packed_bytes"`
+	PackSeconds   float64 `json:"pack_seconds"`
+	Unpacks       int64   `json:"unpacks"`
+	UnpackErrors  int64   `json:"unpack_errors"`
+	UnpackedBytes int64   `json:"unpacked_bytes"`
+	UnpackSeconds float64 `json:"unpack_seconds"`
+}
+
+// countingCodec records CodecStats around a batch.Codec.
+type countingCodec struct {
+	batch.Codec
+	pack, unpack codecCounters
+}
+
+type codecCounters struct {
+	calls, errors, bytes, nanos atomic.Int64
+}
+
+func (c *codecCounters) record(start time.Time, size int, err error) {
+	c.calls.Add(1)
+	c.nanos.Add(int64(time.Since(start)))
+	if err != nil {
+		c.errors.Add(1)
+		return
+	}
+	c.bytes.Add(int64(size))
+}
+
+func newCountingCodec(c batch.Codec) *countingCodec {
+	return &countingCodec{Codec: c}
+}
+
+func (c *countingCodec) Pack(b *batch.Batch) (batch.PackedBatch, error) {
+	start := time.Now()
+	p, err := c.Codec.Pack(b)
+	c.pack.record(start, len(p), err)
+	return p, err
+}
+
+func (c *countingCodec) Unpack(p batch.PackedBatch) (*batch.Batch, error) {
+	start := time.Now()
+	b, err := c.Codec.Unpack(p)
+	c.unpack.record(start, len(p), err)
+	return b, err
+}
+
+func (c *countingCodec) stats() CodecStats {
+	return CodecStats{
+		Packs:         c.pack.calls.Load(),
+		PackErrors:    c.pack.errors.Load(),
+		PackedBytes:   c.pack.bytes.Load(),
+		PackSeconds:   time.Duration(c.pack.nanos.Load()).Seconds(),
+		Unpacks:       c.unpack.calls.Load(),
+		UnpackErrors:  c.unpack.errors.Load(),
+		UnpackedBytes: c.unpack.bytes.Load(),
+		UnpackSeconds: time.Duration(c.unpack.nanos.Load()).Seconds(),
+	}
+}
@@ -2,6 +2,8 @@ package runtime
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
 	"github.com/planx-lab/planx-sdk-go/internal/batch"
@@ -11,29 +13,46 @@ import (
 
 type SourceSPI interface {
 	Init(ctx context.Context, config []byte) error
-	ReadBatch() (any, error)
+	ReadBatch(ctx context.Context) (*batch.Batch, error)
 	Close() error
 }
 
 type SourceServer struct {
 	pb.UnimplementedSourcePluginServer
 
-	factory  func() SourceSPI
-	sessions *session.Manager[*sourceSession]
-	codec    batch.Codec
+	proc      *Process
+	factories map[string]func() SourceSPI
+	sessions  *session.Manager[*sourceSession]
+	codec     batch.Codec
 }
 
 type sourceSession struct {
+	*sessionMeta
 	spi    SourceSPI
-	window *flow.Window
+	window flow.Policy
+	// adaptive is nil unless adaptive windowing is enabled.
+	adaptive *flow.Adaptive
+	unacked  unacked
+	acks     *flow.Coalescer
+	controls controls
+	// reads is held while the SPI reads, snapshots or commits, so that an
+	// admin savepoint falls between two reads.
+	reads sync.Mutex
+
+	mu     sync.Mutex
+	stop   context.CancelFunc
+	exited chan struct{}
 }
 
-func NewSourceServer(factory func() SourceSPI) *SourceServer {
-	return &SourceServer{
-		factory:  factory,
-		sessions: session.NewManager[*sourceSession](),
-		codec:    batch.NewCodec(),
+func NewSourceServer(proc *Process, factories map[string]func() SourceSPI) *SourceServer {
+	s := &SourceServer{
+		proc:      proc,
+		factories: factories,
+		sessions:  session.NewManager[*sourceSession](),
+		codec:     proc.codec,
 	}
+	proc.addServer(s)
+	return s
 }
 
 func (s *SourceServer) CreateSession(
@@ -41,54 +60,289 @@ func (s *SourceServer) CreateSession(
 	req *pb.SessionCreateRequest,
 ) (*pb.SessionCreateResponse, error) {
 
-	spi := s.factory()
-	if err := spi.Init(ctx, req.Config); err != nil {
+	window, err := s.proc.flowPolicy(ctx, 0)
+	if err != nil {
 		return nil, err
 	}
 
-	id := generateSessionID()
+	spi, meta, err := createSPI(s.proc, ctx, RoleSource, s.factories, req.Config)
+	if err != nil {
+		return nil, err
+	}
 
-	s.sessions.Add(id, &sourceSession{
-		spi:    spi,
-		window: flow.NewWindow(0),
-	})
+	sess := &sourceSession{
+		sessionMeta: meta,
+		spi:         spi,
+		window:      window,
+		unacked:     unacked{limit: s.proc.budget.Batches},
+	}
+	if s.proc.cfg.AdaptiveWindow {
+		sess.adaptive = flow.NewAdaptive(1, s.proc.cfg.AdaptiveWindowMax)
+	}
+	sess.acks = flow.NewCoalescer(s.proc.cfg.AckCoalesceInterval, sess.release)
+	s.sessions.Add(meta.id, sess)
 
 	return &pb.SessionCreateResponse{
-		SessionId: id,
+		SessionId: meta.id,
 	}, nil
 }
 
 func (s *SourceServer) OpenStream(
 	req *pb.StreamOpenRequest,
 	stream pb.SourcePlugin_OpenStreamServer,
-) error {
+) (err error) {
 
 	sess, ok := s.sessions.Get(req.SessionId)
 	if !ok {
 		return nil
 	}
 
-	sess.window.Release(int(req.InitialWindow))
+	ctx, done := sess.attach(stream.Context(), int(req.InitialWindow))
+	defer done()
+	defer s.logFlowStats(sess)
 
+	s.proc.audit(sess.sessionMeta, AuditStreamOpened, "", nil)
+	defer func() {
+		s.proc.audit(sess.sessionMeta, AuditStreamClosed, "", err)
+	}()
+
+	controls := func() error { return s.runControls(sess, ctx, stream) }
 	for {
-		sess.window.Acquire()
+		if err := controls(); err != nil {
+			return err
+		}
+		if err := s.acquire(sess, ctx, controls); err != nil {
+			return err
+		}
 
-		b, err := sess.spi.ReadBatch()
-		if err != nil {
+		if err := s.sendBatch(sess, ctx, stream); err != nil {
+			sess.unacked.ack(1)
+			if sess.adaptive != nil {
+				sess.adaptive.Release()
+				sess.adaptive.Fail()
+			}
 			return err
 		}
+	}
+}
 
-		packed, err := s.codec.Pack(b)
-		if err != nil {
+// attach makes stream the session's only sender and restores its window.
+// A stream reopened after a reconnect stops the previous send loop and
+// keeps batches still unacknowledged from it counted against the new
+// initial window, so acks that arrive late do not cause over-delivery.
+func (sess *sourceSession) attach(ctx context.Context, initial int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	exited := make(chan struct{})
+
+	sess.mu.Lock()
+	prevCancel, prevExited := sess.stop, sess.exited
+	sess.stop, sess.exited = cancel, exited
+	sess.mu.Unlock()
+
+	if prevCancel != nil {
+		prevCancel()
+		<-prevExited
+	}
+
+	sess.window.Reset(max(0, initial-int(sess.unacked.inflight())))
+
+	return ctx, func() {
+		cancel()
+		close(exited)
+	}
+}
+
+func (sess *sourceSession) lockReads() func() {
+	sess.reads.Lock()
+	return sess.reads.Unlock
+}
+
+// detach stops the session's send loop, if any, and waits for it to exit.
+func (sess *sourceSession) detach() {
+	sess.mu.Lock()
+	cancel, exited := sess.stop, sess.exited
+	sess.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-exited
+	}
+}
+
+// stallPoll bounds how long the send loop blocks before rechecking
+// whether the stream has ended.
+const stallPoll = time.Second
+
+// acquire waits for engine credit, adaptive window room if enabled, and
+// the process-wide in-flight batch budget, calling idle every stallPoll
+// meanwhile.
+func (s *SourceServer) acquire(sess *sourceSession, ctx context.Context, idle func() error) error {
+	if err := s.wait(sess, ctx, "engine credit", sess.window.AcquireTimeout, idle); err != nil {
+		return err
+	}
+	if sess.adaptive != nil {
+		if err := s.wait(sess, ctx, "adaptive window", sess.adaptive.AcquireTimeout, idle); err != nil {
+			sess.window.Release(1)
+			return err
+		}
+	}
+	if err := sess.unacked.acquire(ctx); err != nil {
+		if sess.adaptive != nil {
+			sess.adaptive.Release()
+		}
+		sess.window.Release(1)
+		return err
+	}
+	return nil
+}
+
+func (s *SourceServer) wait(
+	sess *sourceSession,
+	ctx context.Context,
+	what string,
+	try func(time.Duration) bool,
+	idle func() error,
+) error {
+	start := time.Now()
+	lastWarn := start
+	for !try(stallPoll) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := idle(); err != nil {
 			return err
 		}
+		if time.Since(lastWarn) >= s.proc.cfg.StallWarnInterval {
+			lastWarn = time.Now()
+			sess.log.Warn("planx: source stream stalled",
+				"waiting_for", what, "waited", time.Since(start))
+		}
+	}
+	return nil
+}
+
+func (s *SourceServer) sendBatch(
+	sess *sourceSession,
+	ctx context.Context,
+	stream pb.SourcePlugin_OpenStreamServer,
+) error {
 
-		if err := stream.Send(&pb.Batch{
-			Payload: packed,
-		}); err != nil {
+	var b *batch.Batch
+	unlock := sess.lockReads()
+	if s.proc.handingOff.Load() {
+		unlock()
+		return errHandingOff
+	}
+	err := s.proc.call(ctx, sess.sessionMeta, "ReadBatch", func() (err error) {
+		b, err = sess.spi.ReadBatch(ctx)
+		return err
+	})
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	packed, err := s.codec.Pack(b)
+	if err != nil {
+		return s.proc.fail(sess.sessionMeta, "", CategorizeError(ErrDataFormat, err))
+	}
+	payload, err := sess.plane.out(packed)
+	if err != nil {
+		return s.proc.fail(sess.sessionMeta, "", err)
+	}
+
+	n, err := s.proc.budget.Bytes.Acquire(ctx, int64(len(packed)))
+	if err != nil {
+		sess.plane.discard(payload)
+		return err
+	}
+	defer s.proc.budget.Bytes.Release(n)
+
+	if err := stream.Send(&pb.Batch{
+		Payload: payload,
+	}); err != nil {
+		sess.plane.discard(payload)
+		return err
+	}
+
+	s.proc.recordBatch(sess.sessionMeta, DirectionOut, b, len(packed))
+	batch.Done(b)
+	return nil
+}
+
+// runControls snapshots the session and sends a barrier for each
+// checkpoint requested since the last read, and commits the checkpoints
+// the engine completed, between reads of the SPI. A source waiting for
+// credit runs them within stallPoll. A failed commit is retried with the
+// next one.
+func (s *SourceServer) runControls(
+	sess *sourceSession,
+	ctx context.Context,
+	stream pb.SourcePlugin_OpenStreamServer,
+) error {
+
+	for _, c := range sess.controls.take() {
+		unlock := sess.lockReads()
+		if c.commit {
+			s.proc.commit(ctx, sess.sessionMeta, sess.spi, c.id)
+			unlock()
+			continue
+		}
+		barrier := s.proc.barrierFor(ctx, sess.sessionMeta, sess.spi, c.id)
+		unlock()
+		packed, err := s.codec.Pack(barrier)
+		if err != nil {
+			return s.proc.fail(sess.sessionMeta, "", CategorizeError(ErrDataFormat, err))
+		}
+		payload, err := sess.plane.out(packed)
+		if err != nil {
+			return s.proc.fail(sess.sessionMeta, "", err)
+		}
+		if err := stream.Send(&pb.Batch{Payload: payload}); err != nil {
+			sess.plane.discard(payload)
 			return err
 		}
 	}
+	return nil
+}
+
+func (s *SourceServer) snapshots() []SessionSnapshot {
+	var out []SessionSnapshot
+	for _, sess := range s.sessions.All() {
+		out = append(out, sess.snapshot(sess.window.Stats()))
+	}
+	return out
+}
+
+func (s *SourceServer) savepoint(ctx context.Context, id string, want func(string) bool) ([]SavepointSession, error) {
+	var out []SavepointSession
+	for _, sess := range s.sessions.All() {
+		if !want(sess.id) {
+			continue
+		}
+		sp, err := s.proc.savepointSession(ctx, sess.sessionMeta, sess.spi, id, sess.lockReads)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sp)
+	}
+	return out, nil
+}
+
+func (s *SourceServer) logFlowStats(sess *sourceSession) {
+	st := sess.window.Stats()
+	kv := []any{
+		"credits", st.Credits,
+		"acquires", st.Acquires,
+		"stalls", st.Stalls,
+		"wait_time", st.WaitTime,
+		"acks", st.Releases,
+		"ack_interval", st.ReleaseInterval,
+	}
+	if sess.adaptive != nil {
+		kv = append(kv, "adaptive_limit", sess.adaptive.Limit())
+	}
+	sess.log.Debug("planx: source stream closed", kv...)
 }
 
 func (s *SourceServer) Ack(
@@ -98,22 +352,54 @@ func (s *SourceServer) Ack(
 
 	sess, ok := s.sessions.Get(req.SessionId)
 	if ok {
-		sess.window.Release(int(req.NewWindow))
+		if id := incoming(ctx, committedMetadata); id != "" {
+			sess.controls.add(id, true)
+		}
+		if id := incoming(ctx, checkpointMetadata); id != "" {
+			sess.controls.add(id, false)
+		}
+		sess.acks.Add(int(req.NewWindow))
 	}
 
 	return &pb.AckResponse{}, nil
 }
 
+// release applies credits acknowledged by the engine.
+func (sess *sourceSession) release(n int) {
+	sess.window.Release(n)
+	if sess.adaptive != nil {
+		sess.adaptive.Ack(n)
+	}
+	sess.unacked.ack(int64(n))
+}
+
 func (s *SourceServer) CloseSession(
 	ctx context.Context,
 	req *pb.SessionCloseRequest,
 ) (*pb.Empty, error) {
 
-	sess, ok := s.sessions.Get(req.SessionId)
+	s.closeSession(ctx, req.SessionId)
+	return &pb.Empty{}, nil
+}
+
+func (s *SourceServer) closeSession(ctx context.Context, id string) bool {
+	sess, ok := s.sessions.Get(id)
 	if ok {
-		_ = sess.spi.Close()
-		s.sessions.Remove(req.SessionId)
+		s.proc.closeSPI(ctx, sess.sessionMeta, sess.spi)
+		sess.acks.Stop()
+		sess.unacked.ack(-1)
+		s.sessions.Remove(id)
 	}
+	return ok
+}
 
-	return &pb.Empty{}, nil
+// forceClose also ends the session's stream, which the engine would
+// otherwise have closed before CloseSession.
+func (s *SourceServer) forceClose(ctx context.Context, id string) bool {
+	sess, ok := s.sessions.Get(id)
+	if !ok {
+		return false
+	}
+	sess.detach()
+	return s.closeSession(ctx, id)
 }
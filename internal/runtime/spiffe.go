@@ -0,0 +1,277 @@
+package runtime
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The SPIFFE Workload API streams X509SVIDResponse messages:
+//
+//	message X509SVIDResponse { repeated X509SVID svids = 1; ... }
+//	message X509SVID {
+//		string spiffe_id = 1;
+//		bytes x509_svid = 2;     // ASN.1 DER certificate chain
+//		bytes x509_svid_key = 3; // PKCS#8 DER private key
+//		bytes bundle = 4;        // ASN.1 DER trust bundle certificates
+//	}
+//
+// They are decoded by hand so the SDK does not depend on the SPIFFE
+// client libraries.
+const (
+	workloadFetchX509 = "/SpiffeWorkloadAPI/FetchX509SVID"
+	workloadHeader    = "workload.spiffe.io"
+
+	// workloadFirstTimeout bounds the wait for the first SVID at startup.
+	workloadFirstTimeout = 30 * time.Second
+	workloadRetryMax     = 30 * time.Second
+)
+
+// svid is the plugin's current X.509 identity and trust bundle.
+type svid struct {
+	id     string
+	cert   tls.Certificate
+	bundle *x509.CertPool
+}
+
+// workloadSource keeps the latest SVID fetched from a Workload API
+// socket. The agent pushes a new one before each rotation.
+type workloadSource struct {
+	conn *grpc.ClientConn
+	stop context.CancelFunc
+
+	mu      sync.RWMutex
+	current *svid
+}
+
+// newWorkloadSource connects to the Workload API at addr, e.g.
+// "unix:///run/spire/sockets/agent.sock", waits for the first SVID and
+// then follows updates in the background, reconnecting as needed.
+func newWorkloadSource(addr string, log Logger) (*workloadSource, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("planx: spiffe workload api: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &workloadSource{conn: conn, stop: cancel}
+	first := make(chan error, 1)
+	go w.watch(ctx, log, first)
+
+	select {
+	case err = <-first:
+	case <-time.After(workloadFirstTimeout):
+		err = fmt.Errorf("no SVID from %s within %s", addr, workloadFirstTimeout)
+	}
+	if err != nil {
+		w.close()
+		return nil, fmt.Errorf("planx: spiffe workload api: %w", err)
+	}
+	return w, nil
+}
+
+func (w *workloadSource) close() {
+	w.stop()
+	w.conn.Close()
+}
+
+// watch streams SVID updates for the life of the process. The outcome
+// of the first update is sent on first; failures after that are logged
+// and retried with backoff.
+func (w *workloadSource) watch(ctx context.Context, log Logger, first chan<- error) {
+	backoff := time.Second
+	for {
+		err := w.stream(ctx, func() {
+			if first != nil {
+				first <- nil
+				first = nil
+			}
+			backoff = time.Second
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if first != nil && errors.Is(err, errBadSVID) {
+			first <- err
+			return
+		}
+		log.Warn("planx: spiffe workload api stream ended, reconnecting", "error", err, "retry_in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, workloadRetryMax)
+	}
+}
+
+var errBadSVID = errors.New("invalid X509SVIDResponse")
+
+func (w *workloadSource) stream(ctx context.Context, updated func()) error {
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, workloadHeader, "true"))
+	defer cancel()
+	s, err := w.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, workloadFetchX509, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+	if err := s.SendMsg(&rawMessage{}); err != nil {
+		return err
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var msg rawMessage
+		if err := s.RecvMsg(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("stream closed by agent")
+			}
+			return err
+		}
+		next, err := parseX509SVIDResponse(msg.data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadSVID, err)
+		}
+		w.mu.Lock()
+		w.current = next
+		w.mu.Unlock()
+		updated()
+	}
+}
+
+func (w *workloadSource) svid() *svid {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// tlsConfig serves the current SVID and requires engine certificates
+// signed by the current trust bundle.
+func (w *workloadSource) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s := w.svid()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{s.cert},
+				ClientCAs:    s.bundle,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// parseX509SVIDResponse returns the first, default SVID of a response.
+func parseX509SVIDResponse(b []byte) (*svid, error) {
+	var first []byte
+	err := fields(b, func(num protowire.Number, v []byte) {
+		if num == 1 && first == nil {
+			first = v
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if first == nil {
+		return nil, errors.New("no SVIDs")
+	}
+
+	var id string
+	var chain, key, bundle []byte
+	if err := fields(first, func(num protowire.Number, v []byte) {
+		switch num {
+		case 1:
+			id = string(v)
+		case 2:
+			chain = v
+		case 3:
+			key = v
+		case 4:
+			bundle = v
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	certs, err := x509.ParseCertificates(chain)
+	if err != nil || len(certs) == 0 {
+		return nil, fmt.Errorf("svid %s: bad certificate chain: %v", id, err)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("svid %s: bad private key: %w", id, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("svid %s: unsupported private key type %T", id, priv)
+	}
+	roots, err := x509.ParseCertificates(bundle)
+	if err != nil || len(roots) == 0 {
+		return nil, fmt.Errorf("svid %s: bad trust bundle: %v", id, err)
+	}
+
+	s := &svid{
+		id:     id,
+		cert:   tls.Certificate{PrivateKey: signer, Leaf: certs[0]},
+		bundle: x509.NewCertPool(),
+	}
+	for _, c := range certs {
+		s.cert.Certificate = append(s.cert.Certificate, c.Raw)
+	}
+	for _, c := range roots {
+		s.bundle.AddCert(c)
+	}
+	return s, nil
+}
+
+// fields calls fn with each length-delimited field of a protobuf
+// message, skipping fields of other wire types.
+func fields(b []byte, fn func(protowire.Number, []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		fn(num, v)
+		b = b[n:]
+	}
+	return nil
+}
+
+// rawMessage carries undecoded protobuf bytes through rawCodec.
+type rawMessage struct{ data []byte }
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v any) ([]byte, error) { return v.(*rawMessage).data, nil }
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	v.(*rawMessage).data = append([]byte(nil), data...)
+	return nil
+}
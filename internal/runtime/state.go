@@ -0,0 +1,19 @@
+package runtime
+
+// StateStats describes the plugin's state backend: the entries it holds,
+// the size of its files, and the entries expired and logs compacted by
+// its background collection.
+type StateStats struct {
+	Entries     int64 `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+	Expired     int64 `json:"expired"`
+	Compactions int64 `json:"compactions"`
+}
+
+// StateStats returns the stats of the state backend, if it reports them.
+func (r *Process) StateStats() (StateStats, bool) {
+	if r.stateStats == nil {
+		return StateStats{}, false
+	}
+	return r.stateStats()
+}
@@ -0,0 +1,193 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+)
+
+const tapAdmin = "admin"
+
+// TappedBatch is a truncated copy of a batch mirrored by the debug tap.
+type TappedBatch struct {
+	Time      time.Time         `json:"time"`
+	SessionID string            `json:"session_id"`
+	Role      string            `json:"role"`
+	Connector string            `json:"connector,omitempty"`
+	Direction string            `json:"direction"`
+	Records   int               `json:"records"`
+	Bytes     int               `json:"bytes"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	// Sample holds up to tap_max_records records with payloads cut to
+	// tap_max_payload bytes.
+	Sample []TappedRecord `json:"sample,omitempty"`
+}
+
+type TappedRecord struct {
+	Size      int               `json:"size"`
+	Payload   []byte            `json:"payload,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// tap mirrors a sample of batches to a debug destination.
+type tap struct {
+	percent    float64
+	filter     map[string]string
+	maxRecords int
+	maxPayload int
+	emit       func(meta *sessionMeta, t TappedBatch)
+
+	mu   sync.Mutex
+	subs map[chan TappedBatch]struct{}
+}
+
+func validTapTarget(spec string) bool {
+	return spec == "" || spec == "log" || spec == tapAdmin || strings.HasPrefix(spec, fileTargetPrefix)
+}
+
+// parseTapFilter reads "key=value,key=value".
+func parseTapFilter(spec string) (map[string]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	out := make(map[string]string)
+	for _, kv := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("tap_filter: want key=value, got %q", kv)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// newTap returns nil when tap_target is empty. With keys set, each line
+// of a tap file is encrypted.
+func newTap(cfg Config, log Logger, keys KeyProvider) (*tap, error) {
+	if cfg.TapTarget == "" {
+		return nil, nil
+	}
+	filter, err := parseTapFilter(cfg.TapFilter)
+	if err != nil {
+		return nil, fmt.Errorf("planx: %w", err)
+	}
+	t := &tap{
+		percent:    cfg.TapPercent,
+		filter:     filter,
+		maxRecords: cfg.TapMaxRecords,
+		maxPayload: cfg.TapMaxPayload,
+		subs:       make(map[chan TappedBatch]struct{}),
+	}
+
+	switch {
+	case cfg.TapTarget == "log":
+		// Payloads never go to the log; only their sizes do.
+		t.emit = func(meta *sessionMeta, tb TappedBatch) {
+			for i := range tb.Sample {
+				tb.Sample[i].Payload = nil
+			}
+			sample, _ := json.Marshal(tb.Sample)
+			meta.log.Info("planx: tap",
+				"direction", tb.Direction,
+				"records", tb.Records,
+				"bytes", tb.Bytes,
+				"metadata", tb.Metadata,
+				"sample", string(sample),
+			)
+		}
+
+	case cfg.TapTarget == tapAdmin:
+		t.emit = t.publish
+
+	default:
+		path := strings.TrimPrefix(cfg.TapTarget, fileTargetPrefix)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("planx: open tap file: %w", err)
+		}
+		var w io.Writer = f
+		if keys != nil {
+			w = &sealedLines{w: f, keys: keys}
+		}
+		var mu sync.Mutex
+		enc := json.NewEncoder(w)
+		t.emit = func(_ *sessionMeta, tb TappedBatch) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := enc.Encode(tb); err != nil {
+				log.Error("planx: write tap file", "path", path, "error", err)
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *tap) matches(b *batch.Batch) bool {
+	for k, v := range t.filter {
+		if b.Metadata[k] != v {
+			return false
+		}
+	}
+	return t.percent >= 100 || rand.Float64()*100 < t.percent
+}
+
+// observe mirrors b if it is selected. Metadata passes through the
+// session's redaction settings.
+func (t *tap) observe(meta *sessionMeta, direction string, b *batch.Batch, bytes int) {
+	if b == nil || !t.matches(b) {
+		return
+	}
+	tb := TappedBatch{
+		Time:      time.Now().UTC(),
+		SessionID: meta.id,
+		Role:      meta.labels.Role,
+		Connector: meta.labels.Connector,
+		Direction: direction,
+		Records:   b.Len(),
+		Bytes:     bytes,
+		Metadata:  meta.logFilter.RedactMap(b.Metadata),
+	}
+	for _, rec := range b.Records[:min(len(b.Records), t.maxRecords)] {
+		n := min(len(rec.Payload), t.maxPayload)
+		tb.Sample = append(tb.Sample, TappedRecord{
+			Size:      len(rec.Payload),
+			Payload:   append([]byte(nil), rec.Payload[:n]...),
+			Truncated: n < len(rec.Payload),
+			Metadata:  meta.logFilter.RedactMap(rec.Metadata),
+		})
+	}
+	t.emit(meta, tb)
+}
+
+// subscribe registers a consumer for the admin target. Batches are
+// dropped for a subscriber that falls behind.
+func (t *tap) subscribe() (<-chan TappedBatch, func()) {
+	ch := make(chan TappedBatch, 64)
+	t.mu.Lock()
+	t.subs[ch] = struct{}{}
+	t.mu.Unlock()
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.subs, ch)
+		t.mu.Unlock()
+	}
+}
+
+func (t *tap) publish(_ *sessionMeta, tb TappedBatch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ch := range t.subs {
+		select {
+		case ch <- tb:
+		default:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package runtime
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantUsage is the traffic of one tenant across all of its sessions
+// over [Start, End).
+type TenantUsage struct {
+	TenantID string       `json:"tenant_id"`
+	Start    time.Time    `json:"start"`
+	End      time.Time    `json:"end"`
+	In       TrafficStats `json:"in"`
+	Out      TrafficStats `json:"out"`
+}
+
+// tenantLedger accumulates per-tenant traffic for chargeback. Totals
+// cover the life of the process; the pending period is handed to the
+// usage callback and reset on every flush.
+type tenantLedger struct {
+	mu          sync.Mutex
+	started     time.Time
+	periodStart time.Time
+	total       map[string]*[2]TrafficStats
+	period      map[string]*[2]TrafficStats
+}
+
+func newTenantLedger() *tenantLedger {
+	now := time.Now().UTC()
+	return &tenantLedger{
+		started:     now,
+		periodStart: now,
+		total:       make(map[string]*[2]TrafficStats),
+		period:      make(map[string]*[2]TrafficStats),
+	}
+}
+
+func (l *tenantLedger) add(tenant, dir string, records, bytes int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range []map[string]*[2]TrafficStats{l.total, l.period} {
+		t := m[tenant]
+		if t == nil {
+			t = new([2]TrafficStats)
+			m[tenant] = t
+		}
+		s := &t[direction(dir)]
+		s.Batches++
+		s.Records += int64(records)
+		s.Bytes += int64(bytes)
+	}
+}
+
+// totals returns usage since the process started, sorted by tenant.
+func (l *tenantLedger) totals() []TenantUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return report(l.total, l.started, time.Now().UTC())
+}
+
+// flush returns usage since the previous flush and starts a new period.
+func (l *tenantLedger) flush() []TenantUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC()
+	out := report(l.period, l.periodStart, now)
+	l.period = make(map[string]*[2]TrafficStats)
+	l.periodStart = now
+	return out
+}
+
+func report(m map[string]*[2]TrafficStats, start, end time.Time) []TenantUsage {
+	out := make([]TenantUsage, 0, len(m))
+	for tenant, t := range m {
+		out = append(out, TenantUsage{
+			TenantID: tenant,
+			Start:    start,
+			End:      end,
+			In:       t[0],
+			Out:      t[1],
+		})
+	}
+	slices.SortFunc(out, func(a, b TenantUsage) int {
+		return strings.Compare(a.TenantID, b.TenantID)
+	})
+	return out
+}
+
+// TenantUsage reports per-tenant traffic since the process started.
+func (r *Process) TenantUsage() []TenantUsage {
+	return r.tenants.totals()
+}
+
+// flushUsage hands each period's usage to fn every interval. Periods
+// without traffic are skipped.
+func (r *Process) flushUsage(interval time.Duration, fn func([]TenantUsage)) {
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for range tick.C {
+			if usage := r.tenants.flush(); len(usage) > 0 {
+				fn(usage)
+			}
+		}
+	}()
+}
@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// newServerCredentials returns the TLS credentials of the plugin
+// listener, or nil when neither tls_cert_file nor spiffe_endpoint_socket
+// is set. With tls_client_ca_file or a SPIFFE identity the engine must
+// present a certificate signed by a trusted CA (mTLS).
+func newServerCredentials(cfg Config, log Logger) (credentials.TransportCredentials, error) {
+	if cfg.SPIFFEEndpointSocket != "" {
+		w, err := newWorkloadSource(cfg.SPIFFEEndpointSocket, log)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("planx: using spiffe identity", "spiffe_id", w.svid().id)
+		return credentials.NewTLS(w.tlsConfig()), nil
+	}
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("planx: load tls certificate: %w", err)
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("planx: read tls client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("planx: no certificates in tls_client_ca_file %s", cfg.TLSClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(tc), nil
+}
+
+// peerIdentity returns the identity in the verified client certificate
+// of the call, if the connection uses mTLS. Certificates that were not
+// verified against the client CAs are ignored.
+func peerIdentity(ctx context.Context) session.Peer {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return session.Peer{}
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return session.Peer{}
+	}
+	leaf := info.State.VerifiedChains[0][0]
+	id := session.Peer{CommonName: leaf.Subject.CommonName}
+	for _, u := range leaf.URIs {
+		if u.Scheme == "spiffe" {
+			id.SPIFFEID = u.String()
+			break
+		}
+	}
+	return id
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tracePropagator reads the W3C traceparent/tracestate and baggage
+// headers the engine sends. It is fixed rather than taken from the OTel
+// global, which is a no-op unless the plugin installs one.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// mdCarrier adapts incoming gRPC metadata to the OTel carrier interface.
+type mdCarrier metadata.MD
+
+func (c mdCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c mdCarrier) Set(key, value string) { metadata.MD(c).Set(key, value) }
+
+func (c mdCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// withTrace places the engine's trace context and baggage from incoming
+// metadata into ctx, so OTel-instrumented clients used by the SPI join
+// the engine's trace.
+func withTrace(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return tracePropagator.Extract(ctx, mdCarrier(md))
+}
+
+func traceUnary(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	return handler(withTrace(ctx), req)
+}
+
+func traceStream(
+	srv any,
+	ss grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return handler(srv, &tracedStream{ServerStream: ss, ctx: withTrace(ss.Context())})
+}
+
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context { return s.ctx }
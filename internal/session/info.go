@@ -0,0 +1,94 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+)
+
+type Info struct {
+	ID       string
+	TenantID string
+	// Peer is the verified identity of the engine that created the
+	// session; empty unless the plugin serves mTLS.
+	Peer Peer
+	// StateNamespace names the state the session reads and writes, from
+	// the x-planx-state-namespace metadata; empty when not given.
+	StateNamespace string
+	// RestoreCheckpoint, from the x-planx-restore-checkpoint metadata,
+	// is the checkpoint of StateNamespace the session resumes from.
+	RestoreCheckpoint string
+	// ExactlyOnce is set when the engine asked for exactly-once delivery
+	// with the x-planx-delivery metadata.
+	ExactlyOnce bool
+	// StateChangelog, set by the x-planx-state-changelog: true metadata,
+	// mirrors the session's state to the plugin's changelog.
+	StateChangelog bool
+	// CheckpointFullInterval is the checkpoint_full_interval setting.
+	CheckpointFullInterval int
+	// Mode is how the engine wants the session to read, from the
+	// x-planx-mode metadata.
+	Mode Mode
+	// Log samples and redacts the session's log output; nil when
+	// neither is configured.
+	Log *logging.Filter
+	// Metrics records the measurements the SPI defines for the session
+	// in the plugin's metrics backend.
+	Metrics Metrics
+}
+
+// Metrics records plugin-defined measurements: Add adds to a counter,
+// Set sets a gauge and Observe records a value in a histogram.
+type Metrics interface {
+	Add(name string, delta float64)
+	Set(name string, value float64)
+	Observe(name string, value float64)
+}
+
+// Session modes.
+const (
+	// ModeLive tails new data as it arrives. This is the default.
+	ModeLive = "live"
+	// ModeBackfill reads historical data, e.g. with a full table scan or
+	// from archived logs, between optional bounds.
+	ModeBackfill = "backfill"
+)
+
+// Mode is how a session reads: live, or backfilling between Start and
+// End, from the x-planx-backfill-start and x-planx-backfill-end metadata.
+// A zero bound leaves that end open.
+type Mode struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Backfill reports whether the session reads historical data.
+func (m Mode) Backfill() bool { return m.Name == ModeBackfill }
+
+// Peer identifies the engine from its verified client certificate.
+type Peer struct {
+	// SPIFFEID is the spiffe:// URI SAN of the certificate, if any.
+	SPIFFEID   string
+	CommonName string
+}
+
+// String returns the SPIFFE ID, falling back to the common name.
+func (p Peer) String() string {
+	if p.SPIFFEID != "" {
+		return p.SPIFFEID
+	}
+	return p.CommonName
+}
+
+type infoKey struct{}
+
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+func InfoFromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(infoKey{}).(Info)
+	return info, ok
+}
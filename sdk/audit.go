@@ -0,0 +1,19 @@
+package sdk
+
+import "github.com/planx-lab/planx-sdk-go/internal/runtime"
+
+// AuditEvent records a session lifecycle step (created or denied, stream
+// opened or closed, errored, closed) with its time, tenant, the engine that
+// created the session and, for session_closed, who closed it.
+type AuditEvent = runtime.AuditEvent
+
+type AuditEventType = runtime.AuditEventType
+
+const (
+	AuditSessionCreated = runtime.AuditSessionCreated
+	AuditSessionDenied  = runtime.AuditSessionDenied
+	AuditStreamOpened   = runtime.AuditStreamOpened
+	AuditStreamClosed   = runtime.AuditStreamClosed
+	AuditSessionError   = runtime.AuditSessionError
+	AuditSessionClosed  = runtime.AuditSessionClosed
+)
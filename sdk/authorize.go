@@ -0,0 +1,28 @@
+package sdk
+
+import (
+	"context"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+)
+
+// AuthorizationRequest describes a session the engine asks to create:
+// the tenant, the connector role and name, and the engine's reported ID
+// and verified mTLS identity.
+type AuthorizationRequest = runtime.AuthRequest
+
+// Authorizer decides which tenants and engines may use which
+// connectors. Authorize is called before SPI.Init; a nil error allows
+// the session and any other result denies it. Denials are returned to
+// the engine as PERMISSION_DENIED unless the error carries a gRPC
+// status, and are audited as session_denied.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AuthorizationRequest) error
+}
+
+// AuthorizerFunc adapts a function to Authorizer.
+type AuthorizerFunc func(ctx context.Context, req AuthorizationRequest) error
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, req AuthorizationRequest) error {
+	return f(ctx, req)
+}
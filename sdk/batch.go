@@ -1,3 +1,37 @@
 package sdk
 
-type Batch interface{}
+import "github.com/planx-lab/planx-sdk-go/internal/batch"
+
+// Batch is the unit of data read from sources, transformed by processors
+// and written to sinks. Record payloads are opaque bytes.
+//
+// The SDK reuses the storage of batches to spare the garbage collector at
+// high record rates, under this contract:
+//
+//   - A batch passed to Process or WriteBatch may be handed back with
+//     ReleaseBatch once the SPI is done with it, during the call or after
+//     it, e.g. when a buffering sink has flushed it. Batches that are not
+//     released are left to the garbage collector, as before.
+//   - A batch from NewBatch that a source returns from ReadBatch, or a
+//     processor from Process, is released by the SDK once it has sent it
+//     on; the SPI must not use it afterwards.
+//
+// Nothing of a released batch can be used afterwards: its records, their
+// payloads and metadata maps are reused too. Batches that share records
+// or maps must therefore not both be released.
+type Batch = batch.Batch
+
+type Record = batch.Record
+
+// NewBatch returns an empty batch for a source or processor to fill,
+// reusing the storage of a released one. Its metadata may be an empty
+// map rather than nil.
+func NewBatch() *Batch {
+	return batch.Get()
+}
+
+// ReleaseBatch hands b back to the SDK for its storage to be reused. See
+// Batch for when a batch may be released.
+func ReleaseBatch(b *Batch) {
+	batch.Release(b)
+}
@@ -0,0 +1,122 @@
+package batchtest
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/planx-lab/planx-sdk-go/sdk"
+)
+
+// maxDiffs bounds the record differences Diff lists, so a test feeding
+// thousands of records does not print them all.
+const maxDiffs = 10
+
+// AssertEqual reports an error on t and returns false when got differs
+// from want. See Diff for what is compared.
+func AssertEqual(t testing.TB, want, got *sdk.Batch) bool {
+	t.Helper()
+	if d := Diff(want, got); d != "" {
+		t.Errorf("batch mismatch (-want +got):\n%s", d)
+		return false
+	}
+	return true
+}
+
+// Diff describes how got differs from want, or returns "" if they are
+// equal. Records are compared in order by payload and metadata. A nil
+// batch equals an empty one and nil metadata equals an empty map.
+func Diff(want, got *sdk.Batch) string {
+	if want == nil {
+		want = &sdk.Batch{}
+	}
+	if got == nil {
+		got = &sdk.Batch{}
+	}
+
+	var out strings.Builder
+	diffMeta(&out, "batch metadata", want.Metadata, got.Metadata)
+	if len(want.Records) != len(got.Records) {
+		fmt.Fprintf(&out, "record count: -%d +%d\n", len(want.Records), len(got.Records))
+	}
+
+	shown := 0
+	for i := range max(len(want.Records), len(got.Records)) {
+		if shown == maxDiffs {
+			out.WriteString("...\n")
+			break
+		}
+		var buf strings.Builder
+		switch {
+		case i >= len(got.Records):
+			fmt.Fprintf(&buf, "record %d: missing, want %s\n", i, describe(want.Records[i]))
+		case i >= len(want.Records):
+			fmt.Fprintf(&buf, "record %d: unexpected %s\n", i, describe(got.Records[i]))
+		default:
+			w, g := want.Records[i], got.Records[i]
+			if !bytes.Equal(w.Payload, g.Payload) {
+				fmt.Fprintf(&buf, "record %d payload:\n\t-%s\n\t+%s\n", i, payload(w.Payload), payload(g.Payload))
+			}
+			diffMeta(&buf, fmt.Sprintf("record %d metadata", i), w.Metadata, g.Metadata)
+		}
+		if buf.Len() > 0 {
+			out.WriteString(buf.String())
+			shown++
+		}
+	}
+	return out.String()
+}
+
+func diffMeta(out *strings.Builder, what string, want, got map[string]string) {
+	keys := slices.Collect(maps.Keys(want))
+	for k := range got {
+		if _, ok := want[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var lines []string
+	for _, k := range keys {
+		w, inWant := want[k]
+		g, inGot := got[k]
+		switch {
+		case !inGot:
+			lines = append(lines, fmt.Sprintf("\t-%s=%q", k, w))
+		case !inWant:
+			lines = append(lines, fmt.Sprintf("\t+%s=%q", k, g))
+		case w != g:
+			lines = append(lines, fmt.Sprintf("\t-%s=%q", k, w), fmt.Sprintf("\t+%s=%q", k, g))
+		}
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(out, "%s:\n%s\n", what, strings.Join(lines, "\n"))
+	}
+}
+
+func describe(r sdk.Record) string {
+	s := payload(r.Payload)
+	if len(r.Metadata) > 0 {
+		s += fmt.Sprintf(" %v", r.Metadata)
+	}
+	return s
+}
+
+// payload formats p as a quoted string when it is text and as hex
+// otherwise, cut to 64 bytes.
+func payload(p []byte) string {
+	const limit = 64
+	suffix := ""
+	if len(p) > limit {
+		suffix = fmt.Sprintf("... (%d bytes)", len(p))
+		p = p[:limit]
+	}
+	if utf8.Valid(p) {
+		return fmt.Sprintf("%q%s", p, suffix)
+	}
+	return fmt.Sprintf("%x%s", p, suffix)
+}
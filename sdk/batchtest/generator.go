@@ -0,0 +1,168 @@
+package batchtest
+
+import (
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/planx-lab/planx-sdk-go/sdk"
+)
+
+// Range is an inclusive range of sizes. A Max below Min means Min.
+type Range struct {
+	Min, Max int
+}
+
+func (r Range) pick(rng *rand.Rand) int {
+	if r.Max <= r.Min {
+		return r.Min
+	}
+	return r.Min + rng.IntN(r.Max-r.Min+1)
+}
+
+// Distribution picks a metadata value. It returns false to leave the
+// key out of the record.
+type Distribution func(rng *rand.Rand) (string, bool)
+
+// Constant always yields v.
+func Constant(v string) Distribution {
+	return func(*rand.Rand) (string, bool) { return v, true }
+}
+
+// Uniform picks one of values with equal probability.
+func Uniform(values ...string) Distribution {
+	return func(rng *rand.Rand) (string, bool) {
+		if len(values) == 0 {
+			return "", false
+		}
+		return values[rng.IntN(len(values))], true
+	}
+}
+
+// Weighted picks a value with probability proportional to its weight.
+// Values with a weight of zero or less are never picked.
+func Weighted(weights map[string]int) Distribution {
+	// Sort keys so the same seed picks the same values regardless of
+	// map iteration order.
+	keys := sortedKeys(weights)
+	total := 0
+	for _, k := range keys {
+		total += max(0, weights[k])
+	}
+	return func(rng *rand.Rand) (string, bool) {
+		if total == 0 {
+			return "", false
+		}
+		n := rng.IntN(total)
+		for _, k := range keys {
+			if n -= max(0, weights[k]); n < 0 {
+				return k, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Sometimes applies d to a fraction p of records and leaves the key out
+// of the rest.
+func Sometimes(p float64, d Distribution) Distribution {
+	return func(rng *rand.Rand) (string, bool) {
+		if rng.Float64() >= p {
+			return "", false
+		}
+		return d(rng)
+	}
+}
+
+// Sequence yields prefix followed by an increasing counter, for keys
+// such as record IDs that must be unique. The counter is shared by every
+// generator the distribution is used with.
+func Sequence(prefix string) Distribution {
+	var n int64
+	return func(*rand.Rand) (string, bool) {
+		n++
+		return prefix + strconv.FormatInt(n, 10), true
+	}
+}
+
+// Config describes the batches a Generator yields.
+type Config struct {
+	// Seed makes the output deterministic: generators with the same
+	// config yield the same batches.
+	Seed uint64
+	// Records is the number of records per batch.
+	Records Range
+	// PayloadSize is the size of each record payload in bytes.
+	PayloadSize Range
+	// Metadata gives the distribution of each record metadata key.
+	Metadata map[string]Distribution
+	// BatchMetadata gives the distribution of each batch metadata key.
+	BatchMetadata map[string]Distribution
+}
+
+// Generator yields random batches from a seed. It is not safe for
+// concurrent use.
+type Generator struct {
+	cfg       Config
+	rng       *rand.Rand
+	keys      []string
+	batchKeys []string
+}
+
+// NewGenerator returns a generator for cfg.
+func NewGenerator(cfg Config) *Generator {
+	return &Generator{
+		cfg:       cfg,
+		rng:       rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		keys:      sortedKeys(cfg.Metadata),
+		batchKeys: sortedKeys(cfg.BatchMetadata),
+	}
+}
+
+// Next returns the next batch.
+func (g *Generator) Next() *sdk.Batch {
+	n := g.cfg.Records.pick(g.rng)
+	b := &sdk.Batch{
+		Records:  make([]sdk.Record, n),
+		Metadata: g.metadata(g.cfg.BatchMetadata, g.batchKeys),
+	}
+	for i := range b.Records {
+		b.Records[i] = g.Record()
+	}
+	return b
+}
+
+// Batches returns the next n batches.
+func (g *Generator) Batches(n int) []*sdk.Batch {
+	out := make([]*sdk.Batch, n)
+	for i := range out {
+		out[i] = g.Next()
+	}
+	return out
+}
+
+// Record returns the next record.
+func (g *Generator) Record() sdk.Record {
+	payload := make([]byte, g.cfg.PayloadSize.pick(g.rng))
+	for i := range payload {
+		payload[i] = byte(g.rng.Uint32())
+	}
+	return sdk.Record{
+		Payload:  payload,
+		Metadata: g.metadata(g.cfg.Metadata, g.keys),
+	}
+}
+
+func (g *Generator) metadata(dists map[string]Distribution, keys []string) map[string]string {
+	var m map[string]string
+	for _, k := range keys {
+		v, ok := dists[k](g.rng)
+		if !ok {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]string, len(keys))
+		}
+		m[k] = v
+	}
+	return m
+}
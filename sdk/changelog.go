@@ -0,0 +1,32 @@
+package sdk
+
+import (
+	"errors"
+
+	"github.com/planx-lab/planx-sdk-go/sdk/state"
+)
+
+// checkChangelog reports why the session cannot mirror its state to a
+// changelog, if it cannot.
+func (w *spiWrapper) checkChangelog() error {
+	switch {
+	case w.changelog == nil:
+		return ConfigError(errors.New("planx: a state changelog was requested but the plugin has none"))
+	case w.ephemeral:
+		return ConfigError(errors.New("planx: a state changelog requires a state namespace"))
+	}
+	return nil
+}
+
+// rebuild restores the state of namespace from the changelog into s, its
+// store in the backend, if the backend lost it.
+func (w *spiWrapper) rebuild(namespace string, s state.Store) error {
+	n, err := state.Rebuild(s, namespace, w.changelog)
+	if err != nil {
+		return TransientError(err)
+	}
+	if n > 0 {
+		w.sc.Logger.Info("planx: state rebuilt from changelog", "state_namespace", namespace, "entries", n)
+	}
+	return nil
+}
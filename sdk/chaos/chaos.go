@@ -0,0 +1,303 @@
+// Package chaos wraps SPIs with injected faults, to check that a
+// pipeline's retries and duplicate handling hold up before production:
+//
+//	cfg, err := chaos.ConfigFromEnv()
+//	...
+//	plugin.AddSink("s3", func() sdk.SinkSPI { return chaos.Sink(newSink(), cfg) })
+//
+// A zero Config injects nothing, so the wrappers can stay in place and
+// be switched on through the PLANX_CHAOS_* environment variables.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/sdk"
+)
+
+// ErrInjected is wrapped by every error the wrappers inject. Injected
+// errors are categorized as transient so the engine retries them.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Config selects the faults to inject. Rates are probabilities in [0, 1]
+// applied per data call: ReadBatch, WriteBatch or Process.
+type Config struct {
+	// Seed makes the injected faults reproducible.
+	Seed uint64
+	// Latency is added before every data call, plus a random extra of
+	// up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// ErrorRate fails data calls with ErrInjected before they reach the
+	// SPI.
+	ErrorRate float64
+	// DropAckRate makes sink writes that succeeded report a failure, as
+	// when an ack is lost, so the engine redelivers the batch.
+	DropAckRate float64
+	// DuplicateRate makes the source return a batch a second time.
+	DuplicateRate float64
+	// EOFDelay holds back io.EOF from the source.
+	EOFDelay time.Duration
+}
+
+// ConfigFromEnv reads PLANX_CHAOS_SEED, PLANX_CHAOS_LATENCY,
+// PLANX_CHAOS_JITTER, PLANX_CHAOS_ERROR_RATE, PLANX_CHAOS_DROP_ACK_RATE,
+// PLANX_CHAOS_DUPLICATE_RATE and PLANX_CHAOS_EOF_DELAY. Durations use
+// time.ParseDuration syntax. Unset variables leave the fault off.
+func ConfigFromEnv() (Config, error) {
+	return configFrom(os.Getenv)
+}
+
+func configFrom(getenv func(string) string) (Config, error) {
+	var cfg Config
+	durations := map[string]*time.Duration{
+		"PLANX_CHAOS_LATENCY":   &cfg.Latency,
+		"PLANX_CHAOS_JITTER":    &cfg.Jitter,
+		"PLANX_CHAOS_EOF_DELAY": &cfg.EOFDelay,
+	}
+	rates := map[string]*float64{
+		"PLANX_CHAOS_ERROR_RATE":     &cfg.ErrorRate,
+		"PLANX_CHAOS_DROP_ACK_RATE":  &cfg.DropAckRate,
+		"PLANX_CHAOS_DUPLICATE_RATE": &cfg.DuplicateRate,
+	}
+
+	if v := getenv("PLANX_CHAOS_SEED"); v != "" {
+		seed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("chaos: PLANX_CHAOS_SEED: %w", err)
+		}
+		cfg.Seed = seed
+	}
+	for _, key := range slices.Sorted(maps.Keys(durations)) {
+		if v := getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return Config{}, fmt.Errorf("chaos: %s: invalid duration %q", key, v)
+			}
+			*durations[key] = d
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(rates)) {
+		if v := getenv(key); v != "" {
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil || r < 0 || r > 1 {
+				return Config{}, fmt.Errorf("chaos: %s: rate %q must be between 0 and 1", key, v)
+			}
+			*rates[key] = r
+		}
+	}
+	return cfg, nil
+}
+
+// faults holds the random state of one wrapped SPI and forwards the
+// optional lifecycle interfaces, which are never faulted.
+type faults struct {
+	cfg   Config
+	inner lifecycle
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+type lifecycle interface {
+	Init(ctx context.Context, config []byte) error
+	Close() error
+}
+
+func (f *faults) init(cfg Config, inner lifecycle) {
+	f.cfg, f.inner = cfg, inner
+	f.rng = rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))
+}
+
+func (f *faults) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < rate
+}
+
+// before runs ahead of every data call: it sleeps for the configured
+// latency and decides whether the call fails.
+func (f *faults) before(ctx context.Context, method string) error {
+	d := f.cfg.Latency
+	if f.cfg.Jitter > 0 {
+		f.mu.Lock()
+		d += time.Duration(f.rng.Int64N(int64(f.cfg.Jitter) + 1))
+		f.mu.Unlock()
+	}
+	if err := sleep(ctx, d); err != nil {
+		return err
+	}
+	if f.chance(f.cfg.ErrorRate) {
+		return sdk.TransientError(fmt.Errorf("%w in %s", ErrInjected, method))
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *faults) Init(ctx context.Context, config []byte) error {
+	return f.inner.Init(ctx, config)
+}
+
+func (f *faults) Close() error { return f.inner.Close() }
+
+func (f *faults) Flush(ctx context.Context) error {
+	if fl, ok := f.inner.(sdk.Flusher); ok {
+		return fl.Flush(ctx)
+	}
+	return errors.ErrUnsupported
+}
+
+func (f *faults) Drain(ctx context.Context) error {
+	if d, ok := f.inner.(sdk.Drainer); ok {
+		return d.Drain(ctx)
+	}
+	return errors.ErrUnsupported
+}
+
+func (f *faults) Checkpoint(ctx context.Context) ([]byte, error) {
+	if c, ok := f.inner.(sdk.Checkpointer); ok {
+		return c.Checkpoint(ctx)
+	}
+	return nil, errors.ErrUnsupported
+}
+
+func (f *faults) Restore(ctx context.Context, checkpoint []byte) error {
+	if c, ok := f.inner.(sdk.Checkpointer); ok {
+		return c.Restore(ctx, checkpoint)
+	}
+	return errors.ErrUnsupported
+}
+
+func (f *faults) Seek(ctx context.Context, position []byte) error {
+	if s, ok := f.inner.(sdk.Seeker); ok {
+		return s.Seek(ctx, position)
+	}
+	return errors.ErrUnsupported
+}
+
+func (f *faults) UpdateConfig(ctx context.Context, config []byte) error {
+	if u, ok := f.inner.(sdk.ConfigUpdater); ok {
+		return u.UpdateConfig(ctx, config)
+	}
+	return errors.ErrUnsupported
+}
+
+type source struct {
+	faults
+	src sdk.SourceSPI
+	// again is a batch to return a second time.
+	again *sdk.Batch
+}
+
+// Source injects latency, errors, duplicated batches and a delayed
+// io.EOF into spi.
+func Source(spi sdk.SourceSPI, cfg Config) sdk.SourceSPI {
+	s := &source{src: spi}
+	s.init(cfg, spi)
+	return s
+}
+
+func (s *source) ReadBatch(ctx context.Context) (*sdk.Batch, error) {
+	if err := s.before(ctx, "ReadBatch"); err != nil {
+		return nil, err
+	}
+	if b := s.again; b != nil {
+		s.again = nil
+		return b, nil
+	}
+
+	b, err := s.src.ReadBatch(ctx)
+	if errors.Is(err, io.EOF) {
+		if err := sleep(ctx, s.cfg.EOFDelay); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	if err == nil && s.chance(s.cfg.DuplicateRate) {
+		s.again = clone(b)
+	}
+	return b, err
+}
+
+// clone copies b so a processor changing the first delivery does not
+// change the duplicate.
+func clone(b *sdk.Batch) *sdk.Batch {
+	if b == nil {
+		return nil
+	}
+	out := &sdk.Batch{Records: make([]sdk.Record, len(b.Records)), Metadata: maps.Clone(b.Metadata)}
+	for i, r := range b.Records {
+		out.Records[i] = sdk.Record{Payload: slices.Clone(r.Payload), Metadata: maps.Clone(r.Metadata)}
+	}
+	return out
+}
+
+type sink struct {
+	faults
+	sink sdk.SinkSPI
+}
+
+// Sink injects latency, errors and dropped acks into spi.
+func Sink(spi sdk.SinkSPI, cfg Config) sdk.SinkSPI {
+	s := &sink{sink: spi}
+	s.init(cfg, spi)
+	return s
+}
+
+func (s *sink) WriteBatch(ctx context.Context, b *sdk.Batch) error {
+	if err := s.before(ctx, "WriteBatch"); err != nil {
+		return err
+	}
+	if err := s.sink.WriteBatch(ctx, b); err != nil {
+		return err
+	}
+	if s.chance(s.cfg.DropAckRate) {
+		return sdk.TransientError(fmt.Errorf("%w: ack dropped after WriteBatch", ErrInjected))
+	}
+	return nil
+}
+
+type processor struct {
+	faults
+	proc sdk.ProcessorSPI
+}
+
+// Processor injects latency and errors into spi.
+func Processor(spi sdk.ProcessorSPI, cfg Config) sdk.ProcessorSPI {
+	p := &processor{proc: spi}
+	p.init(cfg, spi)
+	return p
+}
+
+func (p *processor) Process(ctx context.Context, b *sdk.Batch) (*sdk.Batch, error) {
+	if err := p.before(ctx, "Process"); err != nil {
+		return nil, err
+	}
+	return p.proc.Process(ctx, b)
+}
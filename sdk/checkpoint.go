@@ -0,0 +1,400 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk/state"
+)
+
+// BarrierID returns the checkpoint b is the barrier of, if it is one.
+// SPIs never see barriers; they reach the engine, and tests driving a
+// source, among its batches.
+func BarrierID(b *Batch) (string, bool) { return runtime.BarrierID(b) }
+
+// retainedCheckpoints is how many completed checkpoints are kept per
+// state namespace; the oldest is deleted when another one completes.
+const retainedCheckpoints = 3
+
+// checkpoint is what the SDK stores when a session passes a barrier: the
+// SPI's Checkpoint blob, if it is a Checkpointer, and a snapshot of the
+// session state. For an incremental checkpoint, State holds only the
+// changes since the checkpoint named by Base.
+type checkpoint struct {
+	SPI   []byte `json:"spi,omitempty"`
+	State []byte `json:"state"`
+	Base  string `json:"base,omitempty"`
+}
+
+// checkpointNamespace holds the checkpoints of the state namespace ns.
+func checkpointNamespace(ns string) string {
+	return "checkpoints/" + ns
+}
+
+// checkpointIndex lists the checkpoints kept for a namespace, oldest
+// first. Checkpoints are numbered in the order they were taken;
+// Committed is the number of the last one committed.
+type checkpointIndex struct {
+	Entries   []indexEntry `json:"entries"`
+	Next      int64        `json:"next"`
+	Committed int64        `json:"committed"`
+}
+
+type indexEntry struct {
+	ID  string `json:"id"`
+	Seq int64  `json:"seq"`
+	// Base is set for incremental checkpoints, which depend on the
+	// entry before.
+	Base string `json:"base,omitempty"`
+}
+
+func (x *checkpointIndex) find(id string) int {
+	return slices.IndexFunc(x.Entries, func(e indexEntry) bool { return e.ID == id })
+}
+
+// Snapshot stores checkpoint id of the session, for a later session on
+// the same state namespace to be restored from with the
+// x-planx-restore-checkpoint metadata. Anything the SPI buffers is
+// flushed first, so the checkpoint covers every batch before the
+// barrier. The runtime never snapshots a session concurrently with its
+// other calls.
+func (w *spiWrapper) Snapshot(ctx context.Context, id string) (int, error) {
+	if err := w.Flush(ctx); !errors.Is(err, errors.ErrUnsupported) && err != nil {
+		return 0, err
+	}
+	var cp checkpoint
+	if c, ok := w.spi.(Checkpointer); ok {
+		blob, err := c.Checkpoint(w.ctx(ctx))
+		if err != nil {
+			return 0, err
+		}
+		cp.SPI = blob
+	}
+	if err := w.snapshotState(id, &cp); err != nil {
+		return 0, err
+	}
+	n, err := w.saveCheckpoint(id, cp)
+	switch {
+	case err != nil:
+		// The changes since base are lost with the checkpoint.
+		w.base = ""
+	case cp.Base != "":
+		w.base = id
+		w.deltas++
+	default:
+		w.base, w.deltas = id, 0
+	}
+	return n, err
+}
+
+// snapshotState stores the session state in cp: the changes since the
+// session's last checkpoint if it is the latest of the namespace and a
+// full one is not due, in full otherwise.
+func (w *spiWrapper) snapshotState(id string, cp *checkpoint) error {
+	if w.base != "" && w.base != id && w.deltas+1 < w.fullInterval {
+		_, idx, err := w.checkpointIndex()
+		if err != nil {
+			return err
+		}
+		if n := len(idx.Entries); n > 0 && idx.Entries[n-1].ID == w.base {
+			delta, err := w.tracker.Delta()
+			if err != nil {
+				return TransientError(err)
+			}
+			cp.State, cp.Base = delta, w.base
+			return nil
+		}
+	}
+	w.tracker.Reset()
+	snap, err := state.Snapshot(w.tracker)
+	if err != nil {
+		return TransientError(err)
+	}
+	cp.State = snap
+	return nil
+}
+
+// Commit commits every checkpoint up to id not yet committed, in order,
+// recording each as it is done so a restarted session does not commit
+// it again. A checkpoint the session never took is ignored.
+func (w *spiWrapper) Commit(ctx context.Context, id string) error {
+	s, idx, err := w.checkpointIndex()
+	if err != nil {
+		return err
+	}
+	at := idx.find(id)
+	if at < 0 {
+		return nil
+	}
+	c, _ := w.spi.(Committer)
+	for _, e := range idx.Entries[:at+1] {
+		if e.Seq <= idx.Committed {
+			continue
+		}
+		if c != nil {
+			if err := c.Commit(w.ctx(ctx), e.ID); err != nil {
+				return err
+			}
+		}
+		idx.Committed = e.Seq
+		if err := w.writeIndex(s, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *spiWrapper) checkpointStore() (state.Store, error) {
+	if w.checkpoints == nil {
+		ns := checkpointNamespace(w.namespace)
+		s, err := w.backend.Open(ns)
+		if err != nil {
+			return nil, TransientError(err)
+		}
+		if w.changelogged {
+			if err := w.rebuild(ns, s); err != nil {
+				s.Close()
+				return nil, err
+			}
+			s = state.Logged(s, ns, w.changelog)
+		}
+		w.checkpoints = s
+	}
+	return w.checkpoints, nil
+}
+
+func (w *spiWrapper) checkpointIndex() (state.Store, *checkpointIndex, error) {
+	s, err := w.checkpointStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := readIndex(s)
+	return s, idx, err
+}
+
+func readIndex(s state.Store) (*checkpointIndex, error) {
+	var idx checkpointIndex
+	data, ok, err := s.Get("index")
+	if err != nil {
+		return nil, TransientError(err)
+	}
+	if ok {
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, FatalError(fmt.Errorf("planx: checkpoint index: %w", err))
+		}
+	}
+	return &idx, nil
+}
+
+func (w *spiWrapper) writeIndex(s state.Store, idx *checkpointIndex) error {
+	return writeIndex(s, idx, w.exactlyOnce)
+}
+
+// writeIndex stores idx after deleting the checkpoints beyond
+// retainedCheckpoints that the retained ones do not build on. With
+// keepUncommitted, as in exactly-once mode, only those older than the
+// last commit are deleted.
+func writeIndex(s state.Store, idx *checkpointIndex, keepUncommitted bool) error {
+	for len(idx.Entries) > retainedCheckpoints {
+		oldest := idx.Entries[0]
+		if keepUncommitted && oldest.Seq > idx.Committed {
+			break
+		}
+		// The first retained checkpoint builds on those back to the
+		// last one stored in full, which must not be the oldest.
+		first := len(idx.Entries) - retainedCheckpoints
+		if !slices.ContainsFunc(idx.Entries[1:first+1], func(e indexEntry) bool { return e.Base == "" }) {
+			break
+		}
+		if err := s.Delete("checkpoint/" + oldest.ID); err != nil {
+			return TransientError(err)
+		}
+		idx.Entries = idx.Entries[1:]
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := s.Put("index", data); err != nil {
+		return TransientError(err)
+	}
+	return nil
+}
+
+func (w *spiWrapper) saveCheckpoint(id string, cp checkpoint) (int, error) {
+	s, err := w.checkpointStore()
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return 0, err
+	}
+	if err := putCheckpoint(s, id, cp.Base, data, w.exactlyOnce); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// putCheckpoint stores checkpoint id, incremental on base unless that is
+// empty, as the latest of s.
+func putCheckpoint(s state.Store, id, base string, data []byte, keepUncommitted bool) error {
+	idx, err := readIndex(s)
+	if err != nil {
+		return err
+	}
+	if err := s.Put("checkpoint/"+id, data); err != nil {
+		return TransientError(err)
+	}
+	if at := idx.find(id); at >= 0 {
+		idx.Entries = slices.Delete(idx.Entries, at, at+1)
+	}
+	idx.Next++
+	idx.Entries = append(idx.Entries, indexEntry{ID: id, Seq: idx.Next, Base: base})
+	return writeIndex(s, idx, keepUncommitted)
+}
+
+// rawCheckpoint returns checkpoint id as stored.
+func (w *spiWrapper) rawCheckpoint(id string) ([]byte, error) {
+	s, err := w.checkpointStore()
+	if err != nil {
+		return nil, err
+	}
+	data, ok, err := s.Get("checkpoint/" + id)
+	if err != nil {
+		return nil, TransientError(err)
+	}
+	if !ok {
+		return nil, ConfigError(fmt.Errorf("planx: checkpoint %q of state namespace %q not found", id, w.namespace))
+	}
+	return data, nil
+}
+
+// ExportCheckpoint returns checkpoint id for a savepoint, in full so it
+// can be imported on its own.
+func (w *spiWrapper) ExportCheckpoint(ctx context.Context, id string) (string, []byte, error) {
+	cp, err := w.loadCheckpoint(id)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := json.Marshal(cp)
+	return w.namespace, data, err
+}
+
+// importCheckpoint stores checkpoint id of namespace, exported from
+// another plugin process, in b. Checkpoints of the namespace that await
+// a commit are kept.
+func importCheckpoint(b state.Backend, namespace, id string, data []byte) error {
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return ConfigError(fmt.Errorf("planx: checkpoint %q: %w", id, err))
+	}
+	if cp.Base != "" {
+		return ConfigError(fmt.Errorf("planx: checkpoint %q is incremental", id))
+	}
+	s, err := b.Open(checkpointNamespace(namespace))
+	if err != nil {
+		return TransientError(err)
+	}
+	return errors.Join(putCheckpoint(s, id, "", data, true), s.Close())
+}
+
+// loadCheckpoint returns checkpoint id with the session state in full,
+// merged from the checkpoints it builds on if it is incremental.
+func (w *spiWrapper) loadCheckpoint(id string) (*checkpoint, error) {
+	cp, err := w.readCheckpoint(id)
+	if err != nil {
+		return nil, err
+	}
+	var deltas [][]byte
+	seen := map[string]bool{id: true}
+	full := cp
+	for full.Base != "" {
+		if seen[full.Base] {
+			return nil, FatalError(fmt.Errorf("planx: checkpoint %q builds on itself", full.Base))
+		}
+		seen[full.Base] = true
+		deltas = append(deltas, full.State)
+		if full, err = w.readCheckpoint(full.Base); err != nil {
+			return nil, err
+		}
+	}
+	if len(deltas) > 0 {
+		slices.Reverse(deltas)
+		snap, err := state.Merge(full.State, deltas...)
+		if err != nil {
+			return nil, FatalError(fmt.Errorf("planx: checkpoint %q: %w", id, err))
+		}
+		cp.State, cp.Base = snap, ""
+	}
+	return cp, nil
+}
+
+func (w *spiWrapper) readCheckpoint(id string) (*checkpoint, error) {
+	data, err := w.rawCheckpoint(id)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, FatalError(fmt.Errorf("planx: checkpoint %q: %w", id, err))
+	}
+	return &cp, nil
+}
+
+// restoreSPI hands the SPI its part of checkpoint id once it is
+// initialized, forgets the checkpoints taken after it, and commits it
+// unless that was done before the restart.
+func (w *spiWrapper) restoreSPI(ctx context.Context, id string, cp *checkpoint) error {
+	if cp == nil {
+		return nil
+	}
+	if cp.SPI != nil {
+		c, ok := w.spi.(Checkpointer)
+		if !ok {
+			return ConfigError(errors.New("planx: checkpoint has connector state but the connector is not a Checkpointer"))
+		}
+		if err := c.Restore(w.ctx(ctx), cp.SPI); err != nil {
+			return err
+		}
+	}
+
+	s, idx, err := w.checkpointIndex()
+	if err != nil {
+		return err
+	}
+	if at := idx.find(id); at >= 0 {
+		for _, later := range idx.Entries[at+1:] {
+			if err := s.Delete("checkpoint/" + later.ID); err != nil {
+				return TransientError(err)
+			}
+		}
+		idx.Entries = idx.Entries[:at+1]
+		idx.Committed = min(idx.Committed, idx.Entries[at].Seq)
+		if err := w.writeIndex(s, idx); err != nil {
+			return err
+		}
+	}
+	return w.Commit(ctx, id)
+}
+
+// checkExactlyOnce reports why the session cannot run exactly-once, if
+// it cannot: its checkpoints must survive it, the source must checkpoint
+// its position and the sink must commit per checkpoint.
+func (w *spiWrapper) checkExactlyOnce() error {
+	_, checkpoints := w.spi.(Checkpointer)
+	_, commits := w.spi.(Committer)
+	switch {
+	case w.ephemeral:
+		return ConfigError(errors.New("planx: exactly-once delivery requires a state namespace"))
+	case w.role == runtime.RoleSource && !checkpoints:
+		return ConfigError(errors.New("planx: exactly-once delivery requires a source that is a Checkpointer"))
+	case w.role == runtime.RoleSink && !(checkpoints && commits):
+		return ConfigError(errors.New("planx: exactly-once delivery requires a sink that is a Checkpointer and a Committer"))
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Command planx-batch prints batches packed by the SDK batch codec, for
+// debugging captured traffic and dead-lettered batches:
+//
+//	planx-batch [-format table|json] [-payload n] [-stats] [file ...]
+//
+// Each file holds one packed batch; with no files one batch is read
+// from stdin. Payloads are shown as text when they are valid UTF-8 and
+// as base64 otherwise, cut to the first n bytes.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "planx-batch:", err)
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	format  string
+	payload int
+	stats   bool
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("planx-batch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var o options
+	fs.StringVar(&o.format, "format", "table", "output format: table or json")
+	fs.IntVar(&o.payload, "payload", 64, "payload bytes to show per record, 0 for none, -1 for all")
+	fs.BoolVar(&o.stats, "stats", false, "print only batch statistics")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.format != "table" && o.format != "json" {
+		return fmt.Errorf("unknown format %q, want table or json", o.format)
+	}
+
+	codec := batch.NewCodec()
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	// Inputs are independent, so one bad file does not hide the rest.
+	var errs []error
+	for _, name := range inputs {
+		data, err := readInput(name, stdin)
+		if err == nil {
+			var b *batch.Batch
+			if b, err = codec.Unpack(data); err == nil {
+				err = o.print(stdout, describe(displayName(name), len(data), b, o))
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", displayName(name), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func displayName(name string) string {
+	if name == "-" {
+		return "stdin"
+	}
+	return name
+}
+
+// dump is the printed form of one batch.
+type dump struct {
+	Source   string            `json:"source"`
+	Stats    stats             `json:"stats"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Records  []record          `json:"records,omitempty"`
+}
+
+type stats struct {
+	Records      int `json:"records"`
+	PackedBytes  int `json:"packed_bytes"`
+	PayloadBytes int `json:"payload_bytes"`
+	MinPayload   int `json:"min_payload"`
+	MaxPayload   int `json:"max_payload"`
+	// MetadataKeys counts the records carrying each metadata key.
+	MetadataKeys map[string]int `json:"metadata_keys,omitempty"`
+}
+
+type record struct {
+	Index    int               `json:"index"`
+	Size     int               `json:"size"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Payload  string            `json:"payload,omitempty"`
+	// Encoding is "base64" for payloads that are not valid UTF-8.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func describe(source string, packed int, b *batch.Batch, o options) dump {
+	d := dump{
+		Source:   source,
+		Stats:    stats{Records: b.Len(), PackedBytes: packed},
+		Metadata: b.Metadata,
+	}
+	for i, r := range b.Records {
+		n := len(r.Payload)
+		d.Stats.PayloadBytes += n
+		if i == 0 || n < d.Stats.MinPayload {
+			d.Stats.MinPayload = n
+		}
+		d.Stats.MaxPayload = max(d.Stats.MaxPayload, n)
+		for k := range r.Metadata {
+			if d.Stats.MetadataKeys == nil {
+				d.Stats.MetadataKeys = map[string]int{}
+			}
+			d.Stats.MetadataKeys[k]++
+		}
+		if o.stats {
+			continue
+		}
+		rec := record{Index: i, Size: n, Metadata: r.Metadata}
+		rec.Payload, rec.Encoding = showPayload(r.Payload, o.payload)
+		d.Records = append(d.Records, rec)
+	}
+	return d
+}
+
+// showPayload returns up to limit bytes of p, or all of it when limit is
+// negative. Payloads are opaque, so nothing beyond the encoding is
+// inferred from them.
+func showPayload(p []byte, limit int) (string, string) {
+	if limit >= 0 && len(p) > limit {
+		p = p[:limit]
+	}
+	if len(p) == 0 {
+		return "", ""
+	}
+	if utf8.Valid(p) {
+		return string(p), ""
+	}
+	return base64.StdEncoding.EncodeToString(p), "base64"
+}
+
+func (o options) print(w io.Writer, d dump) error {
+	if o.format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	s := d.Stats
+	fmt.Fprintf(tw, "%s: %d records, %d packed bytes, %d payload bytes (min %d, max %d)\n",
+		d.Source, s.Records, s.PackedBytes, s.PayloadBytes, s.MinPayload, s.MaxPayload)
+	if len(d.Metadata) > 0 {
+		fmt.Fprintf(tw, "metadata: %s\n", formatMeta(d.Metadata))
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.MetadataKeys)) {
+		fmt.Fprintf(tw, "key %s\t%d records\n", k, s.MetadataKeys[k])
+	}
+	if len(d.Records) > 0 {
+		fmt.Fprintln(tw, "INDEX\tSIZE\tMETADATA\tPAYLOAD")
+		for _, r := range d.Records {
+			payload := r.Payload
+			if r.Encoding != "" {
+				payload = r.Encoding + ":" + payload
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%q\n", r.Index, r.Size, formatMeta(r.Metadata), payload)
+		}
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+func formatMeta(m map[string]string) string {
+	if len(m) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		parts = append(parts, k+"="+m[k])
+	}
+	return strings.Join(parts, " ")
+}
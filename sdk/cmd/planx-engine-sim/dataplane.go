@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/planx-lab/planx-sdk-go/sdk/shm"
+	"google.golang.org/grpc/metadata"
+)
+
+// plane carries the batches of a session: inline, or through the data
+// plane the plugin answered CreateSession with. The simulator speaks shm
+// only.
+type plane struct {
+	conn *shm.Conn
+}
+
+func dialPlane(header metadata.MD) (*plane, error) {
+	name := header.Get("x-planx-data-plane")
+	if len(name) == 0 {
+		return &plane{}, nil
+	}
+	if name[0] != shm.Name {
+		return nil, fmt.Errorf("plugin chose data plane %q, which the simulator cannot use", name[0])
+	}
+	var params string
+	if v := header.Get("x-planx-data-plane-params"); len(v) > 0 {
+		params = v[0]
+	}
+	c, err := shm.Dial(params)
+	if err != nil {
+		return nil, err
+	}
+	return &plane{conn: c}, nil
+}
+
+// send returns the payload carrying a packed batch to the plugin.
+func (p *plane) send(packed []byte) ([]byte, error) {
+	if p.conn == nil {
+		return packed, nil
+	}
+	return p.conn.Put(packed)
+}
+
+// recv returns the packed batch a payload from the plugin holds and the
+// func releasing it.
+func (p *plane) recv(payload []byte) ([]byte, func(), error) {
+	if p.conn == nil {
+		return payload, func() {}, nil
+	}
+	packed, err := p.conn.Get(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return packed, func() { p.conn.Release(payload) }, nil
+}
+
+func (p *plane) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
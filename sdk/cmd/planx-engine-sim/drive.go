@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/sdk/batchtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// report collects the timings of one simulated session.
+type report struct {
+	role   string
+	create time.Duration
+	close  time.Duration
+	// first is the time from opening the stream or sending the first
+	// batch to the first batch moved.
+	first   time.Duration
+	elapsed time.Duration
+
+	batches, records, bytes int
+	// latencies are per-call latencies for sinks and processors and the
+	// gaps between received batches for sources.
+	latencies []time.Duration
+}
+
+func (r *report) add(b *batch.Batch, packed int, latency time.Duration) {
+	if r.batches == 0 {
+		r.first = latency
+	}
+	r.batches++
+	r.records += b.Len()
+	r.bytes += packed
+	r.latencies = append(r.latencies, latency)
+}
+
+func (r *report) print(w io.Writer) {
+	fmt.Fprintf(w, "session   created in %s, closed in %s\n", round(r.create), round(r.close))
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "moved     %d batches, %d records, %d bytes in %s\n", r.batches, r.records, r.bytes, round(r.elapsed))
+	if r.batches == 0 || secs == 0 {
+		return
+	}
+	fmt.Fprintf(w, "rate      %.1f batches/s, %.0f records/s, %.0f bytes/s\n",
+		float64(r.batches)/secs, float64(r.records)/secs, float64(r.bytes)/secs)
+	fmt.Fprintf(w, "first     %s\n", round(r.first))
+
+	what := "call latency"
+	if r.role == "source" {
+		what = "batch interval"
+	}
+	l := slices.Clone(r.latencies)
+	slices.Sort(l)
+	fmt.Fprintf(w, "%-9s p50 %s, p90 %s, p99 %s, max %s\n", what,
+		round(pct(l, 0.50)), round(pct(l, 0.90)), round(pct(l, 0.99)), round(l[len(l)-1]))
+}
+
+func pct(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// done reports whether a stream error means the run is over rather than
+// failed: the source ended or -duration ran out.
+func done(ctx context.Context, err error) bool {
+	return errors.Is(err, io.EOF) || ctx.Err() != nil
+}
+
+// closeSession closes the session with its own timeout, so it runs even
+// after -duration has expired.
+func closeSession(p *plugin, o options, r *report, id string,
+	fn func(context.Context, *pb.SessionCloseRequest, ...grpc.CallOption) (*pb.Empty, error)) error {
+
+	ctx, cancel := p.call(context.Background(), o, id)
+	defer cancel()
+	start := time.Now()
+	_, err := fn(ctx, &pb.SessionCloseRequest{SessionId: id})
+	r.close = time.Since(start)
+	if err != nil {
+		return fmt.Errorf("CloseSession: %w", err)
+	}
+	return nil
+}
+
+func driveSource(ctx context.Context, p *plugin, o options) (r report, err error) {
+	r.role = o.role
+	c := pb.NewSourcePluginClient(p.conn)
+
+	cctx, cancel := p.call(ctx, o, "")
+	start := time.Now()
+	var header metadata.MD
+	resp, err := c.CreateSession(cctx, &pb.SessionCreateRequest{Config: o.config}, grpc.Header(&header))
+	cancel()
+	r.create = time.Since(start)
+	if err != nil {
+		return r, fmt.Errorf("CreateSession: %w", err)
+	}
+	id := resp.SessionId
+	defer func() {
+		err = errors.Join(err, closeSession(p, o, &r, id, c.CloseSession))
+	}()
+	pl, err := dialPlane(header)
+	if err != nil {
+		return r, err
+	}
+	defer pl.close()
+
+	sctx, stop := context.WithCancel(p.withMD(ctx, o, id))
+	defer stop()
+	stream, err := c.OpenStream(sctx, &pb.StreamOpenRequest{SessionId: id, InitialWindow: int32(o.window)})
+	if err != nil {
+		return r, fmt.Errorf("OpenStream: %w", err)
+	}
+
+	codec := batch.NewCodec()
+	start = time.Now()
+	last, pending := start, 0
+	defer func() { r.elapsed = time.Since(start) }()
+	for o.batches == 0 || r.batches < o.batches {
+		msg, err := stream.Recv()
+		if done(ctx, err) {
+			return r, nil
+		}
+		if err != nil {
+			return r, fmt.Errorf("stream: %w", err)
+		}
+		now := time.Now()
+		packed, release, err := pl.recv(msg.Payload)
+		if err != nil {
+			return r, fmt.Errorf("batch %d: %w", r.batches, err)
+		}
+		b, err := codec.Unpack(packed)
+		release()
+		if err != nil {
+			return r, fmt.Errorf("unpacking batch %d: %w", r.batches, err)
+		}
+		r.add(b, len(packed), now.Sub(last))
+		last = now
+
+		if pending++; pending < o.ackEvery {
+			continue
+		}
+		if o.ackDelay > 0 {
+			select {
+			case <-time.After(o.ackDelay):
+			case <-ctx.Done():
+				return r, nil
+			}
+		}
+		actx, cancel := p.call(ctx, o, id)
+		_, err = c.Ack(actx, &pb.AckRequest{SessionId: id, NewWindow: int32(pending)})
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			return r, fmt.Errorf("Ack: %w", err)
+		}
+		pending = 0
+	}
+	return r, nil
+}
+
+// generator feeds sinks and processors batches of the configured shape.
+func generator(o options) *batchtest.Generator {
+	return batchtest.NewGenerator(batchtest.Config{
+		Seed:        o.seed,
+		Records:     batchtest.Range{Min: o.records},
+		PayloadSize: batchtest.Range{Min: o.payloadSize},
+	})
+}
+
+// feed sends generated batches through send until -batches or
+// -duration is reached, timing each call.
+func feed(ctx context.Context, o options, r *report, pl *plane, send func(context.Context, *pb.Batch) error) error {
+	codec := batch.NewCodec()
+	gen := generator(o)
+	start := time.Now()
+	defer func() { r.elapsed = time.Since(start) }()
+	for (o.batches == 0 || r.batches < o.batches) && ctx.Err() == nil {
+		b := gen.Next()
+		packed, err := codec.Pack(b)
+		if err != nil {
+			return err
+		}
+		t := time.Now()
+		payload, err := pl.send(packed)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", r.batches, err)
+		}
+		if err := send(ctx, &pb.Batch{Payload: payload}); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("batch %d: %w", r.batches, err)
+		}
+		r.add(b, len(packed), time.Since(t))
+	}
+	return nil
+}
+
+func driveSink(ctx context.Context, p *plugin, o options) (r report, err error) {
+	r.role = o.role
+	c := pb.NewSinkPluginClient(p.conn)
+
+	cctx, cancel := p.call(ctx, o, "")
+	start := time.Now()
+	var header metadata.MD
+	resp, err := c.CreateSession(cctx, &pb.SessionCreateRequest{Config: o.config}, grpc.Header(&header))
+	cancel()
+	r.create = time.Since(start)
+	if err != nil {
+		return r, fmt.Errorf("CreateSession: %w", err)
+	}
+	id := resp.SessionId
+	defer func() {
+		err = errors.Join(err, closeSession(p, o, &r, id, c.CloseSession))
+	}()
+	pl, err := dialPlane(header)
+	if err != nil {
+		return r, err
+	}
+	defer pl.close()
+
+	return r, feed(ctx, o, &r, pl, func(ctx context.Context, b *pb.Batch) error {
+		wctx, cancel := p.call(ctx, o, id)
+		defer cancel()
+		_, err := c.WriteBatch(wctx, b)
+		return err
+	})
+}
+
+func driveProcessor(ctx context.Context, p *plugin, o options) (r report, err error) {
+	r.role = o.role
+	c := pb.NewProcessorPluginClient(p.conn)
+
+	cctx, cancel := p.call(ctx, o, "")
+	start := time.Now()
+	var header metadata.MD
+	resp, err := c.CreateSession(cctx, &pb.SessionCreateRequest{Config: o.config}, grpc.Header(&header))
+	cancel()
+	r.create = time.Since(start)
+	if err != nil {
+		return r, fmt.Errorf("CreateSession: %w", err)
+	}
+	id := resp.SessionId
+	defer func() {
+		err = errors.Join(err, closeSession(p, o, &r, id, c.CloseSession))
+	}()
+	pl, err := dialPlane(header)
+	if err != nil {
+		return r, err
+	}
+	defer pl.close()
+
+	return r, feed(ctx, o, &r, pl, func(ctx context.Context, b *pb.Batch) error {
+		pctx, cancel := p.call(ctx, o, id)
+		defer cancel()
+		out, err := c.Process(pctx, b)
+		if err != nil {
+			return err
+		}
+		_, release, err := pl.recv(out.Payload)
+		if err != nil {
+			return err
+		}
+		release()
+		return nil
+	})
+}
@@ -0,0 +1,149 @@
+// Command planx-engine-sim stands in for the engine when developing a
+// connector. It launches a plugin binary, reads its handshake, opens a
+// session and drives it with engine-like window and ack behavior, then
+// prints timings:
+//
+//	planx-engine-sim -role source -window 8 -ack-every 4 -batches 1000 -- ./my-plugin
+//	planx-engine-sim -role sink -config @sink.json -records 500 -- ./my-plugin
+//
+// With -data-plane shm, batches move through the plugin's shared-memory
+// data plane, if it has one (see package shm).
+//
+// Sinks and processors are fed batches from a seeded generator. Extra
+// runtime settings reach the plugin through PLANX_* variables in the
+// simulator's own environment, the same way the engine passes them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+type options struct {
+	role      string
+	connector string
+	config    []byte
+	tenant    string
+	dataPlane string
+
+	window   int
+	ackEvery int
+	ackDelay time.Duration
+
+	batches     int
+	records     int
+	payloadSize int
+	seed        uint64
+
+	duration time.Duration
+	timeout  time.Duration
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "planx-engine-sim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("planx-engine-sim", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: planx-engine-sim [flags] -- plugin [args...]")
+		fs.PrintDefaults()
+	}
+
+	var o options
+	var config string
+	fs.StringVar(&o.role, "role", "source", "connector role: source, processor or sink")
+	fs.StringVar(&o.connector, "connector", "", "connector name, if the plugin serves several for the role")
+	fs.StringVar(&config, "config", "{}", "connector config JSON, or @file to read it from a file")
+	fs.StringVar(&o.tenant, "tenant", "", "tenant ID sent with the session")
+	fs.StringVar(&o.dataPlane, "data-plane", "", "offer the plugin a data plane for batches: shm; they go inline if it has none")
+	fs.IntVar(&o.window, "window", 1, "initial source window in batches")
+	fs.IntVar(&o.ackEvery, "ack-every", 1, "ack source batches in groups of n")
+	fs.DurationVar(&o.ackDelay, "ack-delay", 0, "delay before each source ack, as if the engine were busy")
+	fs.IntVar(&o.batches, "batches", 100, "batches to move; 0 means until -duration or the source ends")
+	fs.IntVar(&o.records, "records", 100, "records per generated batch for sinks and processors")
+	fs.IntVar(&o.payloadSize, "payload-size", 256, "payload bytes per generated record")
+	fs.Uint64Var(&o.seed, "seed", 1, "seed of the batch generator")
+	fs.DurationVar(&o.duration, "duration", 0, "stop moving batches after this long; 0 means no limit")
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "time allowed for the plugin handshake and each call")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no plugin command given")
+	}
+	if o.window < 1 || o.ackEvery < 1 {
+		return errors.New("-window and -ack-every must be at least 1")
+	}
+	if o.role == "source" && o.ackEvery > o.window {
+		// The source would stall waiting for credit the simulator only
+		// grants once it has received -ack-every batches.
+		return fmt.Errorf("-ack-every %d exceeds -window %d", o.ackEvery, o.window)
+	}
+	if o.batches == 0 && o.duration == 0 && o.role != "source" {
+		return errors.New("-batches 0 needs -duration for sinks and processors")
+	}
+
+	drive, ok := drivers[o.role]
+	if !ok {
+		return fmt.Errorf("unknown role %q, want source, processor or sink", o.role)
+	}
+
+	var err error
+	if o.config, err = readConfig(config); err != nil {
+		return err
+	}
+
+	p, err := launch(ctx, fs.Args(), o.timeout, stderr)
+	if err != nil {
+		return err
+	}
+	defer p.stop()
+	fmt.Fprintf(stdout, "plugin %s %s at %s (protocol %s, handshake %s)\n",
+		orUnknown(p.handshake.Plugin), orUnknown(p.handshake.Version),
+		p.handshake.Address, p.handshake.Protocol, p.started.Round(time.Millisecond))
+
+	if o.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.duration)
+		defer cancel()
+	}
+
+	r, err := drive(ctx, p, o)
+	r.print(stdout)
+	return err
+}
+
+var drivers = map[string]func(context.Context, *plugin, options) (report, error){
+	"source":    driveSource,
+	"sink":      driveSink,
+	"processor": driveProcessor,
+}
+
+func readConfig(v string) ([]byte, error) {
+	if name, ok := strings.CutPrefix(v, "@"); ok {
+		return os.ReadFile(name)
+	}
+	return []byte(v), nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "(unnamed)"
+	}
+	return s
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// engineID identifies the simulator to the plugin in x-planx-engine-id.
+const engineID = "planx-engine-sim"
+
+// plugin is a launched plugin process and a connection to it.
+type plugin struct {
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	dir       string
+	conn      *grpc.ClientConn
+	handshake runtime.Handshake
+	// started is the time from launch to the handshake.
+	started time.Duration
+	timeout time.Duration
+}
+
+// launch starts argv with the environment the engine would give it and
+// waits for the handshake on the first line of its stdout. The rest of
+// its output is copied to stderr.
+func launch(ctx context.Context, argv []string, timeout time.Duration, stderr io.Writer) (*plugin, error) {
+	dir, err := os.MkdirTemp("", "planx-engine-sim")
+	if err != nil {
+		return nil, err
+	}
+	p := &plugin{dir: dir, exited: make(chan struct{}), timeout: timeout}
+
+	// A fresh secret per launch lets the handshake be checked the way
+	// the engine does.
+	secret := rand.Text()
+	p.cmd = exec.Command(argv[0], argv[1:]...)
+	p.cmd.Env = append(os.Environ(),
+		"PLANX_HANDSHAKE_FILE="+filepath.Join(dir, "planx.handshake"),
+		"PLANX_HANDSHAKE_SECRET="+secret,
+		"PLANX_PROTOCOLS="+runtime.ProtocolV4,
+	)
+	p.cmd.Stderr = stderr
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := p.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	go func() {
+		p.cmd.Wait()
+		close(p.exited)
+	}()
+
+	lines := make(chan []byte, 1)
+	go func() {
+		r := bufio.NewReader(stdout)
+		line, _ := r.ReadBytes('\n')
+		lines <- line
+		io.Copy(stderr, r)
+	}()
+
+	var line []byte
+	select {
+	case line = <-lines:
+	case <-p.exited:
+		p.stop()
+		return nil, fmt.Errorf("plugin exited before its handshake: %v", p.cmd.ProcessState)
+	case <-time.After(timeout):
+		p.stop()
+		return nil, fmt.Errorf("no handshake from plugin within %s", timeout)
+	case <-ctx.Done():
+		p.stop()
+		return nil, ctx.Err()
+	}
+	p.started = time.Since(start)
+
+	if len(line) == 0 {
+		p.stop()
+		return nil, errors.New("plugin closed stdout before its handshake")
+	}
+	if err := json.Unmarshal(line, &p.handshake); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("bad handshake %q: %w", line, err)
+	}
+	if err := runtime.VerifyHandshake(p.handshake, []byte(secret)); err != nil {
+		p.stop()
+		return nil, err
+	}
+	if p.handshake.Protocol != runtime.ProtocolV4 {
+		p.stop()
+		return nil, fmt.Errorf("plugin speaks protocol %q, want %q", p.handshake.Protocol, runtime.ProtocolV4)
+	}
+
+	p.conn, err = grpc.NewClient(p.handshake.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		p.stop()
+		return nil, err
+	}
+	return p, nil
+}
+
+// stop ends the plugin process, giving it a moment to exit on SIGTERM
+// before killing it.
+func (p *plugin) stop() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.exited:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	os.RemoveAll(p.dir)
+}
+
+// call returns a context for one RPC, bounded by the call timeout and
+// carrying the metadata the engine sends.
+func (p *plugin) call(ctx context.Context, o options, sessionID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	return p.withMD(ctx, o, sessionID), cancel
+}
+
+func (p *plugin) withMD(ctx context.Context, o options, sessionID string) context.Context {
+	md := metadata.Pairs("x-planx-engine-id", engineID)
+	if o.connector != "" {
+		md.Set("x-planx-connector", o.connector)
+	}
+	if o.tenant != "" {
+		md.Set("x-planx-tenant-id", o.tenant)
+	}
+	if sessionID != "" {
+		md.Set("x-planx-session-id", sessionID)
+	} else if o.dataPlane != "" {
+		md.Set("x-planx-data-plane", o.dataPlane)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
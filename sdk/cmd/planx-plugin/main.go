@@ -0,0 +1,57 @@
+// Command planx-plugin creates new connector projects wired to the SDK:
+//
+//	planx-plugin new [-module path] [-dir dir] source|processor|sink <name>
+//
+// The generated module has a main package serving the connector, a
+// config struct decoded in Init, an SPI stub, a plugintest-based test
+// and a Dockerfile. Run go mod tidy in it to pull in the SDK.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: planx-plugin new [-module path] [-dir dir] source|processor|sink <name>`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "planx-plugin:", err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] != "new" {
+		return errors.New(usage)
+	}
+
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	module := fs.String("module", "", "module path of the new project (default github.com/example/<name>)")
+	dir := fs.String("dir", "", "directory to create (default ./<name>)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New(usage)
+	}
+
+	p, err := newProject(fs.Arg(0), fs.Arg(1), *module)
+	if err != nil {
+		return err
+	}
+	if *dir == "" {
+		*dir = p.Name
+	}
+	if err := p.generate(*dir); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "created %s %q in %s\n\nnext:\n\tcd %s && go mod tidy && go test ./...\n",
+		p.Role, p.Name, *dir, *dir)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+var roles = map[string]string{
+	"source":    "Source",
+	"processor": "Processor",
+	"sink":      "Sink",
+}
+
+// validName keeps connector names usable as directory, module and
+// image names.
+var validName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// project is the data the templates are rendered with.
+type project struct {
+	Role   string // source, processor or sink
+	Type   string // Source, Processor or Sink
+	Name   string
+	Module string
+}
+
+func newProject(role, name, module string) (*project, error) {
+	typ, ok := roles[role]
+	if !ok {
+		return nil, fmt.Errorf("unknown connector role %q, want source, processor or sink", role)
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid connector name %q: use lower case letters, digits and dashes", name)
+	}
+	if module == "" {
+		module = "github.com/example/" + name
+	}
+	return &project{Role: role, Type: typ, Name: name, Module: module}, nil
+}
+
+// files maps generated file names to their templates.
+func (p *project) files() map[string]string {
+	return map[string]string{
+		"go.mod":            "go.mod.tmpl",
+		"main.go":           "main.go.tmpl",
+		p.Role + ".go":      p.Role + ".go.tmpl",
+		p.Role + "_test.go": p.Role + "_test.go.tmpl",
+		"Dockerfile":        "Dockerfile.tmpl",
+	}
+}
+
+// generate writes the project to dir, which must not exist yet so that
+// nothing is overwritten.
+func (p *project) generate(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+
+	rendered := map[string][]byte{}
+	for name, tmpl := range p.files() {
+		data, err := p.render(tmpl)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, ".go") {
+			if data, err = format.Source(data); err != nil {
+				return fmt.Errorf("formatting %s: %w", name, err)
+			}
+		}
+		rendered[name] = data
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, data := range rendered {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *project) render(name string) ([]byte, error) {
+	t, err := template.ParseFS(templates, "templates/"+name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("rendering %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,26 @@
+package sdk
+
+import (
+	"os"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+)
+
+// RuntimeConfig holds the settings the SDK runtime itself uses, as
+// opposed to the connector config passed to SPI.Init.
+type RuntimeConfig = runtime.Config
+
+// ResolveRuntimeConfig computes the runtime config the Serve functions
+// use. Sources are applied in increasing order of precedence:
+//
+//  1. built-in defaults
+//  2. the JSON file named by --planx-config-file or PLANX_CONFIG_FILE
+//  3. environment variables, e.g. PLANX_LISTEN_ADDRESS
+//  4. command line flags, e.g. --planx-listen-address=127.0.0.1:7000
+//  5. the JSON object provided by the engine in PLANX_ENGINE_CONFIG
+//
+// Keys are the JSON field names of RuntimeConfig. Unrelated flags are
+// ignored so plugins can keep their own flag parsing.
+func ResolveRuntimeConfig() (RuntimeConfig, error) {
+	return runtime.ResolveConfig(os.Args[1:], os.Getenv)
+}
@@ -0,0 +1,19 @@
+package sdk
+
+import "github.com/planx-lab/planx-sdk-go/internal/runtime"
+
+// DataPlane moves the packed batches of a session outside the gRPC
+// streams, for transports that carry large batches more cheaply, e.g. an
+// Arrow Flight or shared-memory plane. The engine offers the planes it
+// can use when it creates a session, and the first one registered with
+// WithDataPlane is opened for it; the session's Batch messages then only
+// carry references, while acks, credit and checkpoints stay on gRPC.
+// Sessions whose engine offers none keep their batches inline.
+//
+// A data plane sees packed batches as opaque bytes; connectors are not
+// aware of it.
+type DataPlane = runtime.DataPlane
+
+// DataPlaneDiscarder is implemented by data planes that take back the
+// batches whose references a failed send never delivered to the engine.
+type DataPlaneDiscarder = runtime.Discarder
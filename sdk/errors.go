@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+)
+
+// ErrorCategory classifies a failure for the engine. An SPI error
+// wrapped with a category is returned to the engine with a matching gRPC
+// code and counted per session in planx_errors_total:
+//
+//	ErrUserConfig     InvalidArgument  bad or incomplete session config
+//	ErrTransient      Unavailable      retry may succeed (timeouts, throttling)
+//	ErrFatal          Internal         the plugin or its host is broken
+//	ErrDataFormat     DataLoss         a batch or record cannot be decoded
+//
+// Unwrapped errors keep their gRPC code, if they have one, and are
+// otherwise reported as Unknown.
+type ErrorCategory = runtime.ErrorCategory
+
+const (
+	ErrUserConfig    = runtime.ErrUserConfig
+	ErrTransient     = runtime.ErrTransient
+	ErrFatal         = runtime.ErrFatal
+	ErrDataFormat    = runtime.ErrDataFormat
+	ErrUncategorized = runtime.ErrUncategorized
+)
+
+// ConfigError marks err as caused by the session configuration.
+func ConfigError(err error) error { return runtime.CategorizeError(ErrUserConfig, err) }
+
+// TransientError marks err as retryable.
+func TransientError(err error) error { return runtime.CategorizeError(ErrTransient, err) }
+
+// FatalError marks err as a non-retryable system failure.
+func FatalError(err error) error { return runtime.CategorizeError(ErrFatal, err) }
+
+// DataFormatError marks err as caused by malformed input data.
+func DataFormatError(err error) error { return runtime.CategorizeError(ErrDataFormat, err) }
+
+// ErrorCategoryOf reports the category the SDK assigns to err.
+func ErrorCategoryOf(err error) ErrorCategory { return runtime.ErrorCategoryOf(err) }
+
+// ErrorDomain is the google.rpc.ErrorInfo domain the SDK reports
+// failures under. Engines read the category, SPI method, error string
+// and record index from the ErrorInfo metadata, and any retry hint from
+// a google.rpc.RetryInfo detail.
+const ErrorDomain = runtime.ErrorDomain
+
+// RetryAfter suggests that the engine retry no sooner than d.
+func RetryAfter(err error, d time.Duration) error { return runtime.WithRetryAfter(err, d) }
+
+// RecordError reports that the record at index in the current batch
+// caused err.
+func RecordError(err error, index int) error { return runtime.WithRecordIndex(err, index) }
@@ -0,0 +1,41 @@
+package sdk
+
+import (
+	"context"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+)
+
+// KeyProvider encrypts and decrypts secrets on behalf of the SDK, for
+// example through a KMS, Vault transit or a local key. Decrypt must
+// accept every key ID Encrypt has returned and not yet retired.
+//
+// With a KeyProvider set, a connector config may be a single encrypted
+// value, or a JSON object whose string fields hold encrypted values; the
+// SDK decrypts them before SPI.Init, so the SPI only sees plaintext.
+// Encrypted values have the form "enc:v1:<key id>:<base64>" and are
+// produced by EncryptConfigValue.
+//
+// The key provider also encrypts record data the SDK writes to disk,
+// such as tap_target "file:" output, one value per line; read it back
+// with DecryptValue.
+type KeyProvider = runtime.KeyProvider
+
+// NewLocalKeyProvider returns a KeyProvider using AES-256-GCM keys held
+// in memory, by key ID. It encrypts with keys[current] and decrypts
+// with any of keys. Each key must be 32 bytes.
+func NewLocalKeyProvider(current string, keys map[string][]byte) (KeyProvider, error) {
+	return runtime.NewLocalKeyProvider(current, keys)
+}
+
+// EncryptConfigValue encrypts plaintext with kp into a value the SDK
+// decrypts when it appears as a connector config or config field.
+func EncryptConfigValue(ctx context.Context, kp KeyProvider, plaintext []byte) (string, error) {
+	return runtime.EncryptValue(ctx, kp, plaintext)
+}
+
+// DecryptValue decrypts a value produced by EncryptConfigValue or a line
+// of an encrypted tap file.
+func DecryptValue(ctx context.Context, kp KeyProvider, v string) ([]byte, error) {
+	return runtime.DecryptValue(ctx, kp, v)
+}
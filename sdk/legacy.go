@@ -0,0 +1,88 @@
+package sdk
+
+import (
+	"context"
+	"sync"
+)
+
+// The Legacy* interfaces are the SPI shapes of earlier SDK releases,
+// whose data methods took no ctx. Wrap existing implementations with the
+// Adapt* functions to serve them over the current protocol unchanged.
+
+// Deprecated: implement SourceSPI.
+type LegacySourceSPI interface {
+	Init(ctx context.Context, config []byte) error
+	ReadBatch() (*Batch, error)
+	Close() error
+}
+
+// Deprecated: implement SinkSPI.
+type LegacySinkSPI interface {
+	Init(ctx context.Context, config []byte) error
+	WriteBatch(batch *Batch) error
+	Close() error
+}
+
+// Deprecated: implement ProcessorSPI.
+type LegacyProcessorSPI interface {
+	Init(ctx context.Context, config []byte) error
+	Process(batch *Batch) (*Batch, error)
+	Close() error
+}
+
+func AdaptLegacySource(spi LegacySourceSPI) SourceSPI {
+	return legacySource{spi}
+}
+
+func AdaptLegacySink(spi LegacySinkSPI) SinkSPI {
+	return legacySink{spi}
+}
+
+func AdaptLegacyProcessor(spi LegacyProcessorSPI) ProcessorSPI {
+	return legacyProcessor{spi}
+}
+
+var legacyWarned sync.Map
+
+func warnLegacy(ctx context.Context, role string) {
+	if _, loaded := legacyWarned.LoadOrStore(role, true); loaded {
+		return
+	}
+	if sc, ok := SessionFromContext(ctx); ok {
+		sc.Logger.Warn("planx: serving a legacy SPI through an adapter; migrate to the ctx-aware SPI",
+			"role", role)
+	}
+}
+
+type legacySource struct{ LegacySourceSPI }
+
+func (l legacySource) Init(ctx context.Context, config []byte) error {
+	warnLegacy(ctx, "source")
+	return l.LegacySourceSPI.Init(ctx, config)
+}
+
+func (l legacySource) ReadBatch(context.Context) (*Batch, error) {
+	return l.LegacySourceSPI.ReadBatch()
+}
+
+type legacySink struct{ LegacySinkSPI }
+
+func (l legacySink) Init(ctx context.Context, config []byte) error {
+	warnLegacy(ctx, "sink")
+	return l.LegacySinkSPI.Init(ctx, config)
+}
+
+func (l legacySink) WriteBatch(_ context.Context, batch *Batch) error {
+	return l.LegacySinkSPI.WriteBatch(batch)
+}
+
+type legacyProcessor struct{ LegacyProcessorSPI }
+
+func (l legacyProcessor) Init(ctx context.Context, config []byte) error {
+	warnLegacy(ctx, "processor")
+	return l.LegacyProcessorSPI.Init(ctx, config)
+}
+
+func (l legacyProcessor) Process(_ context.Context, batch *Batch) (*Batch, error) {
+	return l.LegacyProcessorSPI.Process(batch)
+}
@@ -0,0 +1,73 @@
+package sdk
+
+import "context"
+
+// The interfaces below are optional. A Source, Sink or Processor SPI may
+// implement any of them; the SDK detects them at runtime and calls them
+// at the appropriate point of the session lifecycle.
+
+// Flusher is implemented by SPIs that buffer output. Flush is called
+// before Close, whenever the engine requests a flush and at the end of
+// a bounded stream, once the batches before it were processed or
+// written, e.g. for a sink to finalize its files or transaction.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Drainer is implemented by SPIs that need to finish in-flight work
+// before shutting down. Drain is called before Flush and Close.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// Checkpointer is implemented by SPIs whose progress can be snapshotted
+// into an opaque blob and later restored. When a session passes a
+// checkpoint barrier the SDK stores the blob with a snapshot of the
+// session's state, and a session created with x-planx-restore-checkpoint
+// gets the state back before Init and the blob through Restore after it.
+type Checkpointer interface {
+	Checkpoint(ctx context.Context) ([]byte, error)
+	Restore(ctx context.Context, checkpoint []byte) error
+}
+
+// Committer is implemented by SPIs with effects that must wait until a
+// checkpoint is complete across the pipeline: a source acknowledging
+// offsets upstream, or a sink committing the transaction it prepared in
+// Checkpoint. Commit is called in checkpoint order for every checkpoint
+// the SPI took, after Restore for those left uncommitted when the last
+// session ended. A commit the SDK had no time to record is repeated, so
+// Commit must be idempotent. Restore should abort transactions prepared
+// after the checkpoint it is given.
+type Committer interface {
+	Commit(ctx context.Context, id string) error
+}
+
+// CheckpointFailureHandler is implemented by SPIs that act on failed
+// checkpoints: to alert, or to fall back to at-least-once, e.g. a sink
+// publishing without waiting for commits, once Consecutive grows. Its
+// OnCheckpointFailed is called after each failure, before the engine is
+// told of it.
+type CheckpointFailureHandler interface {
+	OnCheckpointFailed(ctx context.Context, f CheckpointFailure)
+}
+
+// CheckpointFailure describes a failed checkpoint of the session.
+type CheckpointFailure struct {
+	CheckpointID string
+	Err          error
+	// Consecutive is the number of checkpoints in a row that failed,
+	// this one included.
+	Consecutive int
+}
+
+// Seeker is implemented by sources that can reposition to an opaque,
+// source-defined position.
+type Seeker interface {
+	Seek(ctx context.Context, position []byte) error
+}
+
+// ConfigUpdater is implemented by SPIs that accept a new config without
+// recreating the session.
+type ConfigUpdater interface {
+	UpdateConfig(ctx context.Context, config []byte) error
+}
@@ -0,0 +1,71 @@
+package sdk
+
+import (
+	"log/slog"
+
+	"github.com/planx-lab/planx-sdk-go/internal/logging"
+)
+
+// Logger is the structured logger used by the SDK and handed to plugins.
+// Key/value pairs follow the log/slog convention. Inject an
+// implementation with Plugin.WithLogger; the default writes through
+// slog.Default().
+//
+// zap and zerolog users can wrap their logger in a slog handler (zapslog,
+// slog-zerolog) and pass it to NewSlogLogger, or implement Logger directly.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+	With(kv ...any) Logger
+}
+
+// NewSlogLogger adapts a *slog.Logger to Logger.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+// NewNopLogger returns a Logger that discards everything.
+func NewNopLogger() Logger {
+	return NewSlogLogger(slog.New(slog.DiscardHandler))
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func defaultLogger() Logger {
+	return slogLogger{l: slog.Default()}
+}
+
+func (s slogLogger) Debug(msg string, kv ...any) { s.l.Debug(msg, kv...) }
+func (s slogLogger) Info(msg string, kv ...any)  { s.l.Info(msg, kv...) }
+func (s slogLogger) Warn(msg string, kv ...any)  { s.l.Warn(msg, kv...) }
+func (s slogLogger) Error(msg string, kv ...any) { s.l.Error(msg, kv...) }
+func (s slogLogger) With(kv ...any) Logger       { return slogLogger{l: s.l.With(kv...)} }
+
+// sessionLogger applies a session's sampling and redaction settings
+// (log_sample_* and log_redact_keys) to the plugin logger.
+type sessionLogger struct {
+	logging.Logger
+	base   Logger
+	filter *logging.Filter
+}
+
+func newSessionLogger(base Logger, f *logging.Filter) Logger {
+	if f == nil {
+		return base
+	}
+	return sessionLogger{Logger: logging.Wrap(base, f), base: base, filter: f}
+}
+
+func (s sessionLogger) With(kv ...any) Logger {
+	return newSessionLogger(s.base.With(s.filter.Redact(kv)...), s.filter)
+}
+
+// RedactionFunc rewrites a value before the SDK logs it, e.g. to mask
+// PII in metadata. It is called for every key/value pair and every entry
+// of map values, after log_redact_keys, and must be safe for concurrent
+// use. Raw payload bytes are never logged regardless.
+type RedactionFunc func(key string, value any) any
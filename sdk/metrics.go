@@ -0,0 +1,16 @@
+package sdk
+
+import "github.com/planx-lab/planx-sdk-go/internal/session"
+
+// Metrics records plugin-defined measurements for a session in the
+// plugin's metrics backend, labeled with the session and its tenant: Add
+// adds to a counter, Set sets a gauge and Observe records a value in a
+// histogram. Names are prefixed, e.g. rows_skipped is exported as
+// planx_plugin_rows_skipped by Prometheus; a counter is never decreased.
+type Metrics = session.Metrics
+
+type nopMetrics struct{}
+
+func (nopMetrics) Add(string, float64)     {}
+func (nopMetrics) Set(string, float64)     {}
+func (nopMetrics) Observe(string, float64) {}
@@ -0,0 +1,291 @@
+// Package pipeline runs a source, any number of processors and a sink
+// together in one process, so a pipeline can be run and debugged on a
+// laptop without the engine:
+//
+//	res, err := pipeline.New(newReader, readerConfig).
+//		Process(newMapper, nil).
+//		Sink(newWriter, writerConfig).
+//		Run(ctx)
+//
+// Connectors are driven through the same SDK runtime a served plugin
+// uses: sessions, flow control, batch encoding and panic recovery all
+// apply. The run ends when the source returns io.EOF from ReadBatch,
+// when a connector fails, or when ctx is done.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/bridge"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultWindow is the number of batches the source may read ahead of
+// the sink.
+const DefaultWindow = 4
+
+const (
+	sourceName = "source"
+	sinkName   = "sink"
+)
+
+// Pipeline describes the connectors to run and their configs.
+type Pipeline struct {
+	plugin     *sdk.Plugin
+	sourceCfg  []byte
+	processors [][]byte
+	sinkCfg    []byte
+	hasSink    bool
+	window     int
+	cfg        sdk.RuntimeConfig
+}
+
+// Result is the traffic of a run.
+type Result struct {
+	// Read is what the source produced and Written what reached the sink.
+	Read    sdk.TrafficStats
+	Written sdk.TrafficStats
+	Elapsed time.Duration
+}
+
+// New starts a pipeline reading from the source built by factory.
+func New(factory func() sdk.SourceSPI, config []byte) *Pipeline {
+	cfg := runtime.DefaultConfig()
+	cfg.MetricsBackend = runtime.MetricsNone
+	return &Pipeline{
+		plugin:    sdk.NewPlugin("pipeline", "").AddSource(sourceName, factory),
+		sourceCfg: config,
+		window:    DefaultWindow,
+		cfg:       cfg,
+	}
+}
+
+// Process appends a processor. Batches pass through processors in the
+// order they were added.
+func (p *Pipeline) Process(factory func() sdk.ProcessorSPI, config []byte) *Pipeline {
+	p.plugin.AddProcessor(processorName(len(p.processors)), factory)
+	p.processors = append(p.processors, config)
+	return p
+}
+
+// Sink sets the sink batches are written to.
+func (p *Pipeline) Sink(factory func() sdk.SinkSPI, config []byte) *Pipeline {
+	p.plugin.AddSink(sinkName, factory)
+	p.sinkCfg = config
+	p.hasSink = true
+	return p
+}
+
+// Window sets how many batches the source may read ahead of the sink.
+func (p *Pipeline) Window(n int) *Pipeline {
+	p.window = n
+	return p
+}
+
+// Logger sets the logger used by the SDK and handed to the connectors.
+func (p *Pipeline) Logger(l sdk.Logger) *Pipeline {
+	p.plugin.WithLogger(l)
+	return p
+}
+
+// Runtime changes the SDK runtime settings, e.g. panic_policy or
+// log_level, from their defaults.
+func (p *Pipeline) Runtime(fn func(*sdk.RuntimeConfig)) *Pipeline {
+	fn(&p.cfg)
+	return p
+}
+
+func processorName(i int) string {
+	return fmt.Sprintf("processor-%d", i)
+}
+
+// Run opens a session per connector, moves batches from the source
+// through the processors to the sink, and once the source is done ends
+// the stream and closes the sessions source first so the sink is
+// flushed last.
+func (p *Pipeline) Run(ctx context.Context) (Result, error) {
+	if !p.hasSink {
+		return Result{}, errors.New("pipeline: no sink")
+	}
+	if p.window < 1 {
+		return Result{}, fmt.Errorf("pipeline: window must be at least 1, got %d", p.window)
+	}
+
+	conns, opts := bridge.Plugin(p.plugin)
+	opts.Registerer, opts.Gatherer, opts.MeterProvider = nil, nil, nil
+	proc, err := runtime.NewProcess(p.cfg, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	r := &run{
+		proc:      proc,
+		source:    runtime.NewSourceServer(proc, conns.Sources),
+		processor: runtime.NewProcessorServer(proc, conns.Processors),
+		sink:      runtime.NewSinkServer(proc, conns.Sinks),
+	}
+	start := time.Now()
+	err = r.run(ctx, p)
+	return Result{Read: r.read, Written: r.written, Elapsed: time.Since(start)}, err
+}
+
+type run struct {
+	proc      *runtime.Process
+	source    *runtime.SourceServer
+	processor *runtime.ProcessorServer
+	sink      *runtime.SinkServer
+
+	sourceID     string
+	processorIDs []string
+	sinkID       string
+
+	read, written sdk.TrafficStats
+}
+
+func (r *run) run(ctx context.Context, p *Pipeline) (err error) {
+	// Downstream sessions are opened first so the source has somewhere
+	// to send its first batch.
+	resp, err := r.sink.CreateSession(withMD(ctx, sinkName, ""), &pb.SessionCreateRequest{Config: p.sinkCfg})
+	if err != nil {
+		return fmt.Errorf("pipeline: sink: %w", err)
+	}
+	r.sinkID = resp.SessionId
+	defer func() {
+		err = errors.Join(err, r.close(r.sink.CloseSession, sinkName, r.sinkID))
+	}()
+
+	for i, cfg := range p.processors {
+		name := processorName(i)
+		resp, err := r.processor.CreateSession(withMD(ctx, name, ""), &pb.SessionCreateRequest{Config: cfg})
+		if err != nil {
+			return fmt.Errorf("pipeline: %s: %w", name, err)
+		}
+		r.processorIDs = append(r.processorIDs, resp.SessionId)
+	}
+	defer func() {
+		for i, id := range r.processorIDs {
+			err = errors.Join(err, r.close(r.processor.CloseSession, processorName(i), id))
+		}
+	}()
+
+	resp, err = r.source.CreateSession(withMD(ctx, sourceName, ""), &pb.SessionCreateRequest{Config: p.sourceCfg})
+	if err != nil {
+		return fmt.Errorf("pipeline: source: %w", err)
+	}
+	r.sourceID = resp.SessionId
+	defer func() {
+		err = errors.Join(err, r.close(r.source.CloseSession, sourceName, r.sourceID))
+	}()
+
+	return r.stream(ctx, p.window)
+}
+
+// stream moves batches until the source stream ends, acking each batch
+// once the sink has accepted it.
+func (r *run) stream(ctx context.Context, window int) error {
+	streamCtx, cancel := context.WithCancel(withMD(ctx, sourceName, r.sourceID))
+	defer cancel()
+
+	batches := make(chan *pb.Batch)
+	done := make(chan error, 1)
+	go func() {
+		done <- r.source.OpenStream(
+			&pb.StreamOpenRequest{SessionId: r.sourceID, InitialWindow: int32(window)},
+			bridge.NewSourceStream(streamCtx, batches),
+		)
+	}()
+
+	stop := func(err error) error {
+		cancel()
+		<-done
+		r.snapshot()
+		return err
+	}
+
+	for {
+		select {
+		case msg := <-batches:
+			if err := r.deliver(ctx, msg); err != nil {
+				return stop(err)
+			}
+			if _, err := r.source.Ack(withMD(ctx, sourceName, r.sourceID),
+				&pb.AckRequest{SessionId: r.sourceID, NewWindow: 1}); err != nil {
+				return stop(err)
+			}
+		case err := <-done:
+			if err == nil || errors.Is(err, io.EOF) {
+				err = r.end(ctx)
+				r.snapshot()
+				return err
+			}
+			r.snapshot()
+			return fmt.Errorf("pipeline: source: %w", err)
+		case <-ctx.Done():
+			return stop(ctx.Err())
+		}
+	}
+}
+
+// deliver passes one packed batch through the processors to the sink.
+func (r *run) deliver(ctx context.Context, msg *pb.Batch) error {
+	var err error
+	for i, id := range r.processorIDs {
+		if msg, err = r.processor.Process(withMD(ctx, processorName(i), id), msg); err != nil {
+			return fmt.Errorf("pipeline: %s: %w", processorName(i), err)
+		}
+	}
+	if _, err := r.sink.WriteBatch(withMD(ctx, sinkName, r.sinkID), msg); err != nil {
+		return fmt.Errorf("pipeline: sink: %w", err)
+	}
+	return nil
+}
+
+// end sends the end of the stream through the processors to the sink
+// once the source is done, so they flush before any session is closed.
+func (r *run) end(ctx context.Context) error {
+	packed, err := batch.NewCodec().Pack(runtime.NewEnd("pipeline"))
+	if err != nil {
+		return err
+	}
+	return r.deliver(ctx, &pb.Batch{Payload: packed})
+}
+
+// snapshot records the traffic of the source and sink sessions, which
+// is no longer available once they are closed.
+func (r *run) snapshot() {
+	for _, s := range r.proc.Sessions() {
+		switch s.SessionID {
+		case r.sourceID:
+			r.read = s.Out
+		case r.sinkID:
+			r.written = s.In
+		}
+	}
+}
+
+// close ends a session without the run's context, so connectors are
+// closed even when the run was cancelled.
+func (r *run) close(fn func(context.Context, *pb.SessionCloseRequest) (*pb.Empty, error), name, id string) error {
+	if _, err := fn(withMD(context.Background(), name, id), &pb.SessionCloseRequest{SessionId: id}); err != nil {
+		return fmt.Errorf("pipeline: closing %s: %w", name, err)
+	}
+	return nil
+}
+
+// withMD adds the metadata the engine sends with calls for a connector
+// and, once created, its session.
+func withMD(ctx context.Context, connector, sessionID string) context.Context {
+	md := metadata.Pairs("x-planx-connector", connector)
+	if sessionID != "" {
+		md.Set("x-planx-session-id", sessionID)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
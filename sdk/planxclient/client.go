@@ -0,0 +1,193 @@
+// Package planxclient implements the engine side of the plugin protocol,
+// for programs that drive plugins without the engine: session lifecycle,
+// source streams with their credits and acks, batches sent to sinks and
+// processors, and the checkpoint, flush and end-of-stream markers.
+//
+//	c := planxclient.New(conn, planxclient.WithEngineID("loader"))
+//	src, err := c.OpenSource(ctx, planxclient.Session{Connector: "orders", Config: cfg}, 8)
+//	for {
+//		b, err := src.Recv(ctx)
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//		src.Ack(ctx, 1)
+//	}
+//	src.Close(ctx)
+//
+// Batches are sent inline; the client does not negotiate data planes.
+package planxclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client calls the plugin services over one connection. It is safe for
+// concurrent use.
+type Client struct {
+	engineID  string
+	codec     batch.Codec
+	source    pb.SourcePluginClient
+	sink      pb.SinkPluginClient
+	processor pb.ProcessorPluginClient
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithEngineID sets the x-planx-engine-id sent on every call, which the
+// plugin logs and audits sessions under.
+func WithEngineID(id string) Option {
+	return func(c *Client) { c.engineID = id }
+}
+
+// New returns a client calling the plugin on cc.
+func New(cc grpc.ClientConnInterface, opts ...Option) *Client {
+	c := &Client{
+		engineID:  "planxclient",
+		codec:     batch.NewCodec(),
+		source:    pb.NewSourcePluginClient(cc),
+		sink:      pb.NewSinkPluginClient(cc),
+		processor: pb.NewProcessorPluginClient(cc),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Session describes a session to create.
+type Session struct {
+	// Connector picks the connector of the role; it may be empty when
+	// the plugin has a single one.
+	Connector string
+	Config    []byte
+	TenantID  string
+	// StateNamespace names the session's state, so a session created
+	// under the same namespace picks up where this one left off.
+	StateNamespace string
+	// RestoreCheckpoint restores the session from a checkpoint it took.
+	RestoreCheckpoint string
+	ExactlyOnce       bool
+	StateChangelog    bool
+	// FlowPolicy overrides the plugin's flow_policy for the session.
+	FlowPolicy string
+	// Mode asks a source to backfill instead of tailing live data.
+	Mode sdk.SessionMode
+}
+
+func (s Session) md() metadata.MD {
+	md := metadata.MD{}
+	set := func(k, v string) {
+		if v != "" {
+			md.Set(k, v)
+		}
+	}
+	set("x-planx-connector", s.Connector)
+	set("x-planx-tenant-id", s.TenantID)
+	set("x-planx-state-namespace", s.StateNamespace)
+	set("x-planx-restore-checkpoint", s.RestoreCheckpoint)
+	set("x-planx-flow-policy", s.FlowPolicy)
+	if s.ExactlyOnce {
+		md.Set("x-planx-delivery", runtime.DeliveryExactlyOnce)
+	}
+	if s.StateChangelog {
+		md.Set("x-planx-state-changelog", "true")
+	}
+	runtime.SetModeMetadata(md, s.Mode)
+	return md
+}
+
+// session is what every kind of open session has.
+type session struct {
+	c  *Client
+	id string
+	md metadata.MD
+	// exactlyOnce is set when the plugin confirmed exactly-once delivery.
+	exactlyOnce bool
+}
+
+// create creates a session through create and returns it.
+func (c *Client) create(ctx context.Context, s Session,
+	create func(context.Context, *pb.SessionCreateRequest, ...grpc.CallOption) (*pb.SessionCreateResponse, error),
+) (session, error) {
+	md := s.md()
+	var header metadata.MD
+	resp, err := create(c.outgoing(ctx, md, ""), &pb.SessionCreateRequest{Config: s.Config}, grpc.Header(&header))
+	if err != nil {
+		return session{}, fmt.Errorf("planxclient: CreateSession: %w", err)
+	}
+	delivery := header.Get("x-planx-delivery")
+	return session{
+		c:           c,
+		id:          resp.SessionId,
+		md:          md,
+		exactlyOnce: len(delivery) > 0 && delivery[0] == runtime.DeliveryExactlyOnce,
+	}, nil
+}
+
+func (c *Client) outgoing(ctx context.Context, md metadata.MD, sessionID string) context.Context {
+	md = md.Copy()
+	md.Set("x-planx-engine-id", c.engineID)
+	if sessionID != "" {
+		md.Set("x-planx-session-id", sessionID)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// ctx returns ctx with the metadata of calls on the session and extra
+// key/value pairs.
+func (s *session) ctx(ctx context.Context, kv ...string) context.Context {
+	ctx = s.c.outgoing(ctx, s.md, s.id)
+	if len(kv) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+	}
+	return ctx
+}
+
+// SessionID is the ID the plugin assigned to the session.
+func (s *session) SessionID() string { return s.id }
+
+// ExactlyOnce reports whether the plugin runs the session exactly-once,
+// as asked with Session.ExactlyOnce.
+func (s *session) ExactlyOnce() bool { return s.exactlyOnce }
+
+func (s *session) close(ctx context.Context,
+	close func(context.Context, *pb.SessionCloseRequest, ...grpc.CallOption) (*pb.Empty, error),
+) error {
+	if _, err := close(s.ctx(ctx), &pb.SessionCloseRequest{SessionId: s.id}); err != nil {
+		return fmt.Errorf("planxclient: CloseSession: %w", err)
+	}
+	return nil
+}
+
+// window returns the inbound credits a plugin advertised in header, or
+// -1 if it did not.
+func window(header metadata.MD) int {
+	if v := header.Get("x-planx-window"); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			return n
+		}
+	}
+	return -1
+}
+
+// BarrierError returns the error a source reported in barrier b, if its
+// snapshot failed; sdk.BarrierID tells barriers from the batches a source
+// sends.
+func BarrierError(b *sdk.Batch) (string, bool) {
+	if _, ok := sdk.BarrierID(b); !ok {
+		return "", false
+	}
+	msg, ok := b.Metadata[runtime.BarrierErrorKey]
+	return msg, ok
+}
@@ -0,0 +1,163 @@
+package planxclient
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// inbound is the part of a sink or processor session that sends batches.
+type inbound struct {
+	session
+	window atomic.Int64
+}
+
+func (s *inbound) init(sess session) {
+	s.session = sess
+	s.window.Store(-1)
+}
+
+// Window returns the credits the plugin advertised on the last call, how
+// many more batches it takes for the session before it starts rejecting
+// them, or -1 if it has not advertised any.
+func (s *inbound) Window() int { return int(s.window.Load()) }
+
+func (s *inbound) send(ctx context.Context, b *sdk.Batch, method string,
+	call func(context.Context, *pb.Batch, ...grpc.CallOption) ([]byte, error),
+) ([]byte, error) {
+	packed, err := s.c.codec.Pack(b)
+	if err != nil {
+		return nil, fmt.Errorf("planxclient: pack batch: %w", err)
+	}
+	var header metadata.MD
+	out, err := call(s.ctx(ctx), &pb.Batch{Payload: packed}, grpc.Header(&header))
+	if n := window(header); n >= 0 {
+		s.window.Store(int64(n))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("planxclient: %s: %w", method, err)
+	}
+	return out, nil
+}
+
+// Sink is an open sink session.
+type Sink struct {
+	inbound
+}
+
+// OpenSink creates a sink session.
+func (c *Client) OpenSink(ctx context.Context, s Session) (*Sink, error) {
+	sess, err := c.create(ctx, s, c.sink.CreateSession)
+	if err != nil {
+		return nil, err
+	}
+	sink := &Sink{}
+	sink.init(sess)
+	return sink, nil
+}
+
+// Write sends a batch, or a marker, to the sink.
+func (s *Sink) Write(ctx context.Context, b *sdk.Batch) error {
+	_, err := s.send(ctx, b, "WriteBatch", func(ctx context.Context, in *pb.Batch, opts ...grpc.CallOption) ([]byte, error) {
+		_, err := s.c.sink.WriteBatch(ctx, in, opts...)
+		return nil, err
+	})
+	return err
+}
+
+// Checkpoint sends the barrier of checkpoint id and returns once the sink
+// has taken it.
+func (s *Sink) Checkpoint(ctx context.Context, id string) error {
+	return s.Write(ctx, runtime.NewBarrier(id))
+}
+
+// Commit commits checkpoint id.
+func (s *Sink) Commit(ctx context.Context, id string) error {
+	return s.Write(ctx, runtime.NewCommit(id))
+}
+
+// Flush asks the sink to write out what it buffers.
+func (s *Sink) Flush(ctx context.Context, id string) error {
+	return s.Write(ctx, runtime.NewFlush(id))
+}
+
+// End flushes the sink and ends its stream: it refuses batches after.
+func (s *Sink) End(ctx context.Context, id string) error {
+	return s.Write(ctx, runtime.NewEnd(id))
+}
+
+// Close closes the session.
+func (s *Sink) Close(ctx context.Context) error {
+	return s.close(ctx, s.c.sink.CloseSession)
+}
+
+// Processor is an open processor session.
+type Processor struct {
+	inbound
+}
+
+// OpenProcessor creates a processor session.
+func (c *Client) OpenProcessor(ctx context.Context, s Session) (*Processor, error) {
+	sess, err := c.create(ctx, s, c.processor.CreateSession)
+	if err != nil {
+		return nil, err
+	}
+	p := &Processor{}
+	p.init(sess)
+	return p, nil
+}
+
+// Process sends a batch, or a marker, to the processor and returns what
+// it produced; a marker is returned as it was sent.
+func (p *Processor) Process(ctx context.Context, b *sdk.Batch) (*sdk.Batch, error) {
+	packed, err := p.send(ctx, b, "Process", func(ctx context.Context, in *pb.Batch, opts ...grpc.CallOption) ([]byte, error) {
+		out, err := p.c.processor.Process(ctx, in, opts...)
+		return out.GetPayload(), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.c.codec.Unpack(packed)
+	if err != nil {
+		return nil, fmt.Errorf("planxclient: unpack batch: %w", err)
+	}
+	return out, nil
+}
+
+func (p *Processor) marker(ctx context.Context, b *sdk.Batch) error {
+	_, err := p.Process(ctx, b)
+	return err
+}
+
+// Checkpoint sends the barrier of checkpoint id and returns once the
+// processor has taken it.
+func (p *Processor) Checkpoint(ctx context.Context, id string) error {
+	return p.marker(ctx, runtime.NewBarrier(id))
+}
+
+// Commit commits checkpoint id.
+func (p *Processor) Commit(ctx context.Context, id string) error {
+	return p.marker(ctx, runtime.NewCommit(id))
+}
+
+// Flush asks the processor to emit what it buffers.
+func (p *Processor) Flush(ctx context.Context, id string) error {
+	return p.marker(ctx, runtime.NewFlush(id))
+}
+
+// End flushes the processor and ends its stream: it refuses batches
+// after.
+func (p *Processor) End(ctx context.Context, id string) error {
+	return p.marker(ctx, runtime.NewEnd(id))
+}
+
+// Close closes the session.
+func (p *Processor) Close(ctx context.Context) error {
+	return p.close(ctx, p.c.processor.CloseSession)
+}
@@ -0,0 +1,86 @@
+package planxclient
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+	"google.golang.org/grpc"
+)
+
+// Source is an open source session and its stream.
+type Source struct {
+	session
+	stream grpc.ServerStreamingClient[pb.Batch]
+	stop   context.CancelFunc
+}
+
+// OpenSource creates a source session and opens its stream with window
+// credits: the plugin sends up to window batches before it waits for an
+// Ack. The stream lasts until ctx is done or Close.
+func (c *Client) OpenSource(ctx context.Context, s Session, window int) (*Source, error) {
+	sess, err := c.create(ctx, s, c.source.CreateSession)
+	if err != nil {
+		return nil, err
+	}
+	src := &Source{session: sess}
+	sctx, stop := context.WithCancel(src.ctx(ctx))
+	stream, err := c.source.OpenStream(sctx, &pb.StreamOpenRequest{SessionId: sess.id, InitialWindow: int32(window)})
+	if err != nil {
+		stop()
+		err = fmt.Errorf("planxclient: OpenStream: %w", err)
+		if cerr := src.close(ctx, c.source.CloseSession); cerr != nil {
+			return nil, fmt.Errorf("%w (%w)", err, cerr)
+		}
+		return nil, err
+	}
+	src.stream, src.stop = stream, stop
+	return src, nil
+}
+
+// Recv returns the next batch the source sends, or io.EOF once it has
+// read everything. Barriers come in order among the batches; they take
+// no credit.
+func (s *Source) Recv() (*sdk.Batch, error) {
+	msg, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.c.codec.Unpack(msg.GetPayload())
+	if err != nil {
+		return nil, fmt.Errorf("planxclient: unpack batch: %w", err)
+	}
+	return b, nil
+}
+
+// Ack hands n credits back to the source, for batches the caller is done
+// with.
+func (s *Source) Ack(ctx context.Context, n int) error {
+	return s.ack(ctx, n)
+}
+
+// Checkpoint starts checkpoint id: the source sends its barrier before
+// the next batch it reads.
+func (s *Source) Checkpoint(ctx context.Context, id string) error {
+	return s.ack(ctx, 0, "x-planx-checkpoint-id", id)
+}
+
+// Commit commits checkpoint id, once every session of the pipeline has
+// taken it.
+func (s *Source) Commit(ctx context.Context, id string) error {
+	return s.ack(ctx, 0, "x-planx-checkpoint-committed", id)
+}
+
+func (s *Source) ack(ctx context.Context, n int, kv ...string) error {
+	if _, err := s.c.source.Ack(s.ctx(ctx, kv...), &pb.AckRequest{SessionId: s.id, NewWindow: int32(n)}); err != nil {
+		return fmt.Errorf("planxclient: Ack: %w", err)
+	}
+	return nil
+}
+
+// Close ends the stream and closes the session.
+func (s *Source) Close(ctx context.Context) error {
+	s.stop()
+	return s.close(ctx, s.c.source.CloseSession)
+}
@@ -0,0 +1,276 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/planx-lab/planx-sdk-go/internal/bridge"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Plugin registers the connectors served by one plugin binary.
+//
+//	sdk.NewPlugin("s3", "1.2.0").
+//		AddSource("s3", newSource).
+//		AddSink("s3", newSink).
+//		Run()
+//
+// The engine picks a connector with the x-planx-connector metadata on
+// CreateSession; it may be omitted when a role has a single connector.
+type Plugin struct {
+	name    string
+	version string
+	logger  Logger
+
+	registerer    prometheus.Registerer
+	gatherer      prometheus.Gatherer
+	meterProvider metric.MeterProvider
+	audit         func(AuditEvent)
+	redact        RedactionFunc
+	usage         func([]TenantUsage)
+	authorizer    Authorizer
+	keys          KeyProvider
+	dataPlanes    []DataPlane
+
+	stateOnce sync.Once
+	state     state.Backend
+	changelog state.Changelog
+
+	sources    map[string]func() runtime.SourceSPI
+	sinks      map[string]func() runtime.SinkSPI
+	processors map[string]func() runtime.ProcessorSPI
+	connectors []runtime.ConnectorInfo
+}
+
+func NewPlugin(name, version string) *Plugin {
+	return &Plugin{
+		name:       name,
+		version:    version,
+		sources:    make(map[string]func() runtime.SourceSPI),
+		sinks:      make(map[string]func() runtime.SinkSPI),
+		processors: make(map[string]func() runtime.ProcessorSPI),
+		logger:     defaultLogger(),
+		registerer: prometheus.DefaultRegisterer,
+		gatherer:   prometheus.DefaultGatherer,
+	}
+}
+
+// WithMetricsRegistry registers the SDK's Prometheus metrics on reg
+// instead of the default registry; reg is also what the metrics endpoint
+// (metrics_address) serves. A nil reg disables SDK metrics.
+func (p *Plugin) WithMetricsRegistry(reg *prometheus.Registry) *Plugin {
+	if reg == nil {
+		p.registerer, p.gatherer = nil, nil
+		return p
+	}
+	p.registerer, p.gatherer = reg, reg
+	return p
+}
+
+// WithMeterProvider supplies the OpenTelemetry meter provider used when
+// metrics_backend is "otlp". Without it the SDK exports over OTLP/gRPC
+// to otlp_endpoint.
+func (p *Plugin) WithMeterProvider(mp metric.MeterProvider) *Plugin {
+	p.meterProvider = mp
+	return p
+}
+
+// WithAuditHandler calls fn synchronously with every session audit
+// event, in addition to the audit_log destination. fn must not block.
+func (p *Plugin) WithAuditHandler(fn func(AuditEvent)) *Plugin {
+	p.audit = fn
+	return p
+}
+
+// WithRedaction applies fn to metadata and context values before any SDK
+// log line, including lines logged through SessionContext.Logger.
+func (p *Plugin) WithRedaction(fn RedactionFunc) *Plugin {
+	p.redact = fn
+	return p
+}
+
+// WithUsageHandler calls fn every usage_flush_interval with the traffic
+// of each tenant active since the previous call. Cumulative per-tenant
+// totals are also exported as metrics and by the admin GetTenantUsage
+// call.
+func (p *Plugin) WithUsageHandler(fn func([]TenantUsage)) *Plugin {
+	p.usage = fn
+	return p
+}
+
+// WithAuthorizer lets a decide whether each session may be created,
+// before its SPI is initialized.
+func (p *Plugin) WithAuthorizer(a Authorizer) *Plugin {
+	p.authorizer = a
+	return p
+}
+
+// WithKeyProvider decrypts encrypted connector configs with kp before
+// SPI.Init. See KeyProvider for the accepted forms.
+func (p *Plugin) WithKeyProvider(kp KeyProvider) *Plugin {
+	p.keys = kp
+	return p
+}
+
+// WithDataPlane lets sessions negotiate dp, under its name, with the
+// engine. See DataPlane.
+func (p *Plugin) WithDataPlane(dp DataPlane) *Plugin {
+	p.dataPlanes = append(p.dataPlanes, dp)
+	return p
+}
+
+// WithStateBackend keeps session state in b instead of the backend
+// named by the state_backend setting.
+func (p *Plugin) WithStateBackend(b state.Backend) *Plugin {
+	p.state = b
+	return p
+}
+
+// WithStateChangelog mirrors the state of sessions created with the
+// x-planx-state-changelog: true metadata to c instead of the changelog
+// named by the state_changelog setting, e.g. to a compacted topic.
+func (p *Plugin) WithStateChangelog(c state.Changelog) *Plugin {
+	p.changelog = c
+	return p
+}
+
+// stateBackend returns the state backend, in memory unless one was set.
+func (p *Plugin) stateBackend() state.Backend {
+	p.stateOnce.Do(func() {
+		if p.state == nil {
+			p.state = state.Memory()
+		}
+	})
+	return p.state
+}
+
+// WithLogger sets the logger used by the SDK and handed to plugins
+// through SessionContext.Logger.
+func (p *Plugin) WithLogger(l Logger) *Plugin {
+	p.logger = l
+	return p
+}
+
+func (p *Plugin) AddSource(name string, factory func() SourceSPI) *Plugin {
+	p.register("source", name, p.sources[name] != nil)
+	p.sources[name] = func() runtime.SourceSPI {
+		return newSourceWrapper(factory(), p.logger, p.stateBackend(), p.changelog)
+	}
+	return p
+}
+
+func (p *Plugin) AddSink(name string, factory func() SinkSPI) *Plugin {
+	p.register("sink", name, p.sinks[name] != nil)
+	p.sinks[name] = func() runtime.SinkSPI {
+		return newSinkWrapper(factory(), p.logger, p.stateBackend(), p.changelog)
+	}
+	return p
+}
+
+func (p *Plugin) AddProcessor(name string, factory func() ProcessorSPI) *Plugin {
+	p.register("processor", name, p.processors[name] != nil)
+	p.processors[name] = func() runtime.ProcessorSPI {
+		return newProcessorWrapper(factory(), p.logger, p.stateBackend(), p.changelog)
+	}
+	return p
+}
+
+func (p *Plugin) register(role, name string, exists bool) {
+	if exists {
+		panic(fmt.Sprintf("planx: %s connector %q registered twice", role, name))
+	}
+	p.connectors = append(p.connectors, runtime.ConnectorInfo{Role: role, Name: name})
+}
+
+// Run serves all registered connectors and blocks until the server stops.
+func (p *Plugin) Run() {
+	build := ReadBuildInfo()
+	version := p.version
+	if version == "" {
+		version = build.PluginVersion
+	}
+
+	info := runtime.PluginInfo{
+		Name:       p.name,
+		Version:    version,
+		Connectors: p.connectors,
+		Build:      build,
+	}
+	cfg, err := ResolveRuntimeConfig()
+	if err != nil {
+		panic(err)
+	}
+	if p.state == nil {
+		if p.state, err = state.Open(cfg.StateBackend); err != nil {
+			panic(err)
+		}
+	}
+	if p.changelog == nil && cfg.StateChangelog != "" {
+		if p.changelog, err = state.OpenChangelog(cfg.StateChangelog); err != nil {
+			panic(err)
+		}
+	}
+
+	proc, err := runtime.NewProcess(cfg, p.options())
+	if err != nil {
+		panic(err)
+	}
+
+	runtime.ServeGRPC(proc, info, p.factories().Servers(proc))
+}
+
+func (p *Plugin) factories() bridge.Connectors {
+	return bridge.Connectors{
+		Sources:    p.sources,
+		Sinks:      p.sinks,
+		Processors: p.processors,
+	}
+}
+
+func (p *Plugin) options() runtime.Options {
+	return runtime.Options{
+		Logger:        p.logger,
+		Registerer:    p.registerer,
+		Gatherer:      p.gatherer,
+		MeterProvider: p.meterProvider,
+		Audit:         p.audit,
+		Redact:        p.redact,
+		Usage:         p.usage,
+		Authorize:     p.authorize(),
+		Keys:          p.keys,
+		DataPlanes:    p.dataPlanes,
+
+		ImportCheckpoint: func(_ context.Context, namespace, id string, data []byte) error {
+			return importCheckpoint(p.stateBackend(), namespace, id, data)
+		},
+		StateStats: p.stateStats,
+	}
+}
+
+// stateStats reports the state backend, if it keeps stats.
+func (p *Plugin) stateStats() (runtime.StateStats, bool) {
+	r, ok := p.stateBackend().(state.Reporter)
+	if !ok {
+		return runtime.StateStats{}, false
+	}
+	st := r.Stats()
+	return runtime.StateStats(st), true
+}
+
+func (p *Plugin) authorize() func(context.Context, runtime.AuthRequest) error {
+	if p.authorizer == nil {
+		return nil
+	}
+	return p.authorizer.Authorize
+}
+
+func init() {
+	bridge.Plugin = func(v any) (bridge.Connectors, runtime.Options) {
+		p := v.(*Plugin)
+		return p.factories(), p.options()
+	}
+}
@@ -0,0 +1,262 @@
+// Package plugintest runs plugin connectors against an in-memory engine,
+// so plugin authors can test the full session lifecycle without gRPC or
+// the real engine:
+//
+//	eng, err := plugintest.New(sdk.NewPlugin("s3", "").AddSource("", newSource))
+//	...
+//	src, err := eng.OpenSource(ctx, config)
+//	b, err := src.Recv(ctx)
+//	src.Ack(1)
+//	src.Close()
+//
+// Calls go through the same SDK runtime as a served plugin: session
+// setup, flow control, panic recovery and batch encoding all apply.
+// NewGRPC runs the same engine against the real gRPC servers over an
+// in-process bufconn listener, adding interceptors, metadata and
+// streaming to what is exercised. WithLeakCheck or CheckLeaks fail a
+// test whose sessions or streams leave goroutines running.
+package plugintest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/bridge"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Engine plays the engine side of the plugin protocol.
+type Engine struct {
+	settings
+	proc      *runtime.Process
+	codec     batch.Codec
+	source    sourceAPI
+	sink      sinkAPI
+	processor processorAPI
+
+	// withMD attaches call metadata: incoming for in-memory calls,
+	// outgoing for calls over gRPC.
+	withMD func(context.Context, metadata.MD) context.Context
+	// stop shuts down the transport, if any.
+	stop      func()
+	closeOnce sync.Once
+	conn      *grpc.ClientConn
+}
+
+// sourceAPI is the source protocol as seen by the engine. stream runs
+// OpenStream, delivering batches to out until the stream ends.
+type sourceAPI interface {
+	CreateSession(context.Context, *pb.SessionCreateRequest) (*pb.SessionCreateResponse, error)
+	Ack(context.Context, *pb.AckRequest) (*pb.AckResponse, error)
+	CloseSession(context.Context, *pb.SessionCloseRequest) (*pb.Empty, error)
+	stream(ctx context.Context, req *pb.StreamOpenRequest, out chan<- *pb.Batch) error
+}
+
+type sinkAPI interface {
+	CreateSession(context.Context, *pb.SessionCreateRequest) (*pb.SessionCreateResponse, error)
+	WriteBatch(context.Context, *pb.Batch) (*pb.AckResponse, error)
+	CloseSession(context.Context, *pb.SessionCloseRequest) (*pb.Empty, error)
+}
+
+type processorAPI interface {
+	CreateSession(context.Context, *pb.SessionCreateRequest) (*pb.SessionCreateResponse, error)
+	Process(context.Context, *pb.Batch) (*pb.Batch, error)
+	CloseSession(context.Context, *pb.SessionCloseRequest) (*pb.Empty, error)
+}
+
+// settings describe how the fake engine calls the plugin.
+type settings struct {
+	tenant         string
+	connector      string
+	stateNamespace string
+	restore        string
+	exactlyOnce    bool
+	changelog      bool
+	window         int
+	mode           sdk.SessionMode
+}
+
+// md is the metadata the engine sends with every call.
+func (s settings) md(sessionID string) metadata.MD {
+	md := metadata.MD{}
+	if s.tenant != "" {
+		md.Set("x-planx-tenant-id", s.tenant)
+	}
+	if s.connector != "" {
+		md.Set("x-planx-connector", s.connector)
+	}
+	if s.stateNamespace != "" {
+		md.Set("x-planx-state-namespace", s.stateNamespace)
+	}
+	if s.restore != "" {
+		md.Set("x-planx-restore-checkpoint", s.restore)
+	}
+	if s.exactlyOnce {
+		md.Set("x-planx-delivery", runtime.DeliveryExactlyOnce)
+	}
+	if s.changelog {
+		md.Set("x-planx-state-changelog", "true")
+	}
+	runtime.SetModeMetadata(md, s.mode)
+	if sessionID != "" {
+		md.Set("x-planx-session-id", sessionID)
+	}
+	return md
+}
+
+type Option func(*options)
+
+type options struct {
+	cfg sdk.RuntimeConfig
+	settings
+	leaks testing.TB
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{cfg: runtime.DefaultConfig(), settings: settings{window: 1}}
+	o.cfg.MetricsBackend = runtime.MetricsNone
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.leaks != nil {
+		CheckLeaks(o.leaks)
+	}
+	return o
+}
+
+// started finishes setting up e, closing it when the leak check's test
+// ends so the check only sees goroutines that outlive the engine.
+func (o *options) started(e *Engine) *Engine {
+	if o.leaks != nil {
+		o.leaks.Cleanup(e.Close)
+	}
+	return e
+}
+
+// newProcess builds the plugin process for p with SDK metrics disabled,
+// so that several harnesses can run in one test binary.
+func newProcess(p *sdk.Plugin, o *options) (*runtime.Process, bridge.Connectors, error) {
+	conns, procOpts := bridge.Plugin(p)
+	procOpts.Registerer, procOpts.Gatherer, procOpts.MeterProvider = nil, nil, nil
+	proc, err := runtime.NewProcess(o.cfg, procOpts)
+	return proc, conns, err
+}
+
+// WithRuntimeConfig changes the SDK runtime settings, e.g. panic_policy
+// or flow_policy, from their defaults.
+func WithRuntimeConfig(fn func(*sdk.RuntimeConfig)) Option {
+	return func(o *options) { fn(&o.cfg) }
+}
+
+// WithTenant sets the tenant sessions are created for.
+func WithTenant(id string) Option {
+	return func(o *options) { o.tenant = id }
+}
+
+// WithConnector selects the connector by name when the plugin registers
+// several for a role.
+func WithConnector(name string) Option {
+	return func(o *options) { o.connector = name }
+}
+
+// WithStateNamespace creates sessions on the named state, so a session
+// opened after another one closed sees the state it left behind.
+func WithStateNamespace(ns string) Option {
+	return func(o *options) { o.stateNamespace = ns }
+}
+
+// WithRestoreCheckpoint creates sessions from checkpoint id of the state
+// namespace set with WithStateNamespace.
+func WithRestoreCheckpoint(id string) Option {
+	return func(o *options) { o.restore = id }
+}
+
+// WithExactlyOnce creates sessions with exactly-once delivery, which
+// fails for connectors that do not support it.
+func WithExactlyOnce() Option {
+	return func(o *options) { o.exactlyOnce = true }
+}
+
+// WithStateChangelog creates sessions whose state is mirrored to the
+// plugin's changelog; see Plugin.WithStateChangelog.
+func WithStateChangelog() Option {
+	return func(o *options) { o.changelog = true }
+}
+
+// WithMode creates sessions in mode m, e.g. a backfill:
+//
+//	plugintest.WithMode(sdk.SessionMode{Name: sdk.ModeBackfill, End: cutover})
+func WithMode(m sdk.SessionMode) Option {
+	return func(o *options) { o.mode = m }
+}
+
+// WithInitialWindow sets the credits a source stream is opened with.
+// The default is 1, so each batch must be acked before the next is read.
+func WithInitialWindow(n int) Option {
+	return func(o *options) { o.window = n }
+}
+
+// WithLeakCheck runs CheckLeaks for t, closing the engine at the end of
+// the test before goroutines are checked.
+func WithLeakCheck(t testing.TB) Option {
+	return func(o *options) { o.leaks = t }
+}
+
+// New starts an in-memory engine for the connectors registered on p.
+func New(p *sdk.Plugin, opts ...Option) (*Engine, error) {
+	o := newOptions(opts)
+	proc, conns, err := newProcess(p, o)
+	if err != nil {
+		return nil, err
+	}
+	return o.started(&Engine{
+		settings:  o.settings,
+		proc:      proc,
+		codec:     batch.NewCodec(),
+		source:    memSource{runtime.NewSourceServer(proc, conns.Sources)},
+		sink:      runtime.NewSinkServer(proc, conns.Sinks),
+		processor: runtime.NewProcessorServer(proc, conns.Processors),
+		withMD:    metadata.NewIncomingContext,
+		stop:      func() {},
+	}), nil
+}
+
+func (e *Engine) ctx(ctx context.Context, sessionID string) context.Context {
+	return e.withMD(ctx, e.md(sessionID))
+}
+
+// Close stops the engine's transport. Sessions should be closed first.
+// Calling Close again has no effect.
+func (e *Engine) Close() {
+	e.closeOnce.Do(e.stop)
+}
+
+// Snapshot returns the runtime state of the engine's plugin process,
+// including per-session flow and traffic counters.
+func (e *Engine) Snapshot() runtime.ProcessSnapshot {
+	return e.proc.Snapshot()
+}
+
+// Savepoint takes savepoint id of all open sessions, as the admin
+// Savepoint call does.
+func (e *Engine) Savepoint(ctx context.Context, id string) (*runtime.Savepoint, error) {
+	return e.proc.Savepoint(ctx, id, nil)
+}
+
+// ImportSavepoint stores the checkpoints of sp in the plugin's state
+// backend, as the admin ImportSavepoint call does, for sessions created
+// with WithStateNamespace and WithRestoreCheckpoint(sp.ID).
+func (e *Engine) ImportSavepoint(ctx context.Context, sp *runtime.Savepoint) error {
+	return e.proc.ImportSavepoint(ctx, sp)
+}
+
+func (e *Engine) closeSession(ctx context.Context, close func(context.Context, *pb.SessionCloseRequest) (*pb.Empty, error), id string) error {
+	_, err := close(e.ctx(ctx, id), &pb.SessionCloseRequest{SessionId: id})
+	return err
+}
@@ -0,0 +1,148 @@
+package plugintest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/batch"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bufSize is the in-memory buffer of each bufconn connection.
+const bufSize = 1 << 20
+
+// NewGRPC serves the connectors registered on p from the SDK's gRPC
+// servers over bufconn and returns an engine that calls them through a
+// client connection. Close shuts both down.
+func NewGRPC(p *sdk.Plugin, opts ...Option) (*Engine, error) {
+	o := newOptions(opts)
+	proc, conns, err := newProcess(p, o)
+	if err != nil {
+		return nil, err
+	}
+
+	lis := bufconn.Listen(bufSize)
+	srv := runtime.NewGRPCServer(proc, conns.Register(proc))
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		srv.Stop()
+		return nil, err
+	}
+
+	e := &Engine{
+		settings:  o.settings,
+		proc:      proc,
+		codec:     batch.NewCodec(),
+		source:    grpcSource{pb.NewSourcePluginClient(conn)},
+		sink:      grpcSink{pb.NewSinkPluginClient(conn)},
+		processor: grpcProcessor{pb.NewProcessorPluginClient(conn)},
+		withMD:    metadata.NewOutgoingContext,
+		conn:      conn,
+	}
+	e.stop = func() {
+		conn.Close()
+		srv.Stop()
+	}
+	return o.started(e), nil
+}
+
+// Conn is the client connection to the plugin's gRPC servers, or nil
+// for an in-memory engine. It can be used to call services the engine
+// has no helper for, such as the admin service.
+func (e *Engine) Conn() *grpc.ClientConn {
+	return e.conn
+}
+
+// Context returns ctx with the metadata the engine sends on calls for
+// sessionID, for use with Conn.
+func (e *Engine) Context(ctx context.Context, sessionID string) context.Context {
+	return e.ctx(ctx, sessionID)
+}
+
+type grpcSource struct {
+	c pb.SourcePluginClient
+}
+
+func (g grpcSource) CreateSession(ctx context.Context, req *pb.SessionCreateRequest) (*pb.SessionCreateResponse, error) {
+	return g.c.CreateSession(ctx, req)
+}
+
+func (g grpcSource) Ack(ctx context.Context, req *pb.AckRequest) (*pb.AckResponse, error) {
+	return g.c.Ack(ctx, req)
+}
+
+func (g grpcSource) CloseSession(ctx context.Context, req *pb.SessionCloseRequest) (*pb.Empty, error) {
+	return g.c.CloseSession(ctx, req)
+}
+
+func (g grpcSource) stream(ctx context.Context, req *pb.StreamOpenRequest, out chan<- *pb.Batch) error {
+	stream, err := g.c.OpenStream(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		b, err := stream.Recv()
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case status.Code(err) == codes.Canceled:
+			return context.Canceled
+		case err != nil:
+			return err
+		}
+		select {
+		case out <- b:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type grpcSink struct {
+	c pb.SinkPluginClient
+}
+
+func (g grpcSink) CreateSession(ctx context.Context, req *pb.SessionCreateRequest) (*pb.SessionCreateResponse, error) {
+	return g.c.CreateSession(ctx, req)
+}
+
+func (g grpcSink) WriteBatch(ctx context.Context, b *pb.Batch) (*pb.AckResponse, error) {
+	return g.c.WriteBatch(ctx, b)
+}
+
+func (g grpcSink) CloseSession(ctx context.Context, req *pb.SessionCloseRequest) (*pb.Empty, error) {
+	return g.c.CloseSession(ctx, req)
+}
+
+type grpcProcessor struct {
+	c pb.ProcessorPluginClient
+}
+
+func (g grpcProcessor) CreateSession(ctx context.Context, req *pb.SessionCreateRequest) (*pb.SessionCreateResponse, error) {
+	return g.c.CreateSession(ctx, req)
+}
+
+func (g grpcProcessor) Process(ctx context.Context, b *pb.Batch) (*pb.Batch, error) {
+	return g.c.Process(ctx, b)
+}
+
+func (g grpcProcessor) CloseSession(ctx context.Context, req *pb.SessionCloseRequest) (*pb.Empty, error) {
+	return g.c.CloseSession(ctx, req)
+}
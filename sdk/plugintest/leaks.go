@@ -0,0 +1,114 @@
+package plugintest
+
+import (
+	"bytes"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LeakTimeout is how long CheckLeaks waits for goroutines to exit after
+// the test before reporting them.
+var LeakTimeout = 5 * time.Second
+
+// CheckLeaks fails t if goroutines started during the test are still
+// running once it and its cleanups finish, for example a source stream
+// loop that outlived Close. Call it first so that cleanups registered
+// later, such as closing an engine, run before the check:
+//
+//	func TestSource(t *testing.T) {
+//		plugintest.CheckLeaks(t)
+//		eng, err := plugintest.New(plugin)
+//		...
+//	}
+//
+// Tests using CheckLeaks must not run in parallel with other tests, whose
+// goroutines would be reported too.
+func CheckLeaks(t testing.TB) {
+	t.Helper()
+	before := goroutines()
+	t.Cleanup(func() {
+		t.Helper()
+		if leaked := waitForLeaks(before, LeakTimeout); len(leaked) > 0 {
+			t.Errorf("plugintest: %d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
+
+// waitForLeaks returns the stacks of goroutines not in before that are
+// still running after timeout. Goroutines take a moment to exit once
+// cancelled, so it polls rather than checking once.
+func waitForLeaks(before map[int]string, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		var leaked []string
+		for id, stack := range goroutines() {
+			if _, ok := before[id]; !ok && !ignored(stack) {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			slices.Sort(leaked)
+			return leaked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ignoredFuncs are goroutines owned by the Go runtime, the testing
+// package or the plugin that may legitimately start during a test.
+var ignoredFuncs = []string{
+	"testing.tRunner",
+	"testing.(*T).Run",
+	"testing.runFuzzing",
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.ensureSigM",
+	// The state backend's collection belongs to the plugin, which
+	// outlives engines.
+	"github.com/planx-lab/planx-sdk-go/sdk/state.(*gcLoop).start",
+}
+
+func ignored(stack string) bool {
+	for _, fn := range ignoredFuncs {
+		if strings.Contains(stack, "\n"+fn+"(") || strings.Contains(stack, "\ncreated by "+fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutines returns the stack of every goroutine but the caller's, by
+// goroutine ID.
+func goroutines() map[int]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := map[int]string{}
+	// The first stack is always the calling goroutine's.
+	for i, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if i == 0 {
+			continue
+		}
+		// Each stack starts with "goroutine <id> [<state>]:".
+		header, _, _ := bytes.Cut(stack, []byte("\n"))
+		fields := strings.Fields(string(header))
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[1]); err == nil {
+			stacks[id] = string(stack)
+		}
+	}
+	return stacks
+}
@@ -0,0 +1,129 @@
+package plugintest
+
+import (
+	"context"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+)
+
+// Sink is an open sink session.
+type Sink struct {
+	eng *Engine
+	id  string
+}
+
+// OpenSink creates a sink session with config.
+func (e *Engine) OpenSink(ctx context.Context, config []byte) (*Sink, error) {
+	resp, err := e.sink.CreateSession(e.ctx(ctx, ""), &pb.SessionCreateRequest{Config: config})
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{eng: e, id: resp.SessionId}, nil
+}
+
+// SessionID is the ID the SDK assigned to the session.
+func (s *Sink) SessionID() string { return s.id }
+
+// Write sends b to the sink as the engine would.
+func (s *Sink) Write(ctx context.Context, b *sdk.Batch) error {
+	payload, err := s.eng.codec.Pack(b)
+	if err != nil {
+		return err
+	}
+	_, err = s.eng.sink.WriteBatch(s.eng.ctx(ctx, s.id), &pb.Batch{Payload: payload})
+	return err
+}
+
+// Checkpoint sends the barrier of checkpoint id, returning once the
+// sink has taken it.
+func (s *Sink) Checkpoint(ctx context.Context, id string) error {
+	return s.Write(ctx, runtime.NewBarrier(id))
+}
+
+// Commit tells the sink that checkpoint id is complete across the
+// pipeline, returning once the sink has committed it.
+func (s *Sink) Commit(ctx context.Context, id string) error {
+	return s.Write(ctx, runtime.NewCommit(id))
+}
+
+// Flush has the sink flush what it buffered, returning once it has.
+func (s *Sink) Flush(ctx context.Context) error {
+	return s.Write(ctx, runtime.NewFlush("plugintest"))
+}
+
+// End ends the stream of the session: the sink is flushed, and refuses
+// batches written after it.
+func (s *Sink) End(ctx context.Context) error {
+	return s.Write(ctx, runtime.NewEnd("plugintest"))
+}
+
+// Close closes the session, draining and flushing the sink if it
+// supports it.
+func (s *Sink) Close() error {
+	return s.eng.closeSession(context.Background(), s.eng.sink.CloseSession, s.id)
+}
+
+// Processor is an open processor session.
+type Processor struct {
+	eng *Engine
+	id  string
+}
+
+// OpenProcessor creates a processor session with config.
+func (e *Engine) OpenProcessor(ctx context.Context, config []byte) (*Processor, error) {
+	resp, err := e.processor.CreateSession(e.ctx(ctx, ""), &pb.SessionCreateRequest{Config: config})
+	if err != nil {
+		return nil, err
+	}
+	return &Processor{eng: e, id: resp.SessionId}, nil
+}
+
+// SessionID is the ID the SDK assigned to the session.
+func (p *Processor) SessionID() string { return p.id }
+
+// Process sends b through the processor and returns its output.
+func (p *Processor) Process(ctx context.Context, b *sdk.Batch) (*sdk.Batch, error) {
+	payload, err := p.eng.codec.Pack(b)
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.eng.processor.Process(p.eng.ctx(ctx, p.id), &pb.Batch{Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	return p.eng.codec.Unpack(out.Payload)
+}
+
+// Checkpoint sends the barrier of checkpoint id through the processor,
+// returning once it has taken it.
+func (p *Processor) Checkpoint(ctx context.Context, id string) error {
+	_, err := p.Process(ctx, runtime.NewBarrier(id))
+	return err
+}
+
+// Commit tells the processor that checkpoint id is complete across the
+// pipeline.
+func (p *Processor) Commit(ctx context.Context, id string) error {
+	_, err := p.Process(ctx, runtime.NewCommit(id))
+	return err
+}
+
+// Flush has the processor flush what it buffered.
+func (p *Processor) Flush(ctx context.Context) error {
+	_, err := p.Process(ctx, runtime.NewFlush("plugintest"))
+	return err
+}
+
+// End ends the stream of the session: the processor is flushed, and
+// refuses batches sent after it.
+func (p *Processor) End(ctx context.Context) error {
+	_, err := p.Process(ctx, runtime.NewEnd("plugintest"))
+	return err
+}
+
+// Close closes the session.
+func (p *Processor) Close() error {
+	return p.eng.closeSession(context.Background(), p.eng.processor.CloseSession, p.id)
+}
@@ -0,0 +1,116 @@
+package plugintest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	pb "github.com/planx-lab/planx-proto/gen/go/planx/plugin/v4"
+	"github.com/planx-lab/planx-sdk-go/internal/bridge"
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/sdk"
+)
+
+// Source is an open source session with its stream running.
+type Source struct {
+	eng *Engine
+	id  string
+
+	batches chan *pb.Batch
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error // stream result, valid after done is closed
+	once    sync.Once
+}
+
+// OpenSource creates a source session with config and opens its stream.
+func (e *Engine) OpenSource(ctx context.Context, config []byte) (*Source, error) {
+	resp, err := e.source.CreateSession(e.ctx(ctx, ""), &pb.SessionCreateRequest{Config: config})
+	if err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(e.ctx(context.Background(), resp.SessionId))
+	s := &Source{
+		eng:     e,
+		id:      resp.SessionId,
+		batches: make(chan *pb.Batch),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		s.err = e.source.stream(streamCtx,
+			&pb.StreamOpenRequest{SessionId: s.id, InitialWindow: int32(e.window)}, s.batches)
+	}()
+	return s, nil
+}
+
+// SessionID is the ID the SDK assigned to the session.
+func (s *Source) SessionID() string { return s.id }
+
+// Recv returns the next batch the source sends, which may be the barrier
+// of a checkpoint requested with Checkpoint. When the stream has
+// ended it returns the stream's error, or io.EOF if there was none.
+func (s *Source) Recv(ctx context.Context) (*sdk.Batch, error) {
+	select {
+	case msg := <-s.batches:
+		return s.eng.codec.Unpack(msg.Payload)
+	case <-s.done:
+		if s.err != nil && !errors.Is(s.err, context.Canceled) {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Ack grants the source n more credits, as the engine does once it has
+// processed batches.
+func (s *Source) Ack(n int) error {
+	_, err := s.eng.source.Ack(s.eng.ctx(context.Background(), s.id),
+		&pb.AckRequest{SessionId: s.id, NewWindow: int32(n)})
+	return err
+}
+
+// Checkpoint asks the source to take checkpoint id. The source sends its
+// barrier, see sdk.BarrierID, before the next batch it reads.
+func (s *Source) Checkpoint(id string) error {
+	md := s.eng.md(s.id)
+	md.Set("x-planx-checkpoint-id", id)
+	_, err := s.eng.source.Ack(s.eng.withMD(context.Background(), md),
+		&pb.AckRequest{SessionId: s.id})
+	return err
+}
+
+// Commit tells the source that checkpoint id is complete across the
+// pipeline. The source commits it before its next read.
+func (s *Source) Commit(id string) error {
+	md := s.eng.md(s.id)
+	md.Set("x-planx-checkpoint-committed", id)
+	_, err := s.eng.source.Ack(s.eng.withMD(context.Background(), md),
+		&pb.AckRequest{SessionId: s.id})
+	return err
+}
+
+// Close ends the stream and closes the session.
+func (s *Source) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		<-s.done
+		err = s.eng.closeSession(context.Background(), s.eng.source.CloseSession, s.id)
+	})
+	return err
+}
+
+// memSource calls the source server directly.
+type memSource struct {
+	*runtime.SourceServer
+}
+
+func (m memSource) stream(ctx context.Context, req *pb.StreamOpenRequest, out chan<- *pb.Batch) error {
+	return m.OpenStream(req, bridge.NewSourceStream(ctx, out))
+}
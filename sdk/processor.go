@@ -4,6 +4,6 @@ import "context"
 
 type ProcessorSPI interface {
 	Init(ctx context.Context, config []byte) error
-	Process(batch Batch) (Batch, error)
+	Process(ctx context.Context, batch *Batch) (*Batch, error)
 	Close() error
 }
@@ -0,0 +1,111 @@
+package sdk
+
+import (
+	"context"
+	"sync"
+
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+)
+
+// SessionContext carries the per-session resources of a plugin instance.
+// The SDK creates one per session and makes it available through the ctx
+// passed to every SPI method; see SessionFromContext.
+type SessionContext struct {
+	SessionID string
+	TenantID  string
+	// Peer is the verified identity of the engine driving the session
+	// when the plugin serves mTLS, and empty otherwise. Plugins can use
+	// it to reject sessions from unexpected engines in Init.
+	Peer PeerIdentity
+	// ExactlyOnce is set when the engine runs the session with
+	// exactly-once delivery; see Committer.
+	ExactlyOnce bool
+	// Mode tells a source whether to tail live data or backfill
+	// historical data, e.g. with a full table scan, between optional
+	// bounds.
+	Mode SessionMode
+
+	Logger  Logger
+	Metrics Metrics
+	// State is the session's store in the plugin's state backend; see
+	// package state.
+	State StateStore
+
+	mu     sync.RWMutex
+	config []byte
+	data   map[any]any
+}
+
+// PeerIdentity identifies an engine by its verified client certificate.
+type PeerIdentity = session.Peer
+
+// SessionMode is how the engine wants a session to read, set on
+// CreateSession with x-planx-mode.
+type SessionMode = session.Mode
+
+const (
+	ModeLive     = session.ModeLive
+	ModeBackfill = session.ModeBackfill
+)
+
+type sessionContextKey struct{}
+
+// SessionFromContext returns the SessionContext of the session an SPI call
+// belongs to.
+func SessionFromContext(ctx context.Context) (*SessionContext, bool) {
+	sc, ok := ctx.Value(sessionContextKey{}).(*SessionContext)
+	return sc, ok
+}
+
+// Config returns the session's connector config: the one it was created
+// with, or the last one applied with ConfigUpdater.
+func (sc *SessionContext) Config() []byte {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.config
+}
+
+func (sc *SessionContext) setConfig(config []byte) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.config = config
+}
+
+// Set stores a value in the session's data bag.
+func (sc *SessionContext) Set(key, value any) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.data[key] = value
+}
+
+// Get returns a value previously stored with Set.
+func (sc *SessionContext) Get(key any) (any, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	v, ok := sc.data[key]
+	return v, ok
+}
+
+func newSessionContext(ctx context.Context, config []byte, log Logger, store StateStore) *SessionContext {
+	info, _ := session.InfoFromContext(ctx)
+	var metrics Metrics = nopMetrics{}
+	if info.Metrics != nil {
+		metrics = info.Metrics
+	}
+	return &SessionContext{
+		SessionID:   info.ID,
+		TenantID:    info.TenantID,
+		Peer:        info.Peer,
+		config:      config,
+		ExactlyOnce: info.ExactlyOnce,
+		Mode:        info.Mode,
+		Logger:      newSessionLogger(log, info.Log).With("session_id", info.ID, "tenant_id", info.TenantID),
+		Metrics:     metrics,
+		State:       store,
+		data:        make(map[any]any),
+	}
+}
+
+func withSession(ctx context.Context, sc *SessionContext) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, sc)
+}
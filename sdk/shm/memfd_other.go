@@ -0,0 +1,20 @@
+//go:build !linux
+
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var errUnsupported = fmt.Errorf("shm: %w on %s", errors.ErrUnsupported, runtime.GOOS)
+
+func createSegment(int) (*os.File, error) { return nil, errUnsupported }
+
+func segmentPath(*os.File) string { return "" }
+
+func mapFile(*os.File, int) ([]byte, error) { return nil, errUnsupported }
+
+func unmap([]byte) error { return errUnsupported }
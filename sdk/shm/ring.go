@@ -0,0 +1,141 @@
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// An entry is an 8-byte header, the length of the packed batch and a
+// flag, followed by the batch padded to 8 bytes. The writer sets the flag
+// once the batch is in place; the reader clears it to release the entry.
+const (
+	headerSize = 8
+	refSize    = 16
+
+	entryFree uint32 = 0
+	entryBusy uint32 = 1
+
+	// ringPoll is how often a writer waiting for space checks whether
+	// the reader released some.
+	ringPoll = 100 * time.Microsecond
+)
+
+// ErrRingFull is returned by a Put that found no space in time, as the
+// reader released nothing.
+var ErrRingFull = errors.New("shm: ring full")
+
+var errBadRef = errors.New("shm: invalid reference")
+
+// ring is one direction of a segment. Only the writer moves head and
+// tail, positions that grow without bound; the offset of a position is
+// its remainder by the ring size.
+type ring struct {
+	mem []byte
+
+	mu         sync.Mutex
+	head, tail uint64
+}
+
+func (r *ring) flag(off uint64) *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.mem[off+4]))
+}
+
+// reclaim moves tail past the entries the reader released.
+func (r *ring) reclaim() {
+	size := uint64(len(r.mem))
+	for r.tail < r.head {
+		off := r.tail % size
+		if atomic.LoadUint32(r.flag(off)) != entryFree {
+			return
+		}
+		r.tail += headerSize + align(uint64(binary.LittleEndian.Uint32(r.mem[off:])))
+	}
+}
+
+// put copies data into the ring, waiting up to timeout for space, and
+// returns its reference.
+func (r *ring) put(data []byte, timeout time.Duration) ([]byte, error) {
+	size := uint64(len(r.mem))
+	need := headerSize + align(uint64(len(data)))
+	if need > size {
+		return nil, fmt.Errorf("shm: batch of %d bytes exceeds ring of %d", len(data), size)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var off, pad uint64
+	deadline := time.Now().Add(timeout)
+	for {
+		r.reclaim()
+		// An entry does not wrap: the end of the ring is skipped with
+		// a free padding entry if it does not fit.
+		off, pad = r.head%size, 0
+		if off+need > size {
+			pad = size - off
+		}
+		if r.head+pad+need-r.tail <= size {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrRingFull
+		}
+		time.Sleep(ringPoll)
+	}
+	if pad > 0 {
+		binary.LittleEndian.PutUint32(r.mem[off:], uint32(pad-headerSize))
+		atomic.StoreUint32(r.flag(off), entryFree)
+		r.head += pad
+		off = 0
+	}
+	copy(r.mem[off+headerSize:], data)
+	binary.LittleEndian.PutUint32(r.mem[off:], uint32(len(data)))
+	atomic.StoreUint32(r.flag(off), entryBusy)
+	r.head += need
+
+	ref := make([]byte, refSize)
+	binary.LittleEndian.PutUint64(ref, off)
+	binary.LittleEndian.PutUint64(ref[8:], uint64(len(data)))
+	return ref, nil
+}
+
+// entry returns the offset of the entry ref refers to, checking that it
+// holds a batch of the length ref names.
+func (r *ring) entry(ref []byte) (off, n uint64, err error) {
+	if len(ref) != refSize {
+		return 0, 0, errBadRef
+	}
+	off, n = binary.LittleEndian.Uint64(ref), binary.LittleEndian.Uint64(ref[8:])
+	size := uint64(len(r.mem))
+	if off%headerSize != 0 || off >= size || n > size-off-headerSize ||
+		uint64(binary.LittleEndian.Uint32(r.mem[off:])) != n ||
+		atomic.LoadUint32(r.flag(off)) != entryBusy {
+		return 0, 0, errBadRef
+	}
+	return off, n, nil
+}
+
+// get returns the batch ref refers to, in place.
+func (r *ring) get(ref []byte) ([]byte, error) {
+	off, n, err := r.entry(ref)
+	if err != nil {
+		return nil, err
+	}
+	start := off + headerSize
+	return r.mem[start : start+n : start+n], nil
+}
+
+// release hands the entry ref refers to back to the writer.
+func (r *ring) release(ref []byte) {
+	if off, _, err := r.entry(ref); err == nil {
+		atomic.StoreUint32(r.flag(off), entryFree)
+	}
+}
+
+func align(n uint64) uint64 {
+	return (n + headerSize - 1) &^ (headerSize - 1)
+}
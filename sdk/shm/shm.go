@@ -0,0 +1,237 @@
+// Package shm provides a shared-memory data plane for plugins running on
+// the same host as the engine. Each session gets a memfd segment mapped by
+// both processes, holding one ring of packed batches per direction; the
+// session's gRPC Batch messages carry only a 16-byte reference to an
+// entry, so batches are not copied through the gRPC transport:
+//
+//	sdk.NewPlugin("warehouse", "1.0.0").
+//		WithDataPlane(shm.New(shm.WithRingSize(256 << 20))).
+//		AddSink("warehouse", newSink).
+//		Run()
+//
+// The engine asks for it with x-planx-data-plane: shm and maps the
+// segment named in x-planx-data-plane-params with Dial. It must run as
+// the plugin's user to open the segment, which is shared as a
+// /proc/<pid>/fd path. Segments exist on Linux only; elsewhere opening a
+// session on the plane fails.
+package shm
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/sdk"
+)
+
+// Name is the data plane name the engine offers.
+const Name = "shm"
+
+const (
+	defaultRingSize = 64 << 20
+	defaultTimeout  = 10 * time.Second
+)
+
+// Option configures a Plane.
+type Option func(*Plane)
+
+// WithRingSize sets the size of each ring, rounded up to a whole page;
+// the largest packed batch a session can move is a little smaller. A
+// segment holds two rings, and its pages are only allocated as they are
+// used. The default is 64 MiB.
+func WithRingSize(n int) Option {
+	return func(p *Plane) {
+		page := os.Getpagesize()
+		p.ringSize = (max(n, page) + page - 1) / page * page
+	}
+}
+
+// WithPutTimeout sets how long a batch waits for the engine to release
+// space in a full ring before ErrRingFull. The default is 10 seconds.
+func WithPutTimeout(d time.Duration) Option {
+	return func(p *Plane) { p.timeout = d }
+}
+
+// Plane is the plugin side of the shared-memory data plane.
+type Plane struct {
+	ringSize int
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*segment
+}
+
+var (
+	_ sdk.DataPlane          = (*Plane)(nil)
+	_ sdk.DataPlaneDiscarder = (*Plane)(nil)
+)
+
+// New returns a shared-memory data plane, to register with
+// Plugin.WithDataPlane.
+func New(opts ...Option) *Plane {
+	p := &Plane{ringSize: defaultRingSize, timeout: defaultTimeout, sessions: make(map[string]*segment)}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+func (p *Plane) Name() string { return Name }
+
+// Open creates the segment of a session. Its parameters name the segment
+// and the ring size, e.g. "path=/proc/4242/fd/9&ring_size=67108864".
+func (p *Plane) Open(_ context.Context, sessionID string) (string, error) {
+	f, err := createSegment(2 * p.ringSize)
+	if err != nil {
+		return "", err
+	}
+	seg, err := mapSegment(f, p.ringSize, false)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	p.mu.Lock()
+	p.sessions[sessionID] = seg
+	p.mu.Unlock()
+	return url.Values{
+		"path":      {segmentPath(f)},
+		"ring_size": {strconv.Itoa(p.ringSize)},
+	}.Encode(), nil
+}
+
+func (p *Plane) segment(sessionID string) (*segment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seg, ok := p.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("shm: no segment for session %s", sessionID)
+	}
+	return seg, nil
+}
+
+func (p *Plane) Put(sessionID string, packed []byte) ([]byte, error) {
+	seg, err := p.segment(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return seg.out.put(packed, p.timeout)
+}
+
+// Get returns the batch in place, in the segment.
+func (p *Plane) Get(sessionID string, ref []byte) ([]byte, error) {
+	seg, err := p.segment(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return seg.in.get(ref)
+}
+
+func (p *Plane) Release(sessionID string, ref []byte) {
+	if seg, err := p.segment(sessionID); err == nil {
+		seg.in.release(ref)
+	}
+}
+
+// Discard frees the entry of a batch Put returned that the engine was
+// never sent.
+func (p *Plane) Discard(sessionID string, ref []byte) {
+	if seg, err := p.segment(sessionID); err == nil {
+		seg.out.release(ref)
+	}
+}
+
+func (p *Plane) Close(sessionID string) error {
+	p.mu.Lock()
+	seg, ok := p.sessions[sessionID]
+	delete(p.sessions, sessionID)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return seg.close()
+}
+
+// segment is the shared memory of a session: the ring the plugin writes
+// to the engine, then the one the engine writes to the plugin.
+type segment struct {
+	f       *os.File
+	mem     []byte
+	out, in *ring
+}
+
+func mapSegment(f *os.File, ringSize int, engine bool) (*segment, error) {
+	mem, err := mapFile(f, 2*ringSize)
+	if err != nil {
+		return nil, err
+	}
+	toEngine, toPlugin := &ring{mem: mem[:ringSize]}, &ring{mem: mem[ringSize:]}
+	seg := &segment{f: f, mem: mem, out: toEngine, in: toPlugin}
+	if engine {
+		seg.out, seg.in = toPlugin, toEngine
+	}
+	return seg, nil
+}
+
+func (s *segment) close() error {
+	err := unmap(s.mem)
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Conn is the engine side of a session's segment, for engines written in
+// Go and for planx-engine-sim.
+type Conn struct {
+	seg     *segment
+	timeout time.Duration
+}
+
+// Dial maps the segment described by the x-planx-data-plane-params a
+// session was created with.
+func Dial(params string) (*Conn, error) {
+	q, err := url.ParseQuery(params)
+	if err != nil {
+		return nil, fmt.Errorf("shm: params: %w", err)
+	}
+	size, err := strconv.Atoi(q.Get("ring_size"))
+	if err != nil || size <= 0 || size%headerSize != 0 {
+		return nil, fmt.Errorf("shm: invalid ring_size %q", q.Get("ring_size"))
+	}
+	f, err := os.OpenFile(q.Get("path"), os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("shm: %w", err)
+	}
+	seg, err := mapSegment(f, size, true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Conn{seg: seg, timeout: defaultTimeout}, nil
+}
+
+// Put copies a packed batch for the plugin into the segment and returns
+// the reference to send in a Batch message.
+func (c *Conn) Put(packed []byte) ([]byte, error) {
+	return c.seg.out.put(packed, c.timeout)
+}
+
+// Get returns the packed batch a Batch message from the plugin refers
+// to, in place; it stays valid until Release.
+func (c *Conn) Get(ref []byte) ([]byte, error) {
+	return c.seg.in.get(ref)
+}
+
+// Release hands the space of a batch Get returned back to the plugin.
+func (c *Conn) Release(ref []byte) {
+	c.seg.in.release(ref)
+}
+
+// Close unmaps the segment.
+func (c *Conn) Close() error {
+	return c.seg.close()
+}
@@ -4,6 +4,6 @@ import "context"
 
 type SinkSPI interface {
 	Init(ctx context.Context, config []byte) error
-	WriteBatch(batch Batch) error
+	WriteBatch(ctx context.Context, batch *Batch) error
 	Close() error
 }
@@ -4,6 +4,6 @@ import "context"
 
 type SourceSPI interface {
 	Init(ctx context.Context, config []byte) error
-	ReadBatch() (Batch, error)
+	ReadBatch(ctx context.Context) (*Batch, error)
 	Close() error
 }
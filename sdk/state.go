@@ -0,0 +1,6 @@
+package sdk
+
+import "github.com/planx-lab/planx-sdk-go/sdk/state"
+
+// StateStore is a session's key/value store, see package state.
+type StateStore = state.Store
@@ -0,0 +1,214 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change is one write to a store, as recorded in a Changelog.
+type Change struct {
+	Key   string
+	Value []byte
+	// Deleted is set if the entry was deleted.
+	Deleted bool
+	// Expires is when an entry written with PutTTL expires; zero for
+	// those written with Put, which take the TTL of the backend they are
+	// rebuilt into.
+	Expires time.Time
+}
+
+// Changelog receives every change to the stores of sessions that ask
+// for it, so their state can be rebuilt after the backend lost it, e.g.
+// with the local disk of a file backend. Implementations other than
+// FileChangelog can mirror changes to a compacted topic or a designated
+// sink; they are safe for concurrent use.
+type Changelog interface {
+	// Append records a change to namespace. The store is changed first,
+	// so a failed append leaves the changelog behind the store.
+	Append(namespace string, c Change) error
+	// Replay calls fn with the changes of namespace in order, or with
+	// only the last one per key, until fn returns false.
+	Replay(namespace string, fn func(Change) bool) error
+	Close() error
+}
+
+// OpenChangelog returns the changelog described by spec:
+//
+//	"file:<dir>"  one compacted log file per namespace in dir
+func OpenChangelog(spec string) (Changelog, error) {
+	if dir, ok := strings.CutPrefix(spec, "file:"); ok {
+		return FileChangelog(dir)
+	}
+	return nil, fmt.Errorf("state: unknown changelog %q", spec)
+}
+
+type fileChangelog struct {
+	b *fileBackend
+
+	mu   sync.Mutex
+	open map[string]Store
+}
+
+// FileChangelog returns a changelog kept in dir, in the format of the
+// file backend: a log per namespace, compacted as it grows. dir should
+// not be on the storage the changelog protects.
+func FileChangelog(dir string) (Changelog, error) {
+	b, err := Dir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &fileChangelog{b: b.(*fileBackend), open: make(map[string]Store)}, nil
+}
+
+func (l *fileChangelog) store(namespace string) (Store, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.open[namespace]; ok {
+		return s, nil
+	}
+	s, err := l.b.Open(namespace)
+	if err != nil {
+		return nil, err
+	}
+	l.open[namespace] = s
+	return s, nil
+}
+
+func (l *fileChangelog) Append(namespace string, c Change) error {
+	s, err := l.store(namespace)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.Deleted:
+		return s.Delete(c.Key)
+	case c.Expires.IsZero():
+		return s.Put(c.Key, c.Value)
+	}
+	// An entry that already expired is written to expire at once.
+	return PutTTL(s, c.Key, c.Value, max(time.Until(c.Expires), time.Nanosecond))
+}
+
+func (l *fileChangelog) Replay(namespace string, fn func(Change) bool) error {
+	s, err := l.store(namespace)
+	if err != nil {
+		return err
+	}
+	fs := s.(*fileHandle).fileStore
+	fs.mu.RLock()
+	if fs.f == nil {
+		fs.mu.RUnlock()
+		return ErrClosed
+	}
+	now := time.Now().UnixNano()
+	changes := make([]Change, 0, len(fs.m))
+	for _, k := range slices.Sorted(maps.Keys(fs.m)) {
+		c := Change{Key: k, Value: slices.Clone(fs.m[k])}
+		if exp := fs.exp[k]; exp != 0 {
+			if expired(exp, now) {
+				continue
+			}
+			c.Expires = time.Unix(0, exp)
+		}
+		changes = append(changes, c)
+	}
+	fs.mu.RUnlock()
+	for _, c := range changes {
+		if !fn(c) {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *fileChangelog) Close() error {
+	return l.b.Close()
+}
+
+// loggedStore mirrors the changes to a store to a changelog.
+type loggedStore struct {
+	Store
+	namespace string
+	log       Changelog
+}
+
+// Logged returns s, the store of namespace, appending its changes to
+// log.
+func Logged(s Store, namespace string, log Changelog) Store {
+	return &loggedStore{Store: s, namespace: namespace, log: log}
+}
+
+func (s *loggedStore) Put(key string, value []byte) error {
+	if err := s.Store.Put(key, value); err != nil {
+		return err
+	}
+	return s.append(Change{Key: key, Value: value})
+}
+
+func (s *loggedStore) PutTTL(key string, value []byte, ttl time.Duration) error {
+	if err := PutTTL(s.Store, key, value, ttl); err != nil {
+		return err
+	}
+	c := Change{Key: key, Value: value}
+	if ttl > 0 {
+		c.Expires = time.Now().Add(ttl)
+	}
+	return s.append(c)
+}
+
+func (s *loggedStore) Expiry(key string) (time.Time, error) {
+	return Expiry(s.Store, key)
+}
+
+func (s *loggedStore) Delete(key string) error {
+	if err := s.Store.Delete(key); err != nil {
+		return err
+	}
+	return s.append(Change{Key: key, Deleted: true})
+}
+
+func (s *loggedStore) append(c Change) error {
+	if err := s.log.Append(s.namespace, c); err != nil {
+		return fmt.Errorf("state: changelog: %w", err)
+	}
+	return nil
+}
+
+// Rebuild replays the changelog of namespace into s if s is empty, as
+// after its backend lost it, and returns the number of entries restored.
+// A store holding state is left as it is.
+func Rebuild(s Store, namespace string, log Changelog) (int, error) {
+	empty := true
+	if err := s.Range("", func(string, []byte) bool {
+		empty = false
+		return false
+	}); err != nil || !empty {
+		return 0, err
+	}
+	var (
+		n      int
+		putErr error
+	)
+	err := log.Replay(namespace, func(c Change) bool {
+		switch {
+		case c.Deleted:
+			putErr = s.Delete(c.Key)
+		case c.Expires.IsZero():
+			putErr = s.Put(c.Key, c.Value)
+		case time.Until(c.Expires) > 0:
+			putErr = PutTTL(s, c.Key, c.Value, time.Until(c.Expires))
+		default:
+			return true
+		}
+		if putErr == nil && !c.Deleted {
+			n++
+		}
+		return putErr == nil
+	})
+	return n, errors.Join(err, putErr)
+}
@@ -0,0 +1,509 @@
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log records are an op byte, the uvarint-prefixed key and, for puts,
+// the uvarint-prefixed value, followed by the little-endian CRC-32 of
+// all of it. Puts of entries that expire also carry the expiry, in
+// little-endian Unix nanoseconds, before the CRC.
+const (
+	opPut        byte = 1
+	opDelete     byte = 2
+	opPutExpires byte = 3
+)
+
+// compactMin is the number of log records below which a log is never
+// compacted.
+const compactMin = 1024
+
+type fileBackend struct {
+	dir    string
+	policy policy
+
+	gc          gcLoop
+	expired     atomic.Int64
+	compactions atomic.Int64
+
+	mu     sync.Mutex
+	open   map[string]*fileStore
+	closed bool
+}
+
+// Dir returns an embedded backend storing each namespace as an
+// append-only log file in dir, created if needed. The live entries of
+// an open namespace are held in memory. Logs are compacted when opened
+// and, like expired entries, in the background while open.
+//
+// The dir is not locked: no two processes may have a namespace open at
+// once, which across a handoff means the old process closes a session
+// before the new one restores it.
+func Dir(dir string, opts ...Option) (Backend, error) {
+	if dir == "" {
+		return nil, errors.New("state: file backend needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("state: %w", err)
+	}
+	return &fileBackend{dir: dir, policy: newPolicy(opts), open: make(map[string]*fileStore)}, nil
+}
+
+func (b *fileBackend) path(namespace string) string {
+	return filepath.Join(b.dir, url.PathEscape(namespace)+".log")
+}
+
+// Open returns the namespace's store. Opening an open namespace again
+// shares the store; it is closed with its last user, each of which
+// releases it once however often it calls Close.
+func (b *fileBackend) Open(namespace string) (Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if s, ok := b.open[namespace]; ok {
+		s.refs++
+		return &fileHandle{fileStore: s}, nil
+	}
+	s, err := openFileStore(b, b.path(namespace))
+	if err != nil {
+		return nil, err
+	}
+	b.gc.start(b.policy.gcInterval, b.sweep)
+	s.namespace, s.refs = namespace, 1
+	b.open[namespace] = s
+	return &fileHandle{fileStore: s}, nil
+}
+
+func (b *fileBackend) Drop(namespace string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.open[namespace]; ok {
+		return fmt.Errorf("state: namespace %q is open", namespace)
+	}
+	if err := os.Remove(b.path(namespace)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("state: %w", err)
+	}
+	return nil
+}
+
+func (b *fileBackend) Close() error {
+	b.gc.close()
+	b.mu.Lock()
+	stores := slices.Collect(maps.Values(b.open))
+	b.open = nil
+	b.closed = true
+	b.mu.Unlock()
+
+	var errs []error
+	for _, s := range stores {
+		errs = append(errs, s.close())
+	}
+	return errors.Join(errs...)
+}
+
+func (b *fileBackend) Stats() Stats {
+	b.mu.Lock()
+	stores := slices.Collect(maps.Values(b.open))
+	b.mu.Unlock()
+	st := Stats{Expired: b.expired.Load(), Compactions: b.compactions.Load()}
+	for _, s := range stores {
+		s.mu.RLock()
+		st.Entries += int64(len(s.m))
+		st.Bytes += s.size
+		s.mu.RUnlock()
+	}
+	return st
+}
+
+// sweep removes the expired entries of the open namespaces and compacts
+// their logs if needed. A failed compaction leaves the log as it was and
+// is retried on the next sweep.
+func (b *fileBackend) sweep() {
+	b.mu.Lock()
+	stores := slices.Collect(maps.Values(b.open))
+	b.mu.Unlock()
+	now := time.Now().UnixNano()
+	for _, s := range stores {
+		s.mu.Lock()
+		if s.f != nil {
+			// The log needs no delete records: replaying it drops
+			// expired entries again.
+			for k, exp := range s.exp {
+				if expired(exp, now) {
+					delete(s.m, k)
+					delete(s.exp, k)
+					b.expired.Add(1)
+				}
+			}
+			if s.bloated() && s.compact() == nil {
+				b.compactions.Add(1)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+type fileStore struct {
+	b         *fileBackend
+	path      string
+	namespace string
+	// refs counts the open handles of the store; it is guarded by b.mu.
+	refs int
+
+	mu sync.RWMutex
+	f  *os.File
+	w  *bufio.Writer
+	m  map[string][]byte
+	// exp holds when the entries that expire do, in Unix nanoseconds.
+	exp     map[string]int64
+	records int
+	size    int64
+}
+
+func openFileStore(b *fileBackend, path string) (*fileStore, error) {
+	s := &fileStore{b: b, path: path, m: make(map[string][]byte), exp: make(map[string]int64)}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("state: %w", err)
+	}
+	valid, err := s.replay(f)
+	if err == nil {
+		// Drop a torn record left by a crash mid-write.
+		err = f.Truncate(valid)
+	}
+	if err == nil {
+		_, err = f.Seek(valid, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("state: open %s: %w", path, err)
+	}
+	s.f, s.w = f, bufio.NewWriter(f)
+
+	if s.bloated() {
+		if err := s.compact(); err != nil {
+			s.f.Close()
+			return nil, err
+		}
+		b.compactions.Add(1)
+	}
+	return s, nil
+}
+
+// bloated reports whether the log holds enough dead records to be
+// compacted.
+func (s *fileStore) bloated() bool {
+	return s.records >= compactMin && float64(s.records) > s.b.policy.compactRatio*float64(len(s.m))
+}
+
+// replay loads the log into memory and returns the length of its valid
+// prefix.
+func (s *fileStore) replay(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+	now := time.Now().UnixNano()
+	for {
+		op, key, value, exp, n, err := readRecord(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errCorrupt) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		s.apply(op, key, value, exp, n)
+	}
+	// Entries that expired while the namespace was closed.
+	for k, exp := range s.exp {
+		if expired(exp, now) {
+			delete(s.m, k)
+			delete(s.exp, k)
+			s.b.expired.Add(1)
+		}
+	}
+	return s.size, nil
+}
+
+func (s *fileStore) apply(op byte, key string, value []byte, exp int64, n int) {
+	s.records++
+	s.size += int64(n)
+	switch op {
+	case opPut, opPutExpires:
+		s.m[key] = value
+		if exp != 0 {
+			s.exp[key] = exp
+		} else {
+			delete(s.exp, key)
+		}
+	default:
+		delete(s.m, key)
+		delete(s.exp, key)
+	}
+}
+
+var errCorrupt = errors.New("corrupt record")
+
+func readRecord(r *bufio.Reader) (op byte, key string, value []byte, exp int64, n int, err error) {
+	h := crc32.NewIEEE()
+	tr := io.TeeReader(r, h)
+	var hdr [1]byte
+	if _, err = io.ReadFull(tr, hdr[:]); err != nil {
+		return
+	}
+	op = hdr[0]
+	if op != opPut && op != opDelete && op != opPutExpires {
+		err = errCorrupt
+		return
+	}
+	k, kn, err := readBytes(tr)
+	if err != nil {
+		return
+	}
+	n = 1 + kn
+	if op != opDelete {
+		var vn int
+		if value, vn, err = readBytes(tr); err != nil {
+			return
+		}
+		n += vn
+	}
+	if op == opPutExpires {
+		var e [8]byte
+		if _, err = io.ReadFull(tr, e[:]); err != nil {
+			return
+		}
+		exp = int64(binary.LittleEndian.Uint64(e[:]))
+		n += 8
+	}
+	sum := h.Sum32()
+	var crc [4]byte
+	if _, err = io.ReadFull(r, crc[:]); err != nil {
+		return
+	}
+	if binary.LittleEndian.Uint32(crc[:]) != sum {
+		err = errCorrupt
+		return
+	}
+	return op, string(k), value, exp, n + 4, nil
+}
+
+func readBytes(r io.Reader) ([]byte, int, error) {
+	br := byteReader{r: r}
+	l, err := binary.ReadUvarint(&br)
+	if err != nil {
+		return nil, 0, err
+	}
+	if l > 1<<30 {
+		return nil, 0, errCorrupt
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, 0, err
+	}
+	return b, br.n + int(l), nil
+}
+
+type byteReader struct {
+	r io.Reader
+	n int
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	var c [1]byte
+	if _, err := io.ReadFull(b.r, c[:]); err != nil {
+		return 0, err
+	}
+	b.n++
+	return c[0], nil
+}
+
+// appendRecord appends a delete record, or a put record expiring at exp
+// unless it is zero.
+func appendRecord(buf []byte, op byte, key string, value []byte, exp int64) []byte {
+	start := len(buf)
+	if op == opPut && exp != 0 {
+		op = opPutExpires
+	}
+	buf = append(buf, op)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	if op != opDelete {
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+	}
+	if op == opPutExpires {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(exp))
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+}
+
+// write appends a record; the caller holds s.mu.
+func (s *fileStore) write(op byte, key string, value []byte, exp int64) error {
+	if s.f == nil {
+		return ErrClosed
+	}
+	rec := appendRecord(nil, op, key, value, exp)
+	if _, err := s.w.Write(rec); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	s.apply(op, key, value, exp, len(rec))
+	return nil
+}
+
+// compact rewrites the log with only the live entries; the caller holds
+// s.mu or has not shared s yet.
+func (s *fileStore) compact() error {
+	tmp := s.path + ".compact"
+	var buf []byte
+	for _, k := range slices.Sorted(maps.Keys(s.m)) {
+		buf = appendRecord(buf, opPut, k, s.m[k], s.exp[k])
+	}
+	if err := writeFileSync(tmp, buf); err != nil {
+		return fmt.Errorf("state: compact %s: %w", s.path, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("state: compact %s: %w", s.path, err)
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("state: compact %s: %w", s.path, err)
+	}
+	s.f.Close()
+	s.f, s.w = f, bufio.NewWriter(f)
+	s.records = len(s.m)
+	s.size = int64(len(buf))
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	return errors.Join(err, f.Close())
+}
+
+func (s *fileStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.f == nil {
+		return nil, false, ErrClosed
+	}
+	if expired(s.exp[key], time.Now().UnixNano()) {
+		return nil, false, nil
+	}
+	v, ok := s.m[key]
+	return slices.Clone(v), ok, nil
+}
+
+func (s *fileStore) Put(key string, value []byte) error {
+	return s.PutTTL(key, value, s.b.policy.ttl)
+}
+
+func (s *fileStore) PutTTL(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(opPut, key, slices.Clone(value), expiry(ttl))
+}
+
+func (s *fileStore) Expiry(key string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.f == nil {
+		return time.Time{}, ErrClosed
+	}
+	if exp := s.exp[key]; exp != 0 {
+		return time.Unix(0, exp), nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *fileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; !ok {
+		return nil
+	}
+	return s.write(opDelete, key, nil, 0)
+}
+
+func (s *fileStore) Range(prefix string, fn func(string, []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	now := time.Now().UnixNano()
+	for _, k := range slices.Sorted(maps.Keys(s.m)) {
+		if !strings.HasPrefix(k, prefix) || expired(s.exp[k], now) {
+			continue
+		}
+		if !fn(k, slices.Clone(s.m[k])) {
+			break
+		}
+	}
+	return nil
+}
+
+// fileHandle is what Open returns: one user's reference to a shared
+// store, which it gives up once however often Close is called.
+type fileHandle struct {
+	*fileStore
+	closed atomic.Bool
+}
+
+func (h *fileHandle) Close() error {
+	if !h.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return h.unref()
+}
+
+// unref drops a handle of the store, closing the store with the last one.
+func (s *fileStore) unref() error {
+	b := s.b
+	b.mu.Lock()
+	s.refs--
+	last := s.refs == 0
+	if last && b.open[s.namespace] == s {
+		delete(b.open, s.namespace)
+	}
+	b.mu.Unlock()
+	if !last {
+		return nil
+	}
+	return s.close()
+}
+
+// close syncs and closes the log file.
+func (s *fileStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := errors.Join(s.w.Flush(), s.f.Sync(), s.f.Close())
+	s.f = nil
+	return err
+}
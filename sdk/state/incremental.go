@@ -0,0 +1,166 @@
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// deltaVersion is the first byte of a delta, followed by when the delta
+// was taken in uvarint Unix nanoseconds. Its entries are an op byte, the
+// uvarint-prefixed key and, for puts, the uvarint-prefixed value and the
+// expiry as in a snapshot. Version 3 deltas have no time and version 2
+// puts no expiry either.
+const deltaVersion = 4
+
+const (
+	deltaPut    byte = 1
+	deltaDelete byte = 2
+)
+
+// Tracker is a Store that records which keys are written, so a snapshot
+// can hold only the changes since the one before.
+type Tracker struct {
+	Store
+
+	mu    sync.Mutex
+	dirty map[string]struct{}
+}
+
+// Track returns s recording its changes.
+func Track(s Store) *Tracker {
+	return &Tracker{Store: s, dirty: make(map[string]struct{})}
+}
+
+// touch records key as changed if err, that of writing it, is nil. A
+// failed write changes nothing for a delta to hold.
+func (t *Tracker) touch(key string, err error) error {
+	if err == nil {
+		t.mu.Lock()
+		t.dirty[key] = struct{}{}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+func (t *Tracker) Put(key string, value []byte) error {
+	return t.touch(key, t.Store.Put(key, value))
+}
+
+func (t *Tracker) PutTTL(key string, value []byte, ttl time.Duration) error {
+	return t.touch(key, PutTTL(t.Store, key, value, ttl))
+}
+
+func (t *Tracker) Delete(key string) error {
+	return t.touch(key, t.Store.Delete(key))
+}
+
+// Reset forgets the changes recorded so far.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	t.dirty = make(map[string]struct{})
+	t.mu.Unlock()
+}
+
+// Delta returns the entries changed since the last call to Delta or
+// Reset, encoded for Merge, and starts recording anew. Entries deleted
+// since are recorded as deleted. So are expired ones: the delta holds
+// when it was taken, and Merge drops the entries expired by then,
+// including those written before the last delta.
+func (t *Tracker) Delta() ([]byte, error) {
+	t.mu.Lock()
+	dirty := t.dirty
+	t.dirty = make(map[string]struct{})
+	t.mu.Unlock()
+
+	buf := binary.AppendUvarint([]byte{deltaVersion}, uint64(time.Now().UnixNano()))
+	for _, k := range slices.Sorted(maps.Keys(dirty)) {
+		v, ok, err := t.Store.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			buf = append(buf, deltaDelete)
+			buf = binary.AppendUvarint(buf, uint64(len(k)))
+			buf = append(buf, k...)
+			continue
+		}
+		exp, err := Expiry(t.Store, k)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendEntry(append(buf, deltaPut), k, entry{v, unixNano(exp)})
+	}
+	return buf, nil
+}
+
+// Expiry returns when the entry of key expires; see ExpiryReader.
+func (t *Tracker) Expiry(key string) (time.Time, error) {
+	return Expiry(t.Store, key)
+}
+
+// Merge applies deltas, oldest first, to a snapshot and returns the
+// snapshot of the result.
+func Merge(snapshot []byte, deltas ...[]byte) ([]byte, error) {
+	entries, err := decodeSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deltas {
+		if err := applyDelta(entries, d); err != nil {
+			return nil, err
+		}
+	}
+	buf := []byte{snapshotVersion}
+	for _, k := range slices.Sorted(maps.Keys(entries)) {
+		buf = appendEntry(buf, k, entries[k])
+	}
+	return buf, nil
+}
+
+func applyDelta(entries map[string]entry, b []byte) error {
+	if len(b) == 0 || b[0] < 2 || b[0] > deltaVersion {
+		if len(b) > 0 {
+			return fmt.Errorf("state: unknown delta version %d", b[0])
+		}
+		return errBadSnapshot
+	}
+	version := b[0]
+	r := entryReader(b[1:])
+	if version >= 4 {
+		taken, ok := r.uvarint()
+		if !ok {
+			return errBadSnapshot
+		}
+		for k, e := range entries {
+			if expired(e.exp, int64(taken)) {
+				delete(entries, k)
+			}
+		}
+	}
+	withExpiry := version >= 3
+	for len(r) > 0 {
+		op := r[0]
+		r = r[1:]
+		k, ok := r.bytes()
+		if !ok {
+			return errBadSnapshot
+		}
+		switch op {
+		case deltaPut:
+			e, ok := r.entry(withExpiry)
+			if !ok {
+				return errBadSnapshot
+			}
+			entries[string(k)] = e
+		case deltaDelete:
+			delete(entries, string(k))
+		default:
+			return errBadSnapshot
+		}
+	}
+	return nil
+}
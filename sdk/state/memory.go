@@ -0,0 +1,158 @@
+package state
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type memoryBackend struct {
+	policy  policy
+	gc      gcLoop
+	expired atomic.Int64
+
+	mu     sync.Mutex
+	spaces map[string]*memoryStore
+}
+
+// Memory returns a backend keeping state in the plugin process. State
+// survives sessions but not the process. Expired entries are removed in
+// the background.
+func Memory(opts ...Option) Backend {
+	return &memoryBackend{policy: newPolicy(opts), spaces: make(map[string]*memoryStore)}
+}
+
+func (b *memoryBackend) Open(namespace string) (Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spaces == nil {
+		return nil, ErrClosed
+	}
+	s, ok := b.spaces[namespace]
+	if !ok {
+		s = &memoryStore{b: b, m: make(map[string][]byte), exp: make(map[string]int64)}
+		b.spaces[namespace] = s
+	}
+	return s, nil
+}
+
+func (b *memoryBackend) Drop(namespace string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.spaces, namespace)
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	b.gc.close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spaces = nil
+	return nil
+}
+
+func (b *memoryBackend) Stats() Stats {
+	b.mu.Lock()
+	spaces := slices.Collect(maps.Values(b.spaces))
+	b.mu.Unlock()
+	st := Stats{Expired: b.expired.Load()}
+	for _, s := range spaces {
+		s.mu.RLock()
+		st.Entries += int64(len(s.m))
+		s.mu.RUnlock()
+	}
+	return st
+}
+
+// sweep removes the expired entries of every namespace.
+func (b *memoryBackend) sweep() {
+	b.mu.Lock()
+	spaces := slices.Collect(maps.Values(b.spaces))
+	b.mu.Unlock()
+	now := time.Now().UnixNano()
+	for _, s := range spaces {
+		s.mu.Lock()
+		for k, exp := range s.exp {
+			if expired(exp, now) {
+				delete(s.m, k)
+				delete(s.exp, k)
+				b.expired.Add(1)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+type memoryStore struct {
+	b *memoryBackend
+
+	mu sync.RWMutex
+	m  map[string][]byte
+	// exp holds when the entries that expire do, in Unix nanoseconds.
+	exp map[string]int64
+}
+
+func (s *memoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if expired(s.exp[key], time.Now().UnixNano()) {
+		return nil, false, nil
+	}
+	v, ok := s.m[key]
+	return slices.Clone(v), ok, nil
+}
+
+func (s *memoryStore) Put(key string, value []byte) error {
+	return s.PutTTL(key, value, s.b.policy.ttl)
+}
+
+func (s *memoryStore) PutTTL(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[key] = slices.Clone(value)
+	if exp := expiry(ttl); exp != 0 {
+		s.exp[key] = exp
+		s.b.gc.start(s.b.policy.gcInterval, s.b.sweep)
+	} else {
+		delete(s.exp, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) Expiry(key string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if exp := s.exp[key]; exp != 0 {
+		return time.Unix(0, exp), nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	delete(s.exp, key)
+	return nil
+}
+
+func (s *memoryStore) Range(prefix string, fn func(string, []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().UnixNano()
+	for _, k := range slices.Sorted(maps.Keys(s.m)) {
+		if !strings.HasPrefix(k, prefix) || expired(s.exp[k], now) {
+			continue
+		}
+		if !fn(k, slices.Clone(s.m[k])) {
+			break
+		}
+	}
+	return nil
+}
+
+// Close does nothing: the state stays in the backend until dropped.
+func (s *memoryStore) Close() error { return nil }
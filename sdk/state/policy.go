@@ -0,0 +1,195 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Option sets a retention policy of the memory and file backends.
+type Option func(*policy)
+
+type policy struct {
+	ttl          time.Duration
+	gcInterval   time.Duration
+	compactRatio float64
+}
+
+const (
+	defaultGCInterval   = time.Minute
+	defaultCompactRatio = 2
+)
+
+func newPolicy(opts []Option) policy {
+	p := policy{gcInterval: defaultGCInterval, compactRatio: defaultCompactRatio}
+	for _, o := range opts {
+		o(&p)
+	}
+	return p
+}
+
+// WithTTL expires entries d after they were last written, unless they
+// were written with PutTTL. Zero, the default, keeps entries until they
+// are deleted.
+func WithTTL(d time.Duration) Option {
+	return func(p *policy) { p.ttl = d }
+}
+
+// WithGCInterval sets how often expired entries are removed and file
+// logs compacted; the default is a minute.
+func WithGCInterval(d time.Duration) Option {
+	return func(p *policy) {
+		if d > 0 {
+			p.gcInterval = d
+		}
+	}
+}
+
+// WithCompactRatio compacts a file log once it holds more than r records
+// per live entry; the default is 2.
+func WithCompactRatio(r float64) Option {
+	return func(p *policy) {
+		if r >= 1 {
+			p.compactRatio = r
+		}
+	}
+}
+
+// parsePolicy reads the options of a backend spec's query, e.g.
+// "ttl=24h&gc_interval=5m&compact_ratio=4".
+func parsePolicy(query string) ([]Option, error) {
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("state: %w", err)
+	}
+	var opts []Option
+	for k := range params {
+		v := params.Get(k)
+		switch k {
+		case "ttl", "gc_interval":
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("state: invalid %s %q", k, v)
+			}
+			if k == "ttl" {
+				opts = append(opts, WithTTL(d))
+			} else {
+				opts = append(opts, WithGCInterval(d))
+			}
+		case "compact_ratio":
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil || r < 1 {
+				return nil, fmt.Errorf("state: invalid compact_ratio %q", v)
+			}
+			opts = append(opts, WithCompactRatio(r))
+		default:
+			return nil, fmt.Errorf("state: unknown option %q", k)
+		}
+	}
+	return opts, nil
+}
+
+// expiry returns when an entry written now with ttl expires, or zero if
+// it does not.
+func expiry(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+func expired(exp, now int64) bool {
+	return exp != 0 && exp <= now
+}
+
+// Expirer is implemented by stores whose entries can expire.
+type Expirer interface {
+	// PutTTL writes an entry that expires ttl from now. A ttl of zero or
+	// less never expires, overriding the backend's default TTL.
+	PutTTL(key string, value []byte, ttl time.Duration) error
+}
+
+// PutTTL writes an entry of s that expires ttl from now, or returns
+// errors.ErrUnsupported if s cannot expire entries.
+func PutTTL(s Store, key string, value []byte, ttl time.Duration) error {
+	if e, ok := s.(Expirer); ok {
+		return e.PutTTL(key, value, ttl)
+	}
+	return errors.ErrUnsupported
+}
+
+// ExpiryReader is implemented by stores that tell when their entries
+// expire, for snapshots to keep it.
+type ExpiryReader interface {
+	// Expiry returns when the entry of key expires, or the zero time if
+	// it does not or is not there.
+	Expiry(key string) (time.Time, error)
+}
+
+// Expiry returns when the entry of key in s expires, or the zero time if
+// it does not or s cannot tell.
+func Expiry(s Store, key string) (time.Time, error) {
+	if e, ok := s.(ExpiryReader); ok {
+		return e.Expiry(key)
+	}
+	return time.Time{}, nil
+}
+
+// Stats describes the state a backend holds and its upkeep.
+type Stats struct {
+	// Entries are the live entries of the namespaces in memory: all of
+	// them for the memory backend, the open ones for the file backend.
+	Entries int64
+	// Bytes is the size of the file logs of open namespaces.
+	Bytes int64
+	// Expired and Compactions count the entries removed after their TTL
+	// and the logs compacted since the backend was created.
+	Expired     int64
+	Compactions int64
+}
+
+// Reporter is implemented by backends that report Stats.
+type Reporter interface {
+	Stats() Stats
+}
+
+// gcLoop runs a backend's garbage collection in the background. It is
+// only started once there is something to collect, so a backend without
+// expiring entries or logs needs no goroutine.
+type gcLoop struct {
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (g *gcLoop) start(interval time.Duration, fn func()) {
+	g.once.Do(func() {
+		g.stop, g.done = make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(g.done)
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					fn()
+				case <-g.stop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// close stops the loop, if it was started, and waits for it to exit.
+func (g *gcLoop) close() {
+	g.once.Do(func() {})
+	if g.stop != nil {
+		close(g.stop)
+		<-g.done
+		g.stop = nil
+	}
+}
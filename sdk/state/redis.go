@@ -0,0 +1,394 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisOptions configures a Redis backend.
+type RedisOptions struct {
+	Password string
+	DB       int
+	// Prefix is prepended to every key; it defaults to "planx:state:".
+	Prefix string
+	// PoolSize is the number of idle connections kept; it defaults to 4.
+	PoolSize    int
+	DialTimeout time.Duration
+	// IOTimeout bounds each command, from writing it to reading its
+	// reply, so a server that stops answering fails the call instead of
+	// blocking it; it defaults to 5 seconds.
+	IOTimeout time.Duration
+	// TTL expires entries written with Put that long after they were
+	// written; Redis removes them. Zero keeps entries until deleted.
+	TTL time.Duration
+}
+
+type redisBackend struct {
+	addr string
+	opts RedisOptions
+	pool chan *redisConn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Redis returns a backend storing each entry as a Redis string under
+// Prefix + namespace + "/" + key, so state outlives the plugin host.
+func Redis(addr string, opts RedisOptions) Backend {
+	if opts.Prefix == "" {
+		opts.Prefix = "planx:state:"
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = 5 * time.Second
+	}
+	return &redisBackend{addr: addr, opts: opts, pool: make(chan *redisConn, opts.PoolSize)}
+}
+
+// RedisURL returns a Redis backend for
+// "redis://[:password@]host:port[/db][?ttl=<duration>]".
+func RedisURL(raw string) (Backend, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("state: bad redis url %q", raw)
+	}
+	var opts RedisOptions
+	if p, ok := u.User.Password(); ok {
+		opts.Password = p
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("state: bad redis database %q", db)
+		}
+	}
+	for k, v := range u.Query() {
+		if k != "ttl" {
+			return nil, fmt.Errorf("state: unknown redis option %q", k)
+		}
+		if opts.TTL, err = time.ParseDuration(v[0]); err != nil || opts.TTL < 0 {
+			return nil, fmt.Errorf("state: invalid ttl %q", v[0])
+		}
+	}
+	return Redis(u.Host, opts), nil
+}
+
+func (b *redisBackend) Open(namespace string) (Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	return &redisStore{b: b, prefix: b.opts.Prefix + url.PathEscape(namespace) + "/"}, nil
+}
+
+func (b *redisBackend) Drop(namespace string) error {
+	prefix := b.opts.Prefix + url.PathEscape(namespace) + "/"
+	keys, err := b.scan(prefix)
+	if err != nil {
+		return err
+	}
+	for chunk := range slices.Chunk(keys, 500) {
+		if _, err := b.do(append([]string{"DEL"}, chunk...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *redisBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.pool)
+	for c := range b.pool {
+		c.conn.Close()
+	}
+	return nil
+}
+
+// do runs one command on a pooled connection. Connections are discarded
+// after a network or protocol error.
+func (b *redisBackend) do(args ...string) (any, error) {
+	c, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(b.opts.IOTimeout))
+	reply, err := c.do(args)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.conn.Close()
+		return nil, fmt.Errorf("state: redis %s: %w", args[0], err)
+	}
+	b.put(c)
+	if err != nil {
+		return nil, fmt.Errorf("state: redis %s: %w", args[0], err)
+	}
+	return reply, nil
+}
+
+// get returns a pooled connection or dials one. A connection dialed
+// while the backend closes is closed rather than handed out.
+func (b *redisBackend) get() (*redisConn, error) {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	select {
+	case c, ok := <-b.pool:
+		if !ok {
+			// Close drained the pool after the check above.
+			return nil, ErrClosed
+		}
+		return c, nil
+	default:
+	}
+	c, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	closed = b.closed
+	b.mu.Unlock()
+	if closed {
+		c.conn.Close()
+		return nil, ErrClosed
+	}
+	return c, nil
+}
+
+func (b *redisBackend) put(c *redisConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		select {
+		case b.pool <- c:
+			return
+		default:
+		}
+	}
+	c.conn.Close()
+}
+
+func (b *redisBackend) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, b.opts.DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("state: redis: %w", err)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(b.opts.IOTimeout))
+	if b.opts.Password != "" {
+		if _, err := c.do([]string{"AUTH", b.opts.Password}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("state: redis AUTH: %w", err)
+		}
+	}
+	if b.opts.DB != 0 {
+		if _, err := c.do([]string{"SELECT", strconv.Itoa(b.opts.DB)}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("state: redis SELECT: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// scan returns the keys starting with prefix, sorted.
+func (b *redisBackend) scan(prefix string) ([]string, error) {
+	pattern := globEscape(prefix) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := b.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("state: redis SCAN: unexpected reply %v", reply)
+		}
+		next, _ := page[0].([]byte)
+		items, _ := page[1].([]any)
+		for _, it := range items {
+			if k, ok := it.([]byte); ok {
+				keys = append(keys, string(k))
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
+
+func globEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+type redisStore struct {
+	b      *redisBackend
+	prefix string
+}
+
+func (s *redisStore) Get(key string) ([]byte, bool, error) {
+	reply, err := s.b.do("GET", s.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	v, ok := reply.([]byte)
+	return v, ok, nil
+}
+
+func (s *redisStore) Put(key string, value []byte) error {
+	return s.PutTTL(key, value, s.b.opts.TTL)
+}
+
+func (s *redisStore) PutTTL(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", s.prefix + key, string(value)}
+	if ms := ttl.Milliseconds(); ms > 0 {
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err := s.b.do(args...)
+	return err
+}
+
+// Expiry asks Redis for the time to live of the entry, which PTTL gives
+// in milliseconds, negative for keys without one or missing.
+func (s *redisStore) Expiry(key string) (time.Time, error) {
+	reply, err := s.b.do("PTTL", s.prefix+key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ms, _ := reply.(int64); ms > 0 {
+		return time.Now().Add(time.Duration(ms) * time.Millisecond), nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *redisStore) Delete(key string) error {
+	_, err := s.b.do("DEL", s.prefix+key)
+	return err
+}
+
+func (s *redisStore) Range(prefix string, fn func(string, []byte) bool) error {
+	keys, err := s.b.scan(s.prefix + prefix)
+	if err != nil {
+		return err
+	}
+	for chunk := range slices.Chunk(keys, 500) {
+		reply, err := s.b.do(append([]string{"MGET"}, chunk...)...)
+		if err != nil {
+			return err
+		}
+		values, _ := reply.([]any)
+		for i, k := range chunk {
+			// Keys deleted since the scan come back nil.
+			if i >= len(values) || values[i] == nil {
+				continue
+			}
+			if !fn(strings.TrimPrefix(k, s.prefix), values[i].([]byte)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Close does nothing: connections belong to the backend.
+func (s *redisStore) Close() error { return nil }
+
+// redisConn speaks RESP2.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func (c *redisConn) do(args []string) (any, error) {
+	var buf []byte
+	buf = fmt.Appendf(buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read returns a simple string as string, an integer as int64, a bulk
+// string as []byte, an array as []any and nil replies as nil. Error
+// replies are returned as redisError.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				var rerr redisError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
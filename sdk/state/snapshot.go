@@ -0,0 +1,161 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// snapshotVersion is the first byte of a snapshot. Version 2 entries end
+// with the uvarint expiry of the entry in Unix nanoseconds, zero if it
+// does not expire; version 1 entries have none.
+const snapshotVersion = 2
+
+// entry is a decoded snapshot entry; exp is as in the file log.
+type entry struct {
+	value []byte
+	exp   int64
+}
+
+// Snapshot returns every entry of s, encoded for Load, with when the
+// entries that expire do.
+func Snapshot(s Store) ([]byte, error) {
+	var (
+		keys   []string
+		values [][]byte
+	)
+	if err := s.Range("", func(key string, value []byte) bool {
+		keys, values = append(keys, key), append(values, value)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	buf := []byte{snapshotVersion}
+	for i, k := range keys {
+		exp, err := Expiry(s, k)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendEntry(buf, k, entry{values[i], unixNano(exp)})
+	}
+	return buf, nil
+}
+
+// appendEntry appends the uvarint-prefixed key and value, then the
+// expiry.
+func appendEntry(buf []byte, key string, e entry) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(e.value)))
+	buf = append(buf, e.value...)
+	return binary.AppendUvarint(buf, uint64(e.exp))
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// Load replaces the entries of s with those of a snapshot. Entries that
+// expire keep their expiry; those expired by now are left out.
+func Load(s Store, snapshot []byte) error {
+	entries, err := decodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	for k, e := range entries {
+		if expired(e.exp, now) {
+			delete(entries, k)
+		}
+	}
+	var stale []string
+	if err := s.Range("", func(key string, _ []byte) bool {
+		if _, ok := entries[key]; !ok {
+			stale = append(stale, key)
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := s.Delete(k); err != nil {
+			return err
+		}
+	}
+	for k, e := range entries {
+		if e.exp == 0 {
+			err = s.Put(k, e.value)
+		} else {
+			err = PutTTL(s, k, e.value, max(time.Until(time.Unix(0, e.exp)), time.Nanosecond))
+			if errors.Is(err, errors.ErrUnsupported) {
+				err = s.Put(k, e.value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errBadSnapshot = errors.New("state: malformed snapshot")
+
+// entryReader reads the fields of encoded entries.
+type entryReader []byte
+
+func (r *entryReader) bytes() ([]byte, bool) {
+	n, w := binary.Uvarint(*r)
+	if w <= 0 || uint64(len(*r)-w) < n {
+		return nil, false
+	}
+	v := (*r)[w : w+int(n)]
+	*r = (*r)[w+int(n):]
+	return v, true
+}
+
+func (r *entryReader) uvarint() (uint64, bool) {
+	n, w := binary.Uvarint(*r)
+	if w <= 0 {
+		return 0, false
+	}
+	*r = (*r)[w:]
+	return n, true
+}
+
+// entry reads a value and, if withExpiry, the expiry after it.
+func (r *entryReader) entry(withExpiry bool) (entry, bool) {
+	v, ok := r.bytes()
+	if !ok || !withExpiry {
+		return entry{value: v}, ok
+	}
+	exp, ok := r.uvarint()
+	return entry{v, int64(exp)}, ok && exp <= 1<<63-1
+}
+
+func decodeSnapshot(b []byte) (map[string]entry, error) {
+	if len(b) == 0 || b[0] != 1 && b[0] != snapshotVersion {
+		if len(b) > 0 {
+			return nil, fmt.Errorf("state: unknown snapshot version %d", b[0])
+		}
+		return nil, errBadSnapshot
+	}
+	withExpiry := b[0] >= 2
+	r := entryReader(b[1:])
+	entries := make(map[string]entry)
+	for len(r) > 0 {
+		k, ok := r.bytes()
+		if !ok {
+			return nil, errBadSnapshot
+		}
+		e, ok := r.entry(withExpiry)
+		if !ok {
+			return nil, errBadSnapshot
+		}
+		entries[string(k)] = e
+	}
+	return entries, nil
+}
@@ -0,0 +1,140 @@
+// Package state provides the key/value state of connector sessions:
+// source offsets, processor aggregates and sink dedup keys alike. Each
+// session reads and writes a Store, the namespace of a Backend named by
+// the engine in the x-planx-state-namespace metadata, so a session
+// recreated under the same namespace picks up where the last one left
+// off:
+//
+//	sc, _ := sdk.SessionFromContext(ctx)
+//	offsets := state.NewKeyed[int64](sc.State, "offsets")
+//	off, _, err := offsets.Get(partition)
+//
+// Entries can expire: backends take a default TTL, and PutTTL sets one
+// per entry, e.g. for dedup keys only needed for a day. Checkpoints keep
+// the expiry of the entries they hold.
+//
+// Sessions without a namespace get one of their own, dropped when the
+// session closes. The backend is chosen with the state_backend setting
+// (see Open) or Plugin.WithStateBackend.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrClosed is returned by the methods of a closed Store or Backend.
+var ErrClosed = errors.New("state: closed")
+
+// Store is the state of one namespace. Implementations are safe for
+// concurrent use.
+type Store interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Range calls fn with every entry whose key starts with prefix, in
+	// key order, until fn returns false. fn must not modify the store.
+	Range(prefix string, fn func(key string, value []byte) bool) error
+	// Close releases the store; its state is kept. The SDK closes the
+	// stores it hands to sessions.
+	Close() error
+}
+
+// Backend holds the stores of all namespaces.
+type Backend interface {
+	// Open returns the store of namespace, creating it if needed.
+	Open(namespace string) (Store, error)
+	// Drop deletes the state of namespace.
+	Drop(namespace string) error
+	Close() error
+}
+
+// Open returns the backend described by spec:
+//
+//	"" or "memory"                      state lives in the plugin process
+//	"file:<dir>"                        embedded store, one log file per namespace in dir
+//	"redis://[:password@]host:port[/db]" a Redis server
+//
+// The memory and file backends take their retention policy as a query,
+// e.g. "file:/var/lib/planx?ttl=24h&gc_interval=5m&compact_ratio=4" (see
+// WithTTL, WithGCInterval and WithCompactRatio); a Redis URL takes ttl
+// only, as Redis expires and compacts on its own.
+func Open(spec string) (Backend, error) {
+	if strings.HasPrefix(spec, "redis://") {
+		return RedisURL(spec)
+	}
+	spec, query, _ := strings.Cut(spec, "?")
+	opts, err := parsePolicy(query)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case spec == "" || spec == "memory":
+		return Memory(opts...), nil
+	case strings.HasPrefix(spec, "file:"):
+		return Dir(strings.TrimPrefix(spec, "file:"), opts...)
+	}
+	return nil, fmt.Errorf("state: unknown backend %q", spec)
+}
+
+// Keyed is a typed view of the entries of a Store under one name, with
+// values encoded as JSON.
+type Keyed[V any] struct {
+	store  Store
+	prefix string
+}
+
+// NewKeyed returns the entries of s named name.
+func NewKeyed[V any](s Store, name string) *Keyed[V] {
+	return &Keyed[V]{store: s, prefix: name + "/"}
+}
+
+func (k *Keyed[V]) Get(key string) (V, bool, error) {
+	var v V
+	data, ok, err := k.store.Get(k.prefix + key)
+	if err != nil || !ok {
+		return v, false, err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, false, fmt.Errorf("state: decode %s%s: %w", k.prefix, key, err)
+	}
+	return v, true, nil
+}
+
+func (k *Keyed[V]) Put(key string, v V) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("state: encode %s%s: %w", k.prefix, key, err)
+	}
+	return k.store.Put(k.prefix+key, data)
+}
+
+// PutTTL writes an entry that expires ttl from now; see Expirer.
+func (k *Keyed[V]) PutTTL(key string, v V, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("state: encode %s%s: %w", k.prefix, key, err)
+	}
+	return PutTTL(k.store, k.prefix+key, data, ttl)
+}
+
+func (k *Keyed[V]) Delete(key string) error {
+	return k.store.Delete(k.prefix + key)
+}
+
+// Range calls fn with every entry in key order until fn returns false.
+func (k *Keyed[V]) Range(fn func(key string, v V) bool) error {
+	var decodeErr error
+	err := k.store.Range(k.prefix, func(key string, data []byte) bool {
+		var v V
+		if decodeErr = json.Unmarshal(data, &v); decodeErr != nil {
+			decodeErr = fmt.Errorf("state: decode %s: %w", key, decodeErr)
+			return false
+		}
+		return fn(strings.TrimPrefix(key, k.prefix), v)
+	})
+	return errors.Join(err, decodeErr)
+}
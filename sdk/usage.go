@@ -0,0 +1,9 @@
+package sdk
+
+import "github.com/planx-lab/planx-sdk-go/internal/runtime"
+
+// TenantUsage is the traffic of one tenant across all of its sessions
+// over a period, for chargeback.
+type TenantUsage = runtime.TenantUsage
+
+type TrafficStats = runtime.TrafficStats
@@ -0,0 +1,21 @@
+package sdk
+
+import "github.com/planx-lab/planx-sdk-go/internal/runtime"
+
+// Build metadata that can be stamped at link time, e.g.
+//
+//	go build -ldflags "-X github.com/planx-lab/planx-sdk-go/sdk.BuildCommit=$(git rev-parse HEAD)"
+//
+// When left empty the values recorded by the Go toolchain are used.
+var (
+	BuildVersion string
+	BuildCommit  string
+	BuildTime    string
+)
+
+type BuildInfo = runtime.BuildInfo
+
+// ReadBuildInfo reports the plugin and SDK build the process is running.
+func ReadBuildInfo() BuildInfo {
+	return runtime.ReadBuildInfo(BuildVersion, BuildCommit, BuildTime)
+}
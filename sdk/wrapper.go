@@ -0,0 +1,236 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+
+	"github.com/planx-lab/planx-sdk-go/internal/runtime"
+	"github.com/planx-lab/planx-sdk-go/internal/session"
+	"github.com/planx-lab/planx-sdk-go/sdk/state"
+)
+
+type lifecycle interface {
+	Init(ctx context.Context, config []byte) error
+	Close() error
+}
+
+// spiWrapper adapts the public SPI to the runtime and carries the
+// session context into every call.
+type spiWrapper struct {
+	spi     lifecycle
+	role    string
+	log     Logger
+	backend state.Backend
+	// changelog mirrors the session's state if it asked for it and
+	// changelogged is set.
+	changelog    state.Changelog
+	changelogged bool
+	sc           *SessionContext
+	// namespace is the session's state namespace and ephemeral whether
+	// it is dropped on Close, having been named after the session.
+	namespace string
+	ephemeral bool
+	// checkpoints is the store of the namespace's checkpoints, opened on
+	// first use.
+	checkpoints state.Store
+	exactlyOnce bool
+	// tracker records the changes to the session state since base, the
+	// last checkpoint the session took or restored; deltas is how many
+	// incremental checkpoints it took since its last full one.
+	tracker      *state.Tracker
+	base         string
+	deltas       int
+	fullInterval int
+}
+
+func (w *spiWrapper) Init(ctx context.Context, config []byte) error {
+	info, _ := session.InfoFromContext(ctx)
+	w.namespace = info.StateNamespace
+	if w.namespace == "" {
+		if info.RestoreCheckpoint != "" {
+			return ConfigError(errors.New("planx: restoring a checkpoint requires a state namespace"))
+		}
+		w.namespace, w.ephemeral = "session/"+info.ID, true
+	}
+	if w.exactlyOnce = info.ExactlyOnce; w.exactlyOnce {
+		if err := w.checkExactlyOnce(); err != nil {
+			return err
+		}
+	}
+	if w.changelogged = info.StateChangelog; w.changelogged {
+		if err := w.checkChangelog(); err != nil {
+			return err
+		}
+	}
+	local, err := w.backend.Open(w.namespace)
+	if err != nil {
+		return TransientError(err)
+	}
+	store := local
+	if w.changelogged {
+		store = state.Logged(local, w.namespace, w.changelog)
+	}
+	w.tracker, w.fullInterval = state.Track(store), info.CheckpointFullInterval
+	w.sc = newSessionContext(ctx, config, w.log, w.tracker)
+
+	// A session that fails to start, even by panicking, is never closed.
+	started := false
+	defer func() {
+		if !started {
+			w.closeState()
+		}
+	}()
+
+	if w.changelogged {
+		if err := w.rebuild(w.namespace, local); err != nil {
+			return err
+		}
+	}
+
+	// State is restored before Init, so the SPI starts from it, and the
+	// SPI's own checkpoint once it is initialized.
+	var cp *checkpoint
+	if info.RestoreCheckpoint != "" {
+		if cp, err = w.loadCheckpoint(info.RestoreCheckpoint); err != nil {
+			return err
+		}
+		if err := state.Load(store, cp.State); err != nil {
+			return TransientError(err)
+		}
+		w.base = info.RestoreCheckpoint
+	}
+	if err := w.spi.Init(w.ctx(ctx), config); err != nil {
+		return err
+	}
+	if err := w.restoreSPI(ctx, info.RestoreCheckpoint, cp); err != nil {
+		w.spi.Close()
+		return err
+	}
+	started = true
+	return nil
+}
+
+func (w *spiWrapper) Close() error {
+	return errors.Join(w.spi.Close(), w.closeState())
+}
+
+// HandOver keeps the session's state on Close, even if ephemeral: the
+// plugin process the session was handed over to restores it from there.
+func (w *spiWrapper) HandOver() {
+	w.ephemeral = false
+}
+
+func (w *spiWrapper) closeState() error {
+	err := w.sc.State.Close()
+	if w.checkpoints != nil {
+		err = errors.Join(err, w.checkpoints.Close())
+	}
+	if w.ephemeral {
+		err = errors.Join(err, w.backend.Drop(w.namespace))
+		if w.checkpoints != nil {
+			err = errors.Join(err, w.backend.Drop(checkpointNamespace(w.namespace)))
+		}
+	}
+	return err
+}
+
+func (w *spiWrapper) ctx(ctx context.Context) context.Context {
+	return withSession(ctx, w.sc)
+}
+
+func (w *spiWrapper) Flush(ctx context.Context) error {
+	if f, ok := w.spi.(Flusher); ok {
+		return f.Flush(w.ctx(ctx))
+	}
+	return errors.ErrUnsupported
+}
+
+func (w *spiWrapper) Drain(ctx context.Context) error {
+	if d, ok := w.spi.(Drainer); ok {
+		return d.Drain(w.ctx(ctx))
+	}
+	return errors.ErrUnsupported
+}
+
+func (w *spiWrapper) Checkpoint(ctx context.Context) ([]byte, error) {
+	if c, ok := w.spi.(Checkpointer); ok {
+		return c.Checkpoint(w.ctx(ctx))
+	}
+	return nil, errors.ErrUnsupported
+}
+
+func (w *spiWrapper) Restore(ctx context.Context, checkpoint []byte) error {
+	if c, ok := w.spi.(Checkpointer); ok {
+		return c.Restore(w.ctx(ctx), checkpoint)
+	}
+	return errors.ErrUnsupported
+}
+
+func (w *spiWrapper) OnCheckpointFailed(ctx context.Context, id string, err error, consecutive int) {
+	if h, ok := w.spi.(CheckpointFailureHandler); ok {
+		h.OnCheckpointFailed(w.ctx(ctx), CheckpointFailure{CheckpointID: id, Err: err, Consecutive: consecutive})
+	}
+}
+
+func (w *spiWrapper) Seek(ctx context.Context, position []byte) error {
+	if s, ok := w.spi.(Seeker); ok {
+		return s.Seek(w.ctx(ctx), position)
+	}
+	return errors.ErrUnsupported
+}
+
+func (w *spiWrapper) UpdateConfig(ctx context.Context, config []byte) error {
+	if u, ok := w.spi.(ConfigUpdater); ok {
+		if err := u.UpdateConfig(w.ctx(ctx), config); err != nil {
+			return err
+		}
+		w.sc.setConfig(config)
+		return nil
+	}
+	return errors.ErrUnsupported
+}
+
+type sourceWrapper struct {
+	spiWrapper
+	src SourceSPI
+}
+
+func newSourceWrapper(spi SourceSPI, log Logger, backend state.Backend, changelog state.Changelog) *sourceWrapper {
+	return &sourceWrapper{spiWrapper: spiWrapper{
+		spi: spi, role: runtime.RoleSource, log: log, backend: backend, changelog: changelog,
+	}, src: spi}
+}
+
+func (w *sourceWrapper) ReadBatch(ctx context.Context) (*Batch, error) {
+	return w.src.ReadBatch(w.ctx(ctx))
+}
+
+type sinkWrapper struct {
+	spiWrapper
+	sink SinkSPI
+}
+
+func newSinkWrapper(spi SinkSPI, log Logger, backend state.Backend, changelog state.Changelog) *sinkWrapper {
+	return &sinkWrapper{spiWrapper: spiWrapper{
+		spi: spi, role: runtime.RoleSink, log: log, backend: backend, changelog: changelog,
+	}, sink: spi}
+}
+
+func (w *sinkWrapper) WriteBatch(ctx context.Context, batch *Batch) error {
+	return w.sink.WriteBatch(w.ctx(ctx), batch)
+}
+
+type processorWrapper struct {
+	spiWrapper
+	proc ProcessorSPI
+}
+
+func newProcessorWrapper(spi ProcessorSPI, log Logger, backend state.Backend, changelog state.Changelog) *processorWrapper {
+	return &processorWrapper{spiWrapper: spiWrapper{
+		spi: spi, role: runtime.RoleProcessor, log: log, backend: backend, changelog: changelog,
+	}, proc: spi}
+}
+
+func (w *processorWrapper) Process(ctx context.Context, batch *Batch) (*Batch, error) {
+	return w.proc.Process(w.ctx(ctx), batch)
+}